@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/zjx20/littlefighterhub/internal/directory"
+	"github.com/zjx20/littlefighterhub/internal/logging"
+)
+
+func main() {
+	listen := flag.String("listen", ":8090", "Address to listen on")
+	ttl := flag.Duration("ttl", 90*time.Second, "Drop servers that have not sent a heartbeat for this long")
+	token := flag.String("register-token", "", "Secret servers must present to register; anyone may register when empty")
+	logLevel := flag.String("log-level", "info", "Log level: trace, debug, info, warn or error")
+	logFormat := flag.String("log-format", "text", "Log format: text or json")
+	flag.Parse()
+
+	if _, err := logging.Setup(os.Stderr, *logFormat, *logLevel); err != nil {
+		logging.Fatal("Invalid logging configuration", "err", err)
+	}
+	if *token == "" {
+		slog.Warn("No register token configured, anyone can register servers")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	reg := directory.NewRegistry(*ttl)
+	go func() {
+		ticker := time.NewTicker(*ttl / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			for _, s := range reg.Expire() {
+				slog.Info("Server expired", "name", s.Name, "address", s.Address, "last_seen", s.LastSeen)
+			}
+		}
+	}()
+
+	httpServer := &http.Server{Addr: *listen, Handler: reg.Handler(*token, slog.Default())}
+	go func() {
+		slog.Info("Hub directory started", "addr", *listen, "ttl", *ttl)
+		if err := httpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logging.Fatal("ListenAndServe failed", "err", err)
+		}
+	}()
+
+	<-ctx.Done()
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	httpServer.Shutdown(shutdownCtx)
+	slog.Info("Hub directory stopped")
+}
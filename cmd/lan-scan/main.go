@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/zjx20/littlefighterhub/internal/lan"
+	"github.com/zjx20/littlefighterhub/internal/logging"
+)
+
+func main() {
+	listen := flag.String("listen", net.JoinHostPort("", strconv.Itoa(lan.DefaultPort)),
+		"Address to listen on for beacons; use the group address, e.g. 239.255.76.70:47777, for multicast")
+	timeout := flag.Duration("timeout", lan.DefaultInterval+time.Second, "How long to listen")
+	asJSON := flag.Bool("json", false, "Print the servers as JSON")
+	flag.Parse()
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	found, err := lan.Scan(ctx, *listen)
+	if err != nil {
+		logging.Fatal("Scan failed", "err", err)
+	}
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(found)
+		return
+	}
+	if len(found) == 0 {
+		fmt.Println("No room servers found.")
+		return
+	}
+	lan.WriteTable(os.Stdout, found)
+}
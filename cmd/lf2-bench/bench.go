@@ -0,0 +1,252 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/zjx20/littlefighterhub/pkg/lf2client"
+	"github.com/zjx20/littlefighterhub/pkg/protocol"
+)
+
+const (
+	// sentRing is how many send times are remembered per player, indexed
+	// by FRAME seq. At 30 FRAMEs/s it covers more than two minutes.
+	sentRing = 4096
+	// How long a single FRAME write may take before it counts as failed.
+	writeTimeout = time.Second
+	// How long to wait for FRAMEs still in flight after the run.
+	drainWait = time.Second
+)
+
+type bench struct {
+	url      string
+	perRoom  int
+	clientCt int
+	tick     time.Duration
+	stats    *stats
+	scraper  *scraper
+	reporter *reporter
+
+	rooms []*benchRoom
+	// players maps player ids to players. It is complete once ready is
+	// closed.
+	players map[int]*player
+	ready   chan struct{}
+	wg      sync.WaitGroup
+}
+
+type benchRoom struct {
+	id int
+	// latency is the room latency; the first latency FRAMEs of every
+	// player are held back by the server until everyone is in sync, so
+	// they are left out of the latency figures.
+	latency int
+	players []*player
+}
+
+type player struct {
+	c       *lf2client.Client
+	room    *benchRoom
+	rng     *rand.Rand
+	keys    int
+	sentAt  [sentRing]atomic.Int64
+	started chan struct{}
+}
+
+// setup connects the players, fills the first vacant rooms and starts a
+// match in each of them.
+func (b *bench) setup(ctx context.Context) error {
+	b.players = make(map[int]*player)
+	b.ready = make(chan struct{})
+	if b.clientCt%b.perRoom != 0 {
+		return fmt.Errorf("%d players cannot be split into rooms of %d", b.clientCt, b.perRoom)
+	}
+
+	var all []*player
+	for i := 0; i < b.clientCt; i++ {
+		c, err := lf2client.Dial(ctx, b.url)
+		if err != nil {
+			return fmt.Errorf("connecting player %d: %w", i+1, err)
+		}
+		p := &player{
+			c:       c,
+			rng:     rand.New(rand.NewSource(time.Now().UnixNano() + int64(i))),
+			started: make(chan struct{}),
+		}
+		b.players[c.ID()] = p
+		all = append(all, p)
+		b.wg.Add(1)
+		go b.receive(p)
+	}
+
+	rooms, err := all[0].c.List(ctx)
+	if err != nil {
+		return fmt.Errorf("listing rooms: %w", err)
+	}
+	need := b.clientCt / b.perRoom
+	for _, r := range rooms {
+		if len(b.rooms) == need {
+			break
+		}
+		if r.State == "VACANT" {
+			b.rooms = append(b.rooms, &benchRoom{id: r.ID})
+		}
+	}
+	if len(b.rooms) < need {
+		return fmt.Errorf("need %d vacant rooms, the server has %d", need, len(b.rooms))
+	}
+
+	for i, p := range all {
+		r := b.rooms[i/b.perRoom]
+		p.room = r
+		r.players = append(r.players, p)
+		joinCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		list, err := p.c.Join(joinCtx, protocol.Join{
+			RoomID:   r.id,
+			Name:     fmt.Sprintf("bench%d", p.c.ID()),
+			Controls: [4]string{fmt.Sprintf("bench%d", p.c.ID())},
+		})
+		cancel()
+		if errors.Is(err, context.DeadlineExceeded) {
+			return fmt.Errorf("room %d did not accept player %d; is -per-room above the room capacity?", r.id, p.c.ID())
+		}
+		if err != nil {
+			return fmt.Errorf("joining room %d: %w", r.id, err)
+		}
+		r.latency = list.Latency
+	}
+	close(b.ready)
+
+	for _, r := range b.rooms {
+		if err := r.players[0].c.Start(ctx); err != nil {
+			return fmt.Errorf("starting room %d: %w", r.id, err)
+		}
+	}
+	for _, p := range all {
+		select {
+		case <-p.started:
+		case <-ctx.Done():
+			return fmt.Errorf("waiting for ROOM_NOW_STARTED: %w", ctx.Err())
+		}
+	}
+	return nil
+}
+
+// receive consumes the events of a player, timing the FRAMEs relayed to it.
+func (b *bench) receive(p *player) {
+	defer b.wg.Done()
+	for ev := range p.c.Events() {
+		switch m := ev.Message.(type) {
+		case *protocol.RoomNowStarted:
+			select {
+			case <-p.started:
+			default:
+				close(p.started)
+			}
+		case *protocol.Frame:
+			<-b.ready
+			sender, ok := b.players[m.PlayerID]
+			if !ok {
+				continue
+			}
+			b.stats.received()
+			if m.Seq < p.room.latency {
+				continue
+			}
+			if sent := sender.sentAt[m.Seq%sentRing].Load(); sent != 0 {
+				b.stats.latency(ev.Received.Sub(time.Unix(0, sent)))
+			}
+		}
+	}
+	if err := p.c.Err(); err != nil && !errors.Is(err, lf2client.ErrClosed) {
+		b.stats.disconnected()
+	}
+}
+
+// run sends FRAMEs until ctx is done, printing a report every interval.
+func (b *bench) run(ctx context.Context, interval time.Duration) {
+	var senders sync.WaitGroup
+	for _, p := range b.players {
+		senders.Add(1)
+		go func(p *player) {
+			defer senders.Done()
+			b.send(ctx, p)
+		}(p)
+	}
+
+	start := time.Now()
+	r := newReporter(b.stats, b.scraper)
+	b.reporter = r
+	r.header()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for done := false; !done; {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			done = true
+		}
+		r.line(time.Since(start))
+	}
+	senders.Wait()
+	time.Sleep(drainWait)
+}
+
+// send is the game loop of a player: one FRAME per tick, with the keys
+// changing now and then like a player's would.
+func (b *bench) send(ctx context.Context, p *player) {
+	// Spread the players over the tick so they do not all send at once.
+	select {
+	case <-time.After(time.Duration(p.rng.Int63n(int64(b.tick)))):
+	case <-ctx.Done():
+		return
+	}
+	ticker := time.NewTicker(b.tick)
+	defer ticker.Stop()
+	for seq := 0; ; seq++ {
+		if p.rng.Intn(5) == 0 {
+			// Up, down, left, right, attack, jump and defend.
+			p.keys = p.rng.Intn(1 << 7)
+		}
+		frame := protocol.Frame{
+			Seq:      seq,
+			Keys:     [4]int{p.keys},
+			Checksum: (seq * 2654435761) & 0xffff,
+		}
+		p.sentAt[seq%sentRing].Store(time.Now().UnixNano())
+		// The write is not bound to ctx, so the end of the run does not
+		// cut a FRAME short.
+		wctx, cancel := context.WithTimeout(context.Background(), writeTimeout)
+		err := p.c.SendFrame(wctx, frame)
+		cancel()
+		if err != nil {
+			b.stats.writeFailed()
+		} else {
+			b.stats.sent(len(p.room.players) - 1)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// close disconnects every player and waits for their receivers.
+func (b *bench) close() {
+	select {
+	case <-b.ready:
+	default:
+		close(b.ready)
+	}
+	for _, p := range b.players {
+		p.c.Close()
+	}
+	b.wg.Wait()
+}
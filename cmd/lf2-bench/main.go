@@ -0,0 +1,84 @@
+// Command lf2-bench load-tests a room server. It fills rooms with simulated
+// players, starts a match in each and sends FRAMEs at the game's tick rate,
+// reporting relay latency, throughput, failures and the server's resource
+// usage as it goes.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"github.com/zjx20/littlefighterhub/internal/logging"
+)
+
+// LF2 runs at about 30 frames per second.
+const defaultTickRate = 30
+
+func main() {
+	serverURL := flag.String("server", "ws://127.0.0.1:8080/", "WebSocket URL of the room server")
+	clients := flag.Int("clients", 16, "Number of simulated players")
+	perRoom := flag.Int("per-room", 2, "Players per room; the rooms used are the first vacant ones")
+	tick := flag.Float64("tick", defaultTickRate, "FRAMEs sent per second by every player")
+	duration := flag.Duration("duration", 30*time.Second, "How long the matches run")
+	interval := flag.Duration("interval", 5*time.Second, "How often to print a report")
+	metricsURL := flag.String("metrics", "", "Metrics URL of the server for CPU and memory; derived from -server when empty, \"off\" to disable")
+	flag.Parse()
+
+	if *perRoom < 2 {
+		logging.Fatal("-per-room must be at least 2, a single player has nobody to relay to")
+	}
+	if *clients < *perRoom || *tick <= 0 {
+		logging.Fatal("-clients must be at least -per-room and -tick positive")
+	}
+	if *metricsURL == "" {
+		*metricsURL = deriveMetricsURL(*serverURL)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	b := &bench{
+		url:      *serverURL,
+		perRoom:  *perRoom,
+		tick:     time.Duration(float64(time.Second) / *tick),
+		stats:    &stats{},
+		clientCt: *clients,
+	}
+	if *metricsURL != "off" {
+		b.scraper = &scraper{url: *metricsURL}
+	}
+
+	setupCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	err := b.setup(setupCtx)
+	cancel()
+	if err != nil {
+		b.close()
+		logging.Fatal("Setup failed", "err", err)
+	}
+	fmt.Printf("%d players in %d rooms, %.0f FRAMEs/s each, running for %s\n",
+		*clients, len(b.rooms), *tick, *duration)
+
+	runCtx, cancel := context.WithTimeout(ctx, *duration)
+	defer cancel()
+	b.run(runCtx, *interval)
+	b.close()
+	b.summary(os.Stdout)
+}
+
+// deriveMetricsURL turns ws://host:port/... into http://host:port/metrics.
+func deriveMetricsURL(serverURL string) string {
+	u, err := url.Parse(serverURL)
+	if err != nil {
+		return "off"
+	}
+	u.Scheme = strings.Replace(u.Scheme, "ws", "http", 1)
+	u.Path = "/metrics"
+	u.RawQuery = ""
+	return u.String()
+}
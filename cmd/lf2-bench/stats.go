@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// counts are the figures collected over a period.
+type counts struct {
+	sent, expected, received int64
+	writeErrors, disconnects int64
+	latencies                []time.Duration
+}
+
+// stats collects the figures of the current report interval and of the
+// whole run.
+type stats struct {
+	mu       sync.Mutex
+	interval counts
+	total    counts
+}
+
+// sent counts a FRAME that fanout players should receive.
+func (s *stats) sent(fanout int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.interval.sent++
+	s.interval.expected += int64(fanout)
+}
+
+func (s *stats) received() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.interval.received++
+}
+
+func (s *stats) latency(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.interval.latencies = append(s.interval.latencies, d)
+}
+
+func (s *stats) writeFailed() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.interval.writeErrors++
+}
+
+func (s *stats) disconnected() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.interval.disconnects++
+}
+
+// flush returns the figures of the interval and adds them to the total.
+func (s *stats) flush() counts {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c := s.interval
+	s.interval = counts{}
+	s.total.sent += c.sent
+	s.total.expected += c.expected
+	s.total.received += c.received
+	s.total.writeErrors += c.writeErrors
+	s.total.disconnects += c.disconnects
+	s.total.latencies = append(s.total.latencies, c.latencies...)
+	return c
+}
+
+// percentiles returns the given percentiles of the latencies, sorting them.
+func percentiles(latencies []time.Duration, ps ...float64) []time.Duration {
+	out := make([]time.Duration, len(ps))
+	if len(latencies) == 0 {
+		return out
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	for i, p := range ps {
+		n := int(p / 100 * float64(len(latencies)-1))
+		out[i] = latencies[n]
+	}
+	return out
+}
+
+// serverSample is the resource usage of the server at one point in time.
+type serverSample struct {
+	at         time.Time
+	cpuSeconds float64
+	rssBytes   float64
+	goroutines float64
+}
+
+// scraper reads the process metrics of the room server.
+type scraper struct {
+	url  string
+	last serverSample
+	// err is the most recent scrape error, reported once.
+	err error
+}
+
+func (s *scraper) scrape() (serverSample, error) {
+	client := http.Client{Timeout: 2 * time.Second}
+	resp, err := client.Get(s.url)
+	if err != nil {
+		return serverSample{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return serverSample{}, fmt.Errorf("%s: %s", s.url, resp.Status)
+	}
+	values := parseMetrics(resp.Body)
+	sample := serverSample{
+		at:         time.Now(),
+		cpuSeconds: values["process_cpu_seconds_total"],
+		rssBytes:   values["process_resident_memory_bytes"],
+		goroutines: values["go_goroutines"],
+	}
+	if sample.rssBytes == 0 {
+		sample.rssBytes = values["go_memstats_sys_bytes"]
+	}
+	return sample, nil
+}
+
+// parseMetrics reads the unlabelled samples of a Prometheus text exposition.
+func parseMetrics(r io.Reader) map[string]float64 {
+	values := make(map[string]float64)
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		line := sc.Text()
+		if strings.HasPrefix(line, "#") || strings.Contains(line, "{") {
+			continue
+		}
+		name, value, ok := strings.Cut(line, " ")
+		if !ok {
+			continue
+		}
+		if v, err := strconv.ParseFloat(value, 64); err == nil {
+			values[name] = v
+		}
+	}
+	return values
+}
+
+// reporter prints a line per interval and the final summary.
+type reporter struct {
+	stats   *stats
+	scraper *scraper
+	last    time.Duration
+	// cpu holds the server CPU usage of every interval, in percent.
+	cpu     []float64
+	peakRSS float64
+	elapsed time.Duration
+}
+
+func newReporter(s *stats, sc *scraper) *reporter {
+	r := &reporter{stats: s, scraper: sc}
+	if sc != nil {
+		sc.last, sc.err = sc.scrape()
+		if sc.err != nil {
+			fmt.Printf("Server metrics unavailable: %v\n", sc.err)
+		}
+	}
+	return r
+}
+
+func (r *reporter) header() {
+	fmt.Printf("%7s %9s %9s %8s %8s %8s %8s %6s %5s %8s %9s %6s\n",
+		"TIME", "SENT/S", "RECV/S", "P50", "P90", "P99", "MAX", "WERR", "DISC", "SRV CPU", "SRV MEM", "GORTN")
+}
+
+func (r *reporter) line(elapsed time.Duration) {
+	c := r.stats.flush()
+	secs := (elapsed - r.last).Seconds()
+	r.last = elapsed
+	r.elapsed = elapsed
+	if secs <= 0 {
+		return
+	}
+	p := percentiles(c.latencies, 50, 90, 99, 100)
+	cpu, mem, gor := "-", "-", "-"
+	if r.scraper != nil {
+		if sample, err := r.scraper.scrape(); err == nil {
+			if prev := r.scraper.last; !prev.at.IsZero() && sample.at.After(prev.at) {
+				usage := (sample.cpuSeconds - prev.cpuSeconds) / sample.at.Sub(prev.at).Seconds() * 100
+				r.cpu = append(r.cpu, usage)
+				cpu = fmt.Sprintf("%.1f%%", usage)
+			}
+			if sample.rssBytes > r.peakRSS {
+				r.peakRSS = sample.rssBytes
+			}
+			mem = fmt.Sprintf("%.1fMB", sample.rssBytes/1e6)
+			gor = strconv.Itoa(int(sample.goroutines))
+			r.scraper.last = sample
+		} else if r.scraper.err == nil {
+			r.scraper.err = err
+			fmt.Printf("Scraping server metrics failed: %v\n", err)
+		}
+	}
+	fmt.Printf("%7s %9.0f %9.0f %8s %8s %8s %8s %6d %5d %8s %9s %6s\n",
+		elapsed.Round(time.Second), float64(c.sent)/secs, float64(c.received)/secs,
+		ms(p[0]), ms(p[1]), ms(p[2]), ms(p[3]), c.writeErrors, c.disconnects, cpu, mem, gor)
+}
+
+// summary prints the figures of the whole run.
+func (b *bench) summary(w io.Writer) {
+	// Count what arrived while draining.
+	b.stats.flush()
+	t := b.stats.total
+	secs := b.reporter.elapsed.Seconds()
+	fmt.Fprintln(w)
+	fmt.Fprintf(w, "FRAMEs sent:     %d (%.0f/s)\n", t.sent, float64(t.sent)/secs)
+	fmt.Fprintf(w, "FRAMEs received: %d (%.0f/s)\n", t.received, float64(t.received)/secs)
+	lost := t.expected - t.received
+	if lost < 0 {
+		lost = 0
+	}
+	lostPct := 0.0
+	if t.expected > 0 {
+		lostPct = float64(lost) / float64(t.expected) * 100
+	}
+	fmt.Fprintf(w, "FRAMEs lost:     %d (%.2f%%)\n", lost, lostPct)
+	fmt.Fprintf(w, "Write errors:    %d\n", t.writeErrors)
+	fmt.Fprintf(w, "Disconnects:     %d\n", t.disconnects)
+	p := percentiles(t.latencies, 50, 90, 99, 99.9, 100)
+	fmt.Fprintf(w, "Relay latency:   p50 %s  p90 %s  p99 %s  p99.9 %s  max %s\n",
+		ms(p[0]), ms(p[1]), ms(p[2]), ms(p[3]), ms(p[4]))
+	if r := b.reporter; len(r.cpu) > 0 {
+		var sum, peak float64
+		for _, c := range r.cpu {
+			sum += c
+			if c > peak {
+				peak = c
+			}
+		}
+		fmt.Fprintf(w, "Server CPU:      avg %.1f%%  peak %.1f%%\n", sum/float64(len(r.cpu)), peak)
+		fmt.Fprintf(w, "Server memory:   peak %.1fMB\n", r.peakRSS/1e6)
+	}
+}
+
+func ms(d time.Duration) string {
+	return fmt.Sprintf("%.2fms", float64(d)/float64(time.Millisecond))
+}
@@ -0,0 +1,66 @@
+// Command lf2-bot connects bot players to a room server, for filling seats
+// or trying out a match alone. The bots follow the room like a player would
+// and send FRAMEs from their script once the owner starts the match.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+
+	"github.com/zjx20/littlefighterhub/internal/bot"
+	"github.com/zjx20/littlefighterhub/internal/logging"
+	"github.com/zjx20/littlefighterhub/pkg/lf2client"
+)
+
+func main() {
+	serverURL := flag.String("server", "ws://127.0.0.1:8080/", "WebSocket URL of the room server")
+	roomID := flag.Int("room", 1, "Room to join")
+	count := flag.Int("count", 1, "Number of bots")
+	script := flag.String("script", bot.ScriptIdle, "Input script: idle, random or replay")
+	replay := flag.String("replay", "", "Room transcript to replay with -script replay")
+	player := flag.Int("player", 0, "Player ID to replay from the transcript; the first player when 0")
+	name := flag.String("name", "Bot", "Name prefix of the bots")
+	flag.Parse()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	var wg sync.WaitGroup
+	for i := 1; i <= *count; i++ {
+		s, err := newScript(*script, *replay, *player)
+		if err != nil {
+			logging.Fatal("Invalid script", "err", err)
+		}
+		c, err := lf2client.Dial(ctx, *serverURL)
+		if err != nil {
+			logging.Fatal("Connecting failed", "err", err)
+		}
+		b := &bot.Bot{Name: fmt.Sprintf("%s%d", *name, i), Script: s}
+		if err := b.Join(ctx, c, *roomID); err != nil {
+			c.Close()
+			logging.Fatal("Joining failed", "bot", b.Name, "err", err)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer c.Close()
+			if err := b.Play(ctx, c, *roomID); err != nil && !errors.Is(err, lf2client.ErrClosed) {
+				logging.Fatal("Bot stopped", "bot", b.Name, "err", err)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// newScript is bot.NewScript with the choice of player to replay.
+func newScript(name, replayFile string, playerID int) (bot.Script, error) {
+	if name == bot.ScriptReplay && replayFile != "" {
+		return bot.LoadReplayFile(replayFile, playerID)
+	}
+	return bot.NewScript(name, replayFile)
+}
@@ -0,0 +1,77 @@
+// Command lf2-replay checks the room server against the golden transcripts
+// in tests/golden. Each transcript is a capture of client sessions, as
+// recorded by lf2-sniff; its client messages are replayed with their timing
+// against a fresh in-process server and the replies must match the recorded
+// ones. It exits with status 1 when any transcript differs. go test runs
+// the same check over tests/golden as TestGolden in internal/replay.
+//
+// After an intended change of behaviour, rewrite the transcripts with
+// -update and review the result with git diff.
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"time"
+
+	"github.com/zjx20/littlefighterhub/internal/capture"
+	"github.com/zjx20/littlefighterhub/internal/logging"
+	"github.com/zjx20/littlefighterhub/internal/replay"
+)
+
+func main() {
+	dir := flag.String("dir", filepath.Join("tests", "golden"), "Directory of golden transcripts, used when no files are given")
+	update := flag.Bool("update", false, "Rewrite the transcripts with the server's current replies")
+	speed := flag.Float64("speed", 1, "Replay speed relative to the recording")
+	wait := flag.Duration("wait", 500*time.Millisecond, "How long to wait for replies after the last message")
+	frames := flag.Bool("frames", true, "Compare relayed FRAMEs too")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: lf2-replay [flags] [transcript ...]\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	files := flag.Args()
+	if len(files) == 0 {
+		var err error
+		if files, err = replay.Files(*dir); err != nil {
+			logging.Fatal("Failed to list transcripts", "err", err)
+		}
+		if len(files) == 0 {
+			logging.Fatal("No transcripts found", "dir", *dir)
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	opts := replay.Options{
+		Replay: capture.ReplayOptions{Speed: *speed, Wait: *wait},
+		Diff:   capture.DiffOptions{Frames: *frames},
+		Update: *update,
+	}
+	failed := 0
+	for _, file := range files {
+		var diff bytes.Buffer
+		ok, err := replay.Check(ctx, &diff, file, opts)
+		switch {
+		case err != nil:
+			logging.Fatal("Replay failed", "err", err)
+		case *update:
+			fmt.Printf("UPDATED %s\n", file)
+		case ok:
+			fmt.Printf("PASS %s\n", file)
+		default:
+			fmt.Printf("FAIL %s\n%s", file, diff.Bytes())
+			failed++
+		}
+	}
+	if failed > 0 {
+		fmt.Printf("%d of %d transcripts differ\n", failed, len(files))
+		os.Exit(1)
+	}
+}
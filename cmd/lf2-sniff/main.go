@@ -0,0 +1,99 @@
+// Command lf2-sniff records the traffic between LF2 clients and a room
+// server, for working out how the original lf2-room-server behaves.
+//
+// Run as a proxy, it sits between the game and the server and appends every
+// message to a capture file:
+//
+//	lf2-sniff -listen :8081 -upstream ws://127.0.0.1:8080/ -out capture.jsonl
+//
+// The diff subcommand replays the clients of a capture against another
+// server and shows where the replies differ:
+//
+//	lf2-sniff diff -server ws://127.0.0.1:8090/ capture.jsonl
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/zjx20/littlefighterhub/internal/capture"
+	"github.com/zjx20/littlefighterhub/internal/logging"
+)
+
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "diff" {
+		runDiff(os.Args[2:])
+		return
+	}
+
+	listen := flag.String("listen", ":8081", "Address the game connects to")
+	upstream := flag.String("upstream", "ws://127.0.0.1:8080/", "WebSocket URL of the room server to record")
+	out := flag.String("out", "capture.jsonl", "Capture file to append to")
+	flag.Parse()
+
+	f, err := os.OpenFile(*out, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		logging.Fatal("Failed to open capture file", "err", err)
+	}
+	defer f.Close()
+
+	proxy := &capture.Proxy{Upstream: *upstream, Capture: capture.NewWriter(f)}
+	srv := &http.Server{Addr: *listen, Handler: proxy}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+
+	fmt.Fprintf(os.Stderr, "Recording %s to %s, point the game at %s\n", *upstream, *out, *listen)
+	if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+		logging.Fatal("Proxy failed", "err", err)
+	}
+}
+
+// runDiff implements "lf2-sniff diff".
+func runDiff(args []string) {
+	set := flag.NewFlagSet("diff", flag.ExitOnError)
+	server := set.String("server", "ws://127.0.0.1:8080/", "WebSocket URL of the room server to replay the capture against")
+	speed := set.Float64("speed", 1, "Replay speed relative to the capture")
+	wait := set.Duration("wait", 2*time.Second, "How long to wait for replies after the last message")
+	frames := set.Bool("frames", false, "Compare relayed FRAMEs too")
+	out := set.String("out", "", "File to write the capture of the replay to")
+	set.Usage = func() {
+		fmt.Fprintf(set.Output(), "Usage: lf2-sniff diff [flags] <capture file>\n")
+		set.PrintDefaults()
+	}
+	set.Parse(args)
+	if set.NArg() != 1 {
+		set.Usage()
+		os.Exit(2)
+	}
+
+	want, err := capture.ReadFile(set.Arg(0))
+	if err != nil {
+		logging.Fatal("Failed to read capture file", "err", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	got, err := capture.Replay(ctx, *server, want, capture.ReplayOptions{Speed: *speed, Wait: *wait})
+	if err != nil {
+		logging.Fatal("Replay failed", "err", err)
+	}
+	if *out != "" {
+		if err := capture.WriteFile(*out, got); err != nil {
+			logging.Fatal("Failed to write replay capture", "err", err)
+		}
+	}
+
+	if capture.Diff(os.Stdout, want, got, capture.DiffOptions{Frames: *frames}) {
+		os.Exit(1)
+	}
+	fmt.Println("No differences.")
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/zjx20/littlefighterhub/internal/directory"
+	"github.com/zjx20/littlefighterhub/internal/lan"
+	"github.com/zjx20/littlefighterhub/internal/logging"
+)
+
+// How long to wait for the hub directory.
+const directoryTimeout = 10 * time.Second
+
+// listServers prints the servers known to the directory.
+func listServers(client *directory.Client, region string) {
+	ctx, cancel := context.WithTimeout(context.Background(), directoryTimeout)
+	defer cancel()
+	servers, err := client.List(ctx, directory.Filter{Region: region})
+	if err != nil {
+		logging.Fatal("Failed to query the hub directory", "err", err)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "KIND\tNAME\tREGION\tADDRESS\tROOMS\tPLAYERS")
+	for _, s := range servers {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d/%d\t%d\n", s.Kind, s.Name, s.Region, s.Address, s.ActiveRooms, s.Rooms, s.Players)
+	}
+	w.Flush()
+}
+
+// scanLAN prints the room servers announcing themselves on the LAN.
+func scanLAN() {
+	ctx, cancel := context.WithTimeout(context.Background(), lan.DefaultInterval+time.Second)
+	defer cancel()
+	found, err := lan.Scan(ctx, fmt.Sprintf(":%d", lan.DefaultPort))
+	if err != nil {
+		logging.Fatal("LAN scan failed", "err", err)
+	}
+	if len(found) == 0 {
+		fmt.Println("No room servers found on the LAN.")
+		return
+	}
+	lan.WriteTable(os.Stdout, found)
+}
+
+// pickProxy returns the proxy server with the fewest active tunnels.
+func pickProxy(client *directory.Client, region string) (directory.Server, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), directoryTimeout)
+	defer cancel()
+	servers, err := client.List(ctx, directory.Filter{Kind: directory.KindProxy, Region: region})
+	if err != nil {
+		return directory.Server{}, err
+	}
+	if len(servers) == 0 {
+		return directory.Server{}, fmt.Errorf("no proxy server is online")
+	}
+	best := servers[0]
+	for _, s := range servers[1:] {
+		if s.Players < best.Players {
+			best = s
+		}
+	}
+	return best, nil
+}
@@ -3,13 +3,17 @@ package main
 import (
 	"encoding/json"
 	"flag"
-	"log"
+	"log/slog"
 	"net"
 	"net/url"
+	"os"
 	"sync"
 	"time"
 
 	"github.com/gorilla/websocket"
+
+	"github.com/zjx20/littlefighterhub/internal/directory"
+	"github.com/zjx20/littlefighterhub/internal/logging"
 )
 
 // Message represents control messages between client and server.
@@ -29,21 +33,56 @@ func main() {
 	gameAddr := flag.String("game", "localhost:8080", "Game server address (for host mode)")
 	localAddr := flag.String("local", "localhost:8081", "Local address for game client to connect (for peer mode)")
 	roomID := flag.String("room", "default", "Room ID to join")
+	logLevel := flag.String("log-level", "info", "Log level: trace, debug, info, warn or error")
+	logFormat := flag.String("log-format", "text", "Log format: text or json")
+	dirURL := flag.String("directory", "", "Hub directory URL; picks the least busy proxy server unless -server is given")
+	region := flag.String("region", "", "Only consider proxy servers in this region when using -directory")
+	list := flag.Bool("list", false, "List the servers in the hub directory and exit")
+	lanScan := flag.Bool("lan-scan", false, "List the room servers announcing themselves on the LAN and exit")
 	flag.Parse()
 
-	log.Printf("Starting proxy client in %s mode for room '%s'", *mode, *roomID)
+	if _, err := logging.Setup(os.Stderr, *logFormat, *logLevel); err != nil {
+		logging.Fatal("Invalid logging configuration", "err", err)
+	}
+
+	if *lanScan {
+		scanLAN()
+		return
+	}
+
+	if *dirURL != "" {
+		client := &directory.Client{URL: *dirURL}
+		if *list {
+			listServers(client, *region)
+			return
+		}
+		serverSet := false
+		flag.Visit(func(f *flag.Flag) { serverSet = serverSet || f.Name == "server" })
+		if !serverSet {
+			picked, err := pickProxy(client, *region)
+			if err != nil {
+				logging.Fatal("Failed to pick a proxy server", "directory", *dirURL, "err", err)
+			}
+			slog.Info("Picked proxy server", "name", picked.Name, "address", picked.Address, "region", picked.Region)
+			*serverAddr = picked.Address
+		}
+	} else if *list {
+		logging.Fatal("-list requires -directory")
+	}
+
+	slog.Info("Starting proxy client", "mode", *mode, "room_id", *roomID)
 
 	// Parse the server address
 	u, err := url.Parse(*serverAddr)
 	if err != nil {
-		log.Fatalf("Invalid server URL: %v", err)
+		logging.Fatal("Invalid server URL", "err", err)
 	}
 
 	// If scheme is missing, prepend ws:// and re-parse
 	if u.Scheme == "" {
 		u, err = url.Parse("ws://" + *serverAddr)
 		if err != nil {
-			log.Fatalf("Invalid server URL: %v", err)
+			logging.Fatal("Invalid server URL", "err", err)
 		}
 	}
 
@@ -81,12 +120,12 @@ const (
 // runHostMode runs the client that connects to the game server with auto-reconnect.
 func runHostMode(u url.URL, gameAddr string) {
 	for {
-		log.Printf("Running in host mode. Attempting control connection to %s", u.String())
+		slog.Info("Running in host mode, attempting control connection", "url", u.String())
 
 		// Establish the main control connection
 		controlConn, _, err := websocket.DefaultDialer.Dial(u.String(), nil)
 		if err != nil {
-			log.Printf("Failed to establish control connection: %v. Retrying in 5 seconds...", err)
+			slog.Warn("Failed to establish control connection, retrying in 5 seconds", "err", err)
 			time.Sleep(5 * time.Second)
 			continue
 		}
@@ -94,21 +133,21 @@ func runHostMode(u url.URL, gameAddr string) {
 		// Register as host
 		registerMsg, _ := json.Marshal(Message{Type: "register_host"})
 		if err := controlConn.WriteMessage(websocket.TextMessage, registerMsg); err != nil {
-			log.Printf("Failed to register as host: %v. Retrying in 5 seconds...", err)
+			slog.Warn("Failed to register as host, retrying in 5 seconds", "err", err)
 			controlConn.Close()
 			time.Sleep(5 * time.Second)
 			continue
 		}
-		log.Println("Registered as host. Waiting for new peer notifications...")
+		slog.Info("Registered as host, waiting for new peer notifications")
 
 		// Listen for new peer notifications
 		err = listenForPeers(controlConn, u, gameAddr)
 		controlConn.Close() // Ensure connection is closed on loop exit
 
 		if err != nil {
-			log.Printf("Control connection lost: %v. Reconnecting...", err)
+			slog.Warn("Control connection lost, reconnecting", "err", err)
 		} else {
-			log.Println("Control connection closed gracefully. Reconnecting...")
+			slog.Info("Control connection closed gracefully, reconnecting")
 		}
 		time.Sleep(5 * time.Second)
 	}
@@ -124,7 +163,7 @@ func listenForPeers(controlConn *websocket.Conn, u url.URL, gameAddr string) err
 
 		var msg Message
 		if err := json.Unmarshal(p, &msg); err != nil {
-			log.Printf("Error unmarshaling control message: %v", err)
+			slog.Warn("Error unmarshaling control message", "err", err)
 			continue
 		}
 
@@ -132,17 +171,17 @@ func listenForPeers(controlConn *websocket.Conn, u url.URL, gameAddr string) err
 		case "new_peer":
 			var payload NewPeerPayload
 			if err := json.Unmarshal(msg.Payload, &payload); err != nil {
-				log.Printf("Error unmarshaling new_peer payload: %v", err)
+				slog.Warn("Error unmarshaling new_peer payload", "err", err)
 				continue
 			}
-			log.Printf("Received notification for new peer: %s", payload.PeerID)
+			slog.Info("Received notification for new peer", "peer_id", payload.PeerID)
 			go handlePeerForHost(u, gameAddr, payload.PeerID)
 		case "ping":
 			// Respond to server's ping
 			pongMsg, _ := json.Marshal(Message{Type: "pong"})
 			controlConn.SetWriteDeadline(time.Now().Add(writeWait))
 			if err := controlConn.WriteMessage(websocket.TextMessage, pongMsg); err != nil {
-				log.Printf("Error sending pong: %v", err)
+				slog.Warn("Error sending pong", "err", err)
 				return err
 			}
 		}
@@ -151,11 +190,11 @@ func listenForPeers(controlConn *websocket.Conn, u url.URL, gameAddr string) err
 
 // handlePeerForHost creates a new data connection for a specific peer.
 func handlePeerForHost(u url.URL, gameAddr, peerID string) {
-	log.Printf("[%s] Creating data channel...", peerID)
+	slog.Info("Creating data channel", "peer_id", peerID)
 	// 1. Establish a new data websocket connection
 	dataConn, _, err := websocket.DefaultDialer.Dial(u.String(), nil)
 	if err != nil {
-		log.Printf("[%s] Failed to create data connection: %v", peerID, err)
+		slog.Warn("Failed to create data connection", "peer_id", peerID, "err", err)
 		return
 	}
 	defer dataConn.Close()
@@ -164,19 +203,19 @@ func handlePeerForHost(u url.URL, gameAddr, peerID string) {
 	payload, _ := json.Marshal(NewPeerPayload{PeerID: peerID})
 	msg, _ := json.Marshal(Message{Type: "data_conn", Payload: payload})
 	if err := dataConn.WriteMessage(websocket.TextMessage, msg); err != nil {
-		log.Printf("[%s] Failed to send data_conn message: %v", peerID, err)
+		slog.Warn("Failed to send data_conn message", "peer_id", peerID, "err", err)
 		return
 	}
 
 	// 3. Connect to the local game server
 	gameConn, err := net.Dial("tcp", gameAddr)
 	if err != nil {
-		log.Printf("[%s] Failed to connect to local game server at %s: %v", peerID, gameAddr, err)
+		slog.Warn("Failed to connect to local game server", "peer_id", peerID, "addr", gameAddr, "err", err)
 		return
 	}
 	defer gameConn.Close()
 
-	log.Printf("[%s] Data channel established. Forwarding data.", peerID)
+	slog.Info("Data channel established, forwarding data", "peer_id", peerID)
 	// 4. Forward data
 	var wg sync.WaitGroup
 	var writeMutex sync.Mutex
@@ -184,25 +223,25 @@ func handlePeerForHost(u url.URL, gameAddr, peerID string) {
 	go forwardToWS(gameConn, dataConn, &wg, &writeMutex)
 	go forwardToTCP(dataConn, gameConn, &wg, &writeMutex)
 	wg.Wait()
-	log.Printf("[%s] Data channel closed.", peerID)
+	slog.Info("Data channel closed", "peer_id", peerID)
 }
 
 // runPeerMode runs the client that the game client connects to.
 func runPeerMode(u url.URL, localAddr string) {
-	log.Printf("Running in peer mode, listening for game client on %s", localAddr)
+	slog.Info("Running in peer mode, listening for game client", "addr", localAddr)
 	listener, err := net.Listen("tcp", localAddr)
 	if err != nil {
-		log.Fatalf("Failed to listen on %s: %v", localAddr, err)
+		logging.Fatal("Failed to listen", "addr", localAddr, "err", err)
 	}
 	defer listener.Close()
 
 	for {
 		gameConn, err := listener.Accept()
 		if err != nil {
-			log.Printf("Failed to accept game connection: %v", err)
+			slog.Warn("Failed to accept game connection", "err", err)
 			continue
 		}
-		log.Printf("Accepted connection from game client: %s", gameConn.RemoteAddr())
+		slog.Info("Accepted connection from game client", "remote_addr", gameConn.RemoteAddr().String())
 		go handleGameConnectionForPeer(gameConn, u)
 	}
 }
@@ -210,15 +249,15 @@ func runPeerMode(u url.URL, localAddr string) {
 func handleGameConnectionForPeer(gameConn net.Conn, u url.URL) {
 	defer gameConn.Close()
 
-	log.Printf("Connecting to proxy server %s", u.String())
+	slog.Info("Connecting to proxy server", "url", u.String())
 	wsConn, _, err := websocket.DefaultDialer.Dial(u.String(), nil)
 	if err != nil {
-		log.Printf("Failed to dial proxy server: %v", err)
+		slog.Warn("Failed to dial proxy server", "err", err)
 		return
 	}
 	defer wsConn.Close()
 
-	log.Printf("Successfully connected to proxy server.")
+	slog.Info("Connected to proxy server")
 
 	var wg sync.WaitGroup
 	var writeMutex sync.Mutex
@@ -226,7 +265,7 @@ func handleGameConnectionForPeer(gameConn net.Conn, u url.URL) {
 	go forwardToWS(gameConn, wsConn, &wg, &writeMutex)
 	go forwardToTCP(wsConn, gameConn, &wg, &writeMutex)
 	wg.Wait()
-	log.Println("Connection closed.")
+	slog.Info("Connection closed", "remote_addr", gameConn.RemoteAddr().String())
 }
 
 func forwardToWS(src net.Conn, dst *websocket.Conn, wg *sync.WaitGroup, writeMutex *sync.Mutex) {
@@ -253,14 +292,14 @@ func forwardToTCP(src *websocket.Conn, dst net.Conn, wg *sync.WaitGroup, writeMu
 		src.SetReadDeadline(time.Now().Add(pongWait))
 		msgType, p, err := src.ReadMessage()
 		if err != nil {
-			log.Printf("forwardToTCP: read error: %v", err)
+			slog.Info("forwardToTCP: read error", "direction", "ws_to_tcp", "err", err)
 			dst.Close()
 			break
 		}
 
 		if msgType == websocket.BinaryMessage {
 			if _, err := dst.Write(p); err != nil {
-				log.Printf("forwardToTCP: write error: %v", err)
+				slog.Warn("forwardToTCP: write error", "direction", "ws_to_tcp", "err", err)
 				break
 			}
 		} else if msgType == websocket.TextMessage {
@@ -272,7 +311,7 @@ func forwardToTCP(src *websocket.Conn, dst net.Conn, wg *sync.WaitGroup, writeMu
 				err := src.WriteMessage(websocket.TextMessage, pongMsg)
 				writeMutex.Unlock()
 				if err != nil {
-					log.Printf("forwardToTCP: error sending pong: %v", err)
+					slog.Warn("forwardToTCP: error sending pong", "err", err)
 					break
 				}
 			}
@@ -1,15 +1,22 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"flag"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
+	"os"
+	"os/signal"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/gorilla/websocket"
+
+	"github.com/zjx20/littlefighterhub/internal/directory"
+	"github.com/zjx20/littlefighterhub/internal/logging"
 )
 
 var upgrader = websocket.Upgrader{
@@ -60,7 +67,7 @@ func (cm *ConnManager) getOrCreateRoom(roomID string) *Room {
 	if room, ok := cm.rooms[roomID]; ok {
 		return room
 	}
-	log.Printf("Creating new room: %s", roomID)
+	slog.Info("Creating new room", "room_id", roomID)
 	room := NewRoom()
 	cm.rooms[roomID] = room
 	return room
@@ -75,7 +82,7 @@ func (cm *ConnManager) removeRoom(roomID string) {
 		isHostPresent := room.hostConn != nil
 		room.lock.Unlock()
 		if !isHostPresent {
-			log.Printf("Removing empty room: %s", roomID)
+			slog.Info("Removing empty room", "room_id", roomID)
 			delete(cm.rooms, roomID)
 		}
 	}
@@ -85,14 +92,14 @@ func (cm *ConnManager) removeRoom(roomID string) {
 func (cm *ConnManager) handleWebSocket(w http.ResponseWriter, r *http.Request) {
 	roomID := r.URL.Query().Get("room")
 	if roomID == "" {
-		log.Println("Rejecting connection: missing room ID")
+		slog.Warn("Rejecting connection: missing room ID", "remote_addr", r.RemoteAddr)
 		http.Error(w, "Room ID is required", http.StatusBadRequest)
 		return
 	}
 
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
-		log.Printf("Upgrade error for room %s: %v", roomID, err)
+		slog.Warn("Upgrade failed", "room_id", roomID, "err", err)
 		return
 	}
 
@@ -101,20 +108,20 @@ func (cm *ConnManager) handleWebSocket(w http.ResponseWriter, r *http.Request) {
 	// The first message determines the client's role.
 	msgType, p, err := conn.ReadMessage()
 	if err != nil {
-		log.Printf("Room %s: Error reading role message: %v", roomID, err)
+		slog.Warn("Error reading role message", "room_id", roomID, "err", err)
 		conn.Close()
 		return
 	}
 
 	if msgType != websocket.TextMessage {
-		log.Printf("Room %s: First message must be a text message for role definition.", roomID)
+		slog.Warn("First message must be a text message for role definition", "room_id", roomID)
 		conn.Close()
 		return
 	}
 
 	var msg Message
 	if err := json.Unmarshal(p, &msg); err != nil {
-		log.Printf("Room %s: Error unmarshaling role message: %v", roomID, err)
+		slog.Warn("Error unmarshaling role message", "room_id", roomID, "err", err)
 		conn.Close()
 		return
 	}
@@ -124,13 +131,13 @@ func (cm *ConnManager) handleWebSocket(w http.ResponseWriter, r *http.Request) {
 	} else if msg.Type == "data_conn" {
 		var payload NewPeerPayload
 		if err := json.Unmarshal(msg.Payload, &payload); err != nil {
-			log.Printf("Room %s: Error unmarshaling data_conn payload: %v", roomID, err)
+			slog.Warn("Error unmarshaling data_conn payload", "room_id", roomID, "err", err)
 			conn.Close()
 			return
 		}
 		room.pairConnections(payload.PeerID, conn)
 	} else {
-		log.Printf("Room %s: Unknown role type: %s", roomID, msg.Type)
+		slog.Warn("Unknown role type", "room_id", roomID, "type", msg.Type)
 		conn.Close()
 	}
 }
@@ -139,25 +146,27 @@ func (room *Room) registerHost(conn *websocket.Conn, roomID string, onHostDiscon
 	room.lock.Lock()
 	if room.hostConn != nil {
 		room.lock.Unlock()
-		log.Printf("Room %s: Host already registered. Rejecting new host.", roomID)
+		slog.Warn("Host already registered, rejecting new host", "room_id", roomID)
 		conn.Close()
 		return
 	}
 	room.hostConn = conn
 	room.lock.Unlock()
-	log.Printf("Room %s: Host registered successfully.", roomID)
+	hostsGauge.Inc()
+	slog.Info("Host registered", "room_id", roomID)
 
 	defer func() {
 		room.lock.Lock()
 		room.hostConn = nil
 		// Close all associated peer connections
 		for peerID, peerConn := range room.peers {
-			log.Printf("Room %s: Closing peer connection %s due to host disconnect.", roomID, peerID)
+			slog.Info("Closing peer connection due to host disconnect", "room_id", roomID, "peer_id", peerID)
 			peerConn.Close()
 		}
 		room.peers = make(map[string]*websocket.Conn) // Clear the peers map
 		room.lock.Unlock()
-		log.Printf("Room %s: Host disconnected and room cleaned up.", roomID)
+		hostsGauge.Dec()
+		slog.Info("Host disconnected and room cleaned up", "room_id", roomID)
 		onHostDisconnect()
 	}()
 
@@ -185,7 +194,7 @@ func (room *Room) registerHost(conn *websocket.Conn, roomID string, onHostDiscon
 			room.hostWriteLock.Unlock()
 
 			if err != nil {
-				log.Printf("Room %s: Failed to send app-level ping to host, assuming it's dead.", roomID)
+				slog.Warn("Failed to send app-level ping to host, assuming it's dead", "room_id", roomID, "err", err)
 				conn.Close() // This will trigger the defer in registerHost and clean up the room
 				return
 			}
@@ -196,7 +205,7 @@ func (room *Room) registerHost(conn *websocket.Conn, roomID string, onHostDiscon
 		conn.SetReadDeadline(time.Now().Add(pongWait))
 		msgType, p, err := conn.ReadMessage()
 		if err != nil {
-			log.Printf("Room %s: Host control connection closed: %v", roomID, err)
+			slog.Info("Host control connection closed", "room_id", roomID, "err", err)
 			break
 		}
 
@@ -206,18 +215,18 @@ func (room *Room) registerHost(conn *websocket.Conn, roomID string, onHostDiscon
 				continue
 			}
 		}
-		log.Printf("Room %s: Received unexpected message on host control channel: %s", roomID, p)
+		slog.Warn("Unexpected message on host control channel", "room_id", roomID, "message", string(p))
 	}
 }
 
 func (room *Room) registerPeer(conn *websocket.Conn, roomID string) {
 	peerID := "peer_" + time.Now().Format("20060102150405.000000")
-	log.Printf("Room %s: Peer connected with ID: %s", roomID, peerID)
+	slog.Info("Peer connected", "room_id", roomID, "peer_id", peerID)
 
 	room.lock.Lock()
 	if room.hostConn == nil {
 		room.lock.Unlock()
-		log.Printf("Room %s: No host available. Rejecting peer.", roomID)
+		slog.Warn("No host available, rejecting peer", "room_id", roomID, "peer_id", peerID)
 		conn.Close()
 		return
 	}
@@ -235,7 +244,7 @@ func (room *Room) registerPeer(conn *websocket.Conn, roomID string) {
 	room.hostWriteLock.Unlock()
 
 	if err != nil {
-		log.Printf("Room %s: Failed to notify host about new peer %s: %v", roomID, peerID, err)
+		slog.Warn("Failed to notify host about new peer", "room_id", roomID, "peer_id", peerID, "err", err)
 		conn.Close()
 		// Re-acquire lock to safely remove peer from map
 		room.lock.Lock()
@@ -249,41 +258,57 @@ func (room *Room) pairConnections(peerID string, hostDataConn *websocket.Conn) {
 	peerConn, ok := room.peers[peerID]
 	if !ok {
 		room.lock.Unlock()
-		log.Printf("Peer %s not found for pairing.", peerID)
+		slog.Warn("Peer not found for pairing", "peer_id", peerID)
 		hostDataConn.Close()
 		return
 	}
 	delete(room.peers, peerID)
 	room.lock.Unlock()
 
-	log.Printf("Pairing host data connection with peer %s", peerID)
+	slog.Info("Pairing host data connection with peer", "peer_id", peerID)
+
+	tunnelsTotal.Inc()
+	tunnelsActive.Inc()
 
 	var hostWriteMutex, peerWriteMutex sync.Mutex
 	go appPinger(hostDataConn, &hostWriteMutex)
 	go appPinger(peerConn, &peerWriteMutex)
-	go forward(hostDataConn, peerConn, "Host -> Peer ("+peerID+")", &peerWriteMutex)
-	go forward(peerConn, hostDataConn, "Peer -> Host ("+peerID+")", &hostWriteMutex)
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		forward(hostDataConn, peerConn, peerID, "host_to_peer", &peerWriteMutex)
+	}()
+	go func() {
+		defer wg.Done()
+		forward(peerConn, hostDataConn, peerID, "peer_to_host", &hostWriteMutex)
+	}()
+	go func() {
+		wg.Wait()
+		tunnelsActive.Dec()
+	}()
 }
 
 // handlePeer is the entry point for peer connections.
 func (cm *ConnManager) handlePeer(w http.ResponseWriter, r *http.Request) {
 	roomID := r.URL.Query().Get("room")
 	if roomID == "" {
-		log.Println("Rejecting peer connection: missing room ID")
+		slog.Warn("Rejecting peer connection: missing room ID", "remote_addr", r.RemoteAddr)
 		http.Error(w, "Room ID is required", http.StatusBadRequest)
 		return
 	}
 
 	room := cm.getOrCreateRoom(roomID)
 	if room == nil {
-		log.Printf("Could not get or create room %s", roomID)
+		slog.Error("Could not get or create room", "room_id", roomID)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
 	}
 
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
-		log.Printf("Upgrade error for peer in room %s: %v", roomID, err)
+		slog.Warn("Upgrade failed for peer", "room_id", roomID, "err", err)
 		return
 	}
 	room.registerPeer(conn, roomID)
@@ -291,21 +316,64 @@ func (cm *ConnManager) handlePeer(w http.ResponseWriter, r *http.Request) {
 
 func main() {
 	port := flag.Int("port", 8095, "Port to listen on")
+	logLevel := flag.String("log-level", "info", "Log level: trace, debug, info, warn or error")
+	logFormat := flag.String("log-format", "text", "Log format: text or json")
+	dirURL := flag.String("directory", "", "URL of a hub directory to register with")
+	dirToken := flag.String("directory-token", "", "Secret for registering with the hub directory")
+	publicAddr := flag.String("public-address", "", "Public URL clients connect to, e.g. wss://proxy.example.com")
+	region := flag.String("region", "", "Region tag shown in the hub directory")
+	name := flag.String("name", "", "Name shown in the hub directory (default host name)")
 	flag.Parse()
 
+	if _, err := logging.Setup(os.Stderr, *logFormat, *logLevel); err != nil {
+		logging.Fatal("Invalid logging configuration", "err", err)
+	}
+
 	manager := NewConnManager()
 	// The host connects here to register and establish a data connection.
 	http.HandleFunc("/ws-host", manager.handleWebSocket)
 	// Peers connect here.
 	http.HandleFunc("/ws-peer", manager.handlePeer)
+	registry.RegisterProcess()
+	http.Handle("/metrics", registry.Handler())
 
-	log.Printf("Proxy server started on :%d", *port)
-	err := http.ListenAndServe(fmt.Sprintf(":%d", *port), nil)
-	if err != nil {
-		log.Fatal("ListenAndServe: ", err)
+	go func() {
+		slog.Info("Proxy server started", "port", *port)
+		err := http.ListenAndServe(fmt.Sprintf(":%d", *port), nil)
+		if err != nil {
+			logging.Fatal("ListenAndServe failed", "err", err)
+		}
+	}()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if *dirURL != "" {
+		if *publicAddr == "" {
+			logging.Fatal("-public-address is required to register with a directory")
+		}
+		if *name == "" {
+			*name, _ = os.Hostname()
+		}
+		client := &directory.Client{URL: *dirURL, Token: *dirToken}
+		// Heartbeat deregisters and returns once ctx is done.
+		client.Heartbeat(ctx, directoryInterval, slog.Default(), func() directory.Server {
+			return directory.Server{
+				Kind:    directory.KindProxy,
+				Name:    *name,
+				Address: *publicAddr,
+				Region:  *region,
+				Rooms:   int(hostsGauge.Value()),
+				Players: int(tunnelsActive.Value()),
+			}
+		})
 	}
+	<-ctx.Done()
+	slog.Info("Proxy server stopped")
 }
 
+// How often the proxy server heartbeats to the hub directory.
+const directoryInterval = 30 * time.Second
+
 const (
 	// Time allowed to write a message to the peer.
 	writeWait = 10 * time.Second
@@ -327,7 +395,7 @@ func appPinger(conn *websocket.Conn, writeMutex *sync.Mutex) {
 		err := conn.WriteMessage(websocket.TextMessage, pingMsg)
 		writeMutex.Unlock()
 		if err != nil {
-			log.Printf("Pinger: closing connection due to write error: %v", err)
+			slog.Info("Pinger: closing connection due to write error", "err", err)
 			conn.Close()
 			return
 		}
@@ -335,18 +403,19 @@ func appPinger(conn *websocket.Conn, writeMutex *sync.Mutex) {
 }
 
 // forward only forwards binary messages from src to dst.
-func forward(src, dst *websocket.Conn, direction string, writeMutex *sync.Mutex) {
+func forward(src, dst *websocket.Conn, peerID, direction string, writeMutex *sync.Mutex) {
+	bytes := tunnelBytes.WithLabelValues(direction)
 	defer func() {
 		src.Close()
 		dst.Close()
-		log.Printf("Stopped forwarding for direction: %s", direction)
+		slog.Info("Stopped forwarding", "peer_id", peerID, "direction", direction)
 	}()
 	for {
 		// Note: The read deadline is set by the client's pong response.
 		// Here we just read. If the client doesn't respond to our pings, this read will time out.
 		msgType, msg, err := src.ReadMessage()
 		if err != nil {
-			log.Printf("Forwarder read error on %s: %v", direction, err)
+			slog.Info("Forwarder read error", "peer_id", peerID, "direction", direction, "err", err)
 			break
 		}
 		// We only forward game data, which should be binary.
@@ -357,9 +426,11 @@ func forward(src, dst *websocket.Conn, direction string, writeMutex *sync.Mutex)
 			err := dst.WriteMessage(msgType, msg)
 			writeMutex.Unlock()
 			if err != nil {
-				log.Printf("Forwarder write error on %s: %v", direction, err)
+				forwardErrors.WithLabelValues(direction).Inc()
+				slog.Warn("Forwarder write error", "peer_id", peerID, "direction", direction, "err", err)
 				break
 			}
+			bytes.Add(float64(len(msg)))
 		}
 	}
 }
@@ -0,0 +1,15 @@
+package main
+
+import (
+	"github.com/zjx20/littlefighterhub/internal/metrics"
+)
+
+// Metrics exposed on /metrics.
+var (
+	registry      = metrics.NewRegistry()
+	hostsGauge    = registry.NewGauge("lf2proxy_hosts", "Number of registered hosts.")
+	tunnelsActive = registry.NewGauge("lf2proxy_tunnels_active", "Number of paired host/peer tunnels.")
+	tunnelsTotal  = registry.NewCounter("lf2proxy_tunnels_total", "Tunnels paired since start.")
+	tunnelBytes   = registry.NewCounterVec("lf2proxy_tunnel_bytes_total", "Bytes forwarded through tunnels.", "direction")
+	forwardErrors = registry.NewCounterVec("lf2proxy_forward_errors_total", "Failed writes while forwarding.", "direction")
+)
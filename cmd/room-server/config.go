@@ -0,0 +1,406 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/zjx20/littlefighterhub/internal/lan"
+	"github.com/zjx20/littlefighterhub/internal/logging"
+	"github.com/zjx20/littlefighterhub/internal/server"
+)
+
+// Config is the room-server configuration. It is read from an optional JSON
+// file and then overridden by any command line flags that were set.
+type Config struct {
+	Listen         []string        `json:"listen"`
+	Rooms          int             `json:"rooms"`
+	RoomCapacity   int             `json:"room_capacity"`
+	DefaultLatency int             `json:"default_latency"`
+	AdminToken     string          `json:"admin_token"`
+	ViewerToken    string          `json:"viewer_token"`
+	Bans           []string        `json:"bans"`
+	TLSCert        string          `json:"tls_cert"`
+	TLSKey         string          `json:"tls_key"`
+	HTTPRedirect   string          `json:"http_redirect"`
+	DataDir        string          `json:"data_dir"`
+	LogLevel       string          `json:"log_level"`
+	LogFormat      string          `json:"log_format"`
+	DrainTimeout   duration        `json:"drain_timeout"`
+	Features       server.Features `json:"features"`
+	// Webhooks receive the events of the default hub.
+	Webhooks []server.Webhook `json:"webhooks"`
+	// Hubs are additional independent hubs served by the same process.
+	Hubs map[string]HubConfig `json:"hubs"`
+	// Directory announces the server to a hub directory.
+	Directory DirectoryConfig `json:"directory"`
+	// LANAnnounce is the broadcast or multicast address LAN beacons are
+	// sent to, e.g. "255.255.255.255:47777". Beacons are off when empty.
+	LANAnnounce string `json:"lan_announce"`
+}
+
+// DirectoryConfig configures the registration with a hub directory. Every
+// hub is registered, named hubs as "<name>/<hub>" at "<address>/hub/<hub>".
+type DirectoryConfig struct {
+	// URL of the directory; registration is off when empty.
+	URL   string `json:"url"`
+	Token string `json:"token"`
+	// Name shown in the listing; the host name when empty.
+	Name string `json:"name"`
+	// Address is the public URL players connect to, e.g.
+	// "ws://lf2.example.com:8080".
+	Address  string   `json:"address"`
+	Region   string   `json:"region"`
+	Interval duration `json:"interval"`
+}
+
+func defaultConfig() Config {
+	sc := server.DefaultConfig()
+	return Config{
+		Listen:         []string{":8080"},
+		Rooms:          sc.Rooms,
+		RoomCapacity:   sc.RoomCapacity,
+		DefaultLatency: sc.DefaultLatency,
+		DataDir:        "data",
+		LogLevel:       "info",
+		LogFormat:      "text",
+		DrainTimeout:   duration(2 * time.Minute),
+		Features:       sc.Features,
+		Directory:      DirectoryConfig{Interval: duration(30 * time.Second)},
+	}
+}
+
+func (c Config) validate() error {
+	if len(c.Listen) == 0 {
+		return fmt.Errorf("at least one listen address is required")
+	}
+	if (c.TLSCert == "") != (c.TLSKey == "") {
+		return fmt.Errorf("tls_cert and tls_key must be set together")
+	}
+	if c.HTTPRedirect != "" && c.TLSCert == "" {
+		return fmt.Errorf("http_redirect requires tls_cert and tls_key")
+	}
+	if _, err := logging.ParseLevel(c.LogLevel); err != nil {
+		return err
+	}
+	switch c.LogFormat {
+	case "text", "json":
+	default:
+		return fmt.Errorf("unknown log format %q", c.LogFormat)
+	}
+	if c.Directory.URL != "" {
+		if c.Directory.Address == "" {
+			return fmt.Errorf("directory.address is required to register with a directory")
+		}
+		if c.Directory.Interval <= 0 {
+			return fmt.Errorf("directory.interval must be positive")
+		}
+	}
+	if c.LANAnnounce != "" {
+		if _, _, err := net.SplitHostPort(c.LANAnnounce); err != nil {
+			return fmt.Errorf("invalid lan_announce address: %w", err)
+		}
+	}
+	if err := c.serverConfig().Validate(); err != nil {
+		return err
+	}
+	return c.validateHubs()
+}
+
+// serverConfig extracts the settings handled by the server package.
+func (c Config) serverConfig() server.Config {
+	return server.Config{
+		Rooms:          c.Rooms,
+		RoomCapacity:   c.RoomCapacity,
+		DefaultLatency: c.DefaultLatency,
+		AdminToken:     c.AdminToken,
+		ViewerToken:    c.ViewerToken,
+		Bans:           c.Bans,
+		RoomLogDir:     filepath.Join(c.DataDir, "rooms"),
+		Features:       c.Features,
+		Webhooks:       c.Webhooks,
+	}
+}
+
+// restartRequired lists the settings that differ between c and next but are
+// only applied on startup.
+func (c Config) restartRequired(next Config) []string {
+	var changed []string
+	if strings.Join(c.Listen, ",") != strings.Join(next.Listen, ",") {
+		changed = append(changed, "listen")
+	}
+	if c.Rooms != next.Rooms {
+		changed = append(changed, "rooms")
+	}
+	if strings.Join(c.hubNames(), ",") != strings.Join(next.hubNames(), ",") {
+		changed = append(changed, "hubs")
+	}
+	for name := range c.Hubs {
+		if _, ok := next.Hubs[name]; ok && c.hubServerConfig(name).Rooms != next.hubServerConfig(name).Rooms {
+			changed = append(changed, "hubs."+name+".rooms")
+		}
+		if _, ok := next.Hubs[name]; ok && strings.Join(c.Hubs[name].Hosts, ",") != strings.Join(next.Hubs[name].Hosts, ",") {
+			changed = append(changed, "hubs."+name+".hosts")
+		}
+	}
+	if c.TLSCert != next.TLSCert || c.TLSKey != next.TLSKey {
+		changed = append(changed, "tls")
+	}
+	if c.HTTPRedirect != next.HTTPRedirect {
+		changed = append(changed, "http_redirect")
+	}
+	if c.DataDir != next.DataDir {
+		changed = append(changed, "data_dir")
+	}
+	if c.LogFormat != next.LogFormat {
+		changed = append(changed, "log_format")
+	}
+	if c.Directory != next.Directory {
+		changed = append(changed, "directory")
+	}
+	if c.LANAnnounce != next.LANAnnounce {
+		changed = append(changed, "lan_announce")
+	}
+	return changed
+}
+
+// flags binds the command line flags. The values are only copied into a
+// Config by apply, and only for the flags given on the command line.
+type flags struct {
+	set            *flag.FlagSet
+	configFile     string
+	listen         stringList
+	rooms          int
+	roomCapacity   int
+	defaultLatency int
+	adminToken     string
+	viewerToken    string
+	bans           stringList
+	webhooks       stringList
+	tlsCert        string
+	tlsKey         string
+	httpRedirect   string
+	dataDir        string
+	logLevel       string
+	logFormat      string
+	drainTimeout   time.Duration
+	directory      string
+	directoryToken string
+	publicAddress  string
+	region         string
+	serverName     string
+	lanAnnounce    string
+	features       featureFlags
+}
+
+func newFlags(set *flag.FlagSet) *flags {
+	d := defaultConfig()
+	f := &flags{set: set}
+	set.StringVar(&f.configFile, "config", "", "Path to a JSON config file")
+	set.Var(&f.listen, "listen", "Address to listen on, may be repeated or comma separated (default :8080)")
+	set.IntVar(&f.rooms, "rooms", d.Rooms, "Number of rooms")
+	set.IntVar(&f.roomCapacity, "room-capacity", d.RoomCapacity, "Maximum number of players per room")
+	set.IntVar(&f.defaultLatency, "latency", d.DefaultLatency, "Default room latency")
+	set.StringVar(&f.adminToken, "admin-token", "", "Secret granting moderator access to the ADMIN channel")
+	set.StringVar(&f.viewerToken, "viewer-token", "", "Secret granting read-only access to the ADMIN channel")
+	set.Var(&f.bans, "ban", "IP address or CIDR range to refuse, may be repeated or comma separated")
+	set.Var(&f.webhooks, "webhook", "URL to post every event to, may be repeated or comma separated")
+	set.StringVar(&f.tlsCert, "tls-cert", "", "TLS certificate file")
+	set.StringVar(&f.tlsKey, "tls-key", "", "TLS private key file")
+	set.StringVar(&f.httpRedirect, "http-redirect", "", "Address for a plain HTTP listener redirecting to HTTPS, e.g. :80")
+	set.StringVar(&f.dataDir, "data-dir", d.DataDir, "Directory for data files")
+	set.StringVar(&f.logLevel, "log-level", d.LogLevel, "Log level: trace, debug, info, warn or error")
+	set.StringVar(&f.logFormat, "log-format", d.LogFormat, "Log format: text or json")
+	set.DurationVar(&f.drainTimeout, "drain-timeout", time.Duration(d.DrainTimeout), "How long to wait for running matches on shutdown")
+	set.StringVar(&f.directory, "directory", "", "URL of a hub directory to register with")
+	set.StringVar(&f.directoryToken, "directory-token", "", "Secret for registering with the hub directory")
+	set.StringVar(&f.publicAddress, "public-address", "", "Public URL players connect to, e.g. ws://lf2.example.com:8080")
+	set.StringVar(&f.region, "region", "", "Region tag shown in the hub directory")
+	set.StringVar(&f.serverName, "server-name", "", "Name shown in the hub directory (default host name)")
+	set.StringVar(&f.lanAnnounce, "lan-announce", "", "Broadcast or multicast address for LAN beacons, e.g. "+lan.DefaultAddress)
+	f.features = featureFlags{features: &d.Features}
+	set.Var(&f.features, "features", "Comma separated feature toggles, e.g. admin=false")
+	return f
+}
+
+// apply copies the flags that were set on the command line into cfg.
+func (f *flags) apply(cfg *Config) {
+	f.set.Visit(func(fl *flag.Flag) {
+		switch fl.Name {
+		case "listen":
+			cfg.Listen = f.listen
+		case "rooms":
+			cfg.Rooms = f.rooms
+		case "room-capacity":
+			cfg.RoomCapacity = f.roomCapacity
+		case "latency":
+			cfg.DefaultLatency = f.defaultLatency
+		case "admin-token":
+			cfg.AdminToken = f.adminToken
+		case "viewer-token":
+			cfg.ViewerToken = f.viewerToken
+		case "ban":
+			cfg.Bans = f.bans
+		case "webhook":
+			cfg.Webhooks = nil
+			for _, u := range f.webhooks {
+				cfg.Webhooks = append(cfg.Webhooks, server.Webhook{URL: u})
+			}
+		case "tls-cert":
+			cfg.TLSCert = f.tlsCert
+		case "tls-key":
+			cfg.TLSKey = f.tlsKey
+		case "http-redirect":
+			cfg.HTTPRedirect = f.httpRedirect
+		case "data-dir":
+			cfg.DataDir = f.dataDir
+		case "log-level":
+			cfg.LogLevel = f.logLevel
+		case "log-format":
+			cfg.LogFormat = f.logFormat
+		case "drain-timeout":
+			cfg.DrainTimeout = duration(f.drainTimeout)
+		case "directory":
+			cfg.Directory.URL = f.directory
+		case "directory-token":
+			cfg.Directory.Token = f.directoryToken
+		case "public-address":
+			cfg.Directory.Address = f.publicAddress
+		case "region":
+			cfg.Directory.Region = f.region
+		case "server-name":
+			cfg.Directory.Name = f.serverName
+		case "lan-announce":
+			cfg.LANAnnounce = f.lanAnnounce
+		case "features":
+			f.features.applyTo(&cfg.Features)
+		}
+	})
+}
+
+// load builds the effective configuration: defaults, then the config file,
+// then the command line flags.
+func (f *flags) load() (Config, error) {
+	cfg := defaultConfig()
+	if f.configFile != "" {
+		data, err := os.ReadFile(f.configFile)
+		if err != nil {
+			return cfg, err
+		}
+		if err := json.Unmarshal(data, &cfg); err != nil {
+			return cfg, fmt.Errorf("parse %s: %w", f.configFile, err)
+		}
+	}
+	f.apply(&cfg)
+	if err := cfg.validate(); err != nil {
+		return cfg, err
+	}
+	return cfg, nil
+}
+
+// duration is a time.Duration that reads from JSON as a string like "90s".
+type duration time.Duration
+
+func (d *duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = duration(v)
+	return nil
+}
+
+func (d duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// stringList is a flag that may be repeated, each value possibly holding
+// several comma separated entries.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(v string) error {
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			*l = append(*l, item)
+		}
+	}
+	return nil
+}
+
+// featureFields maps feature names, as used on the command line, to the
+// toggles in features.
+func featureFields(features *server.Features) map[string]*bool {
+	return map[string]*bool{
+		"admin":        &features.Admin,
+		"bots":         &features.Bots,
+		"compression":  &features.Compression,
+		"events":       &features.Events,
+		"impairment":   &features.Impairment,
+		"lockstep":     &features.Lockstep,
+		"metrics":      &features.Metrics,
+		"room_logs":    &features.RoomLogs,
+		"strict_relay": &features.StrictRelay,
+	}
+}
+
+// featureFlags parses "name=bool" pairs into server.Features.
+type featureFlags struct {
+	features *server.Features
+	values   map[string]bool
+}
+
+func (f *featureFlags) String() string {
+	if f.features == nil {
+		return ""
+	}
+	var pairs []string
+	for name, enabled := range featureFields(f.features) {
+		pairs = append(pairs, fmt.Sprintf("%s=%v", name, *enabled))
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (f *featureFlags) Set(v string) error {
+	if f.values == nil {
+		f.values = make(map[string]bool)
+	}
+	known := featureFields(&server.Features{})
+	for _, item := range strings.Split(v, ",") {
+		name, value, ok := strings.Cut(strings.TrimSpace(item), "=")
+		if !ok {
+			value = "true"
+		}
+		if _, ok := known[name]; !ok {
+			return fmt.Errorf("unknown feature %q", name)
+		}
+		enabled, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("feature %s: %w", name, err)
+		}
+		f.values[name] = enabled
+	}
+	return nil
+}
+
+func (f *featureFlags) applyTo(features *server.Features) {
+	fields := featureFields(features)
+	for name, enabled := range f.values {
+		*fields[name] = enabled
+	}
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/zjx20/littlefighterhub/internal/logging"
+	"github.com/zjx20/littlefighterhub/internal/tlsutil"
+)
+
+// runGencert implements "room-server gencert", which bootstraps a private CA
+// and a server certificate for deployments without a public certificate.
+func runGencert(args []string) {
+	set := flag.NewFlagSet("gencert", flag.ExitOnError)
+	dir := set.String("dir", filepath.Join("data", "tls"), "Directory to write the certificates to")
+	var hosts stringList
+	set.Var(&hosts, "hosts", "Host names and IP addresses for the server certificate, comma separated (default localhost,127.0.0.1,::1)")
+	set.Parse(args)
+	if len(hosts) == 0 {
+		hosts = stringList{"localhost", "127.0.0.1", "::1"}
+	}
+
+	if err := tlsutil.GenerateSelfSigned(*dir, hosts); err != nil {
+		logging.Fatal("Failed to generate certificates", "err", err)
+	}
+	fmt.Fprintf(os.Stderr, "Wrote %s and %s to %s.\n", tlsutil.ServerFile, tlsutil.ServerKeyFile, *dir)
+	fmt.Fprintf(os.Stderr, "Start the server with:\n  room-server -tls-cert %s -tls-key %s\n",
+		filepath.Join(*dir, tlsutil.ServerFile), filepath.Join(*dir, tlsutil.ServerKeyFile))
+	fmt.Fprintf(os.Stderr, "Players need to trust %s. Keep %s private.\n",
+		filepath.Join(*dir, tlsutil.CAFile), filepath.Join(*dir, tlsutil.CAKeyFile))
+}
@@ -0,0 +1,141 @@
+//go:build linux
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Environment of a process started by handoff. The listening sockets are
+// passed from fd 3 on, in the order of the addresses listed, followed by a
+// pipe the new process reports readiness on.
+const (
+	listenFDsEnv = "LF2HUB_LISTEN_FDS"
+	readyFDEnv   = "LF2HUB_READY_FD"
+)
+
+// handoffSignals are the signals that start a handoff.
+func handoffSignals() []os.Signal {
+	return []os.Signal{syscall.SIGUSR2}
+}
+
+// inheritedListeners returns the listening sockets passed by the process
+// that started this one, by the configured address they were opened for.
+func inheritedListeners() (map[string]net.Listener, error) {
+	v := os.Getenv(listenFDsEnv)
+	os.Unsetenv(listenFDsEnv)
+	if v == "" {
+		return nil, nil
+	}
+	lns := make(map[string]net.Listener)
+	for i, addr := range strings.Split(v, ",") {
+		f := os.NewFile(uintptr(3+i), addr)
+		ln, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			for _, ln := range lns {
+				ln.Close()
+			}
+			return nil, fmt.Errorf("inherited listener %s: %w", addr, err)
+		}
+		lns[addr] = ln
+	}
+	return lns, nil
+}
+
+// notifyReady tells the process that started this one that it is serving.
+func notifyReady() {
+	v := os.Getenv(readyFDEnv)
+	os.Unsetenv(readyFDEnv)
+	fd, err := strconv.Atoi(v)
+	if err != nil {
+		return
+	}
+	f := os.NewFile(uintptr(fd), "ready")
+	f.Write([]byte("ready\n"))
+	f.Close()
+}
+
+// handoff starts the current executable again with the same arguments,
+// passing it the listeners opened for addrs, and waits up to timeout for it
+// to serve. If the binary was replaced since this process started, the new
+// build is run. On error the new process is killed and nothing changes.
+func handoff(addrs []string, listeners []net.Listener, timeout time.Duration) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	var files []*os.File
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	for i, ln := range listeners {
+		fl, ok := ln.(interface{ File() (*os.File, error) })
+		if !ok {
+			return fmt.Errorf("listener %s cannot be passed on", addrs[i])
+		}
+		f, err := fl.File()
+		if err != nil {
+			return fmt.Errorf("listener %s: %w", addrs[i], err)
+		}
+		files = append(files, f)
+	}
+	ready, readyW, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer ready.Close()
+	files = append(files, readyW)
+
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = files
+	for _, kv := range os.Environ() {
+		if !strings.HasPrefix(kv, listenFDsEnv+"=") && !strings.HasPrefix(kv, readyFDEnv+"=") {
+			cmd.Env = append(cmd.Env, kv)
+		}
+	}
+	cmd.Env = append(cmd.Env,
+		listenFDsEnv+"="+strings.Join(addrs, ","),
+		readyFDEnv+"="+strconv.Itoa(3+len(listeners)))
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	// Only the new process holds the write end now, so the read below
+	// ends if it exits without reporting.
+	readyW.Close()
+
+	result := make(chan error, 1)
+	go func() {
+		buf := make([]byte, 16)
+		if n, _ := ready.Read(buf); n > 0 {
+			result <- nil
+			return
+		}
+		result <- errors.New("new process exited before serving")
+	}()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case err = <-result:
+	case <-timer.C:
+		err = fmt.Errorf("new process not serving after %s", timeout)
+	}
+	if err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return err
+	}
+	return cmd.Process.Release()
+}
@@ -0,0 +1,20 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"net"
+	"os"
+	"time"
+)
+
+func handoffSignals() []os.Signal { return nil }
+
+func inheritedListeners() (map[string]net.Listener, error) { return nil, nil }
+
+func notifyReady() {}
+
+func handoff(addrs []string, listeners []net.Listener, timeout time.Duration) error {
+	return errors.New("listener handoff is only supported on Linux")
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zjx20/littlefighterhub/internal/directory"
+	"github.com/zjx20/littlefighterhub/internal/lan"
+	"github.com/zjx20/littlefighterhub/internal/metrics"
+	"github.com/zjx20/littlefighterhub/internal/server"
+)
+
+// defaultHub names the hub configured by the top level settings. It is
+// served for requests that match no named hub.
+const defaultHub = "default"
+
+var hubNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// HubConfig configures a named hub: an independent set of rooms with its own
+// settings, admin tokens, bans and webhooks. Rooms, room capacity, latency and
+// features fall back to the top level settings when left out; tokens, bans
+// and webhooks do not.
+type HubConfig struct {
+	// Hosts routes requests whose Host header matches one of these names
+	// to the hub, in addition to the /hub/<name>/ path prefix.
+	Hosts          []string         `json:"hosts"`
+	Rooms          int              `json:"rooms"`
+	RoomCapacity   int              `json:"room_capacity"`
+	DefaultLatency int              `json:"default_latency"`
+	AdminToken     string           `json:"admin_token"`
+	ViewerToken    string           `json:"viewer_token"`
+	Bans           []string         `json:"bans"`
+	Features       *server.Features `json:"features"`
+	Webhooks       []server.Webhook `json:"webhooks"`
+}
+
+// hubNames returns the names of all hubs, the default hub first.
+func (c Config) hubNames() []string {
+	names := make([]string, 0, len(c.Hubs))
+	for name := range c.Hubs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return append([]string{defaultHub}, names...)
+}
+
+// hubServerConfig returns the server settings of the named hub.
+func (c Config) hubServerConfig(name string) server.Config {
+	if name == defaultHub {
+		return c.serverConfig()
+	}
+	h := c.Hubs[name]
+	sc := c.serverConfig()
+	if h.Rooms != 0 {
+		sc.Rooms = h.Rooms
+	}
+	if h.RoomCapacity != 0 {
+		sc.RoomCapacity = h.RoomCapacity
+	}
+	if h.DefaultLatency != 0 {
+		sc.DefaultLatency = h.DefaultLatency
+	}
+	if h.Features != nil {
+		sc.Features = *h.Features
+	}
+	sc.AdminToken = h.AdminToken
+	sc.ViewerToken = h.ViewerToken
+	sc.Bans = h.Bans
+	sc.Webhooks = h.Webhooks
+	sc.RoomLogDir = filepath.Join(c.DataDir, "hubs", name, "rooms")
+	return sc
+}
+
+func (c Config) validateHubs() error {
+	hosts := make(map[string]string)
+	for name, h := range c.Hubs {
+		if name == defaultHub || !hubNamePattern.MatchString(name) {
+			return fmt.Errorf("invalid hub name %q", name)
+		}
+		if err := c.hubServerConfig(name).Validate(); err != nil {
+			return fmt.Errorf("hub %s: %w", name, err)
+		}
+		for _, host := range h.Hosts {
+			host = strings.ToLower(host)
+			if other, ok := hosts[host]; ok {
+				return fmt.Errorf("host %s is used by hubs %s and %s", host, other, name)
+			}
+			hosts[host] = name
+		}
+	}
+	return nil
+}
+
+// hub is a named server together with the handler serving it.
+type hub struct {
+	name    string
+	server  *server.Server
+	handler http.Handler
+}
+
+// hubSet routes requests to the hubs: by Host header first, then by the
+// /hub/<name>/ path prefix, falling back to the default hub. /metrics is
+// shared by all hubs.
+type hubSet struct {
+	hubs    map[string]*hub
+	byHost  map[string]*hub
+	metrics http.Handler
+}
+
+// newHubSet creates a server per hub. All hubs publish to reg, labelled by
+// hub name when named hubs are configured, and log with a hub attribute.
+func newHubSet(cfg Config, reg *metrics.Registry) (*hubSet, error) {
+	hs := &hubSet{
+		hubs:   make(map[string]*hub),
+		byHost: make(map[string]*hub),
+	}
+	for _, name := range cfg.hubNames() {
+		sc := cfg.hubServerConfig(name)
+		sc.Registry = reg
+		sc.Logger = slog.Default()
+		if len(cfg.Hubs) > 0 {
+			sc.Registry = reg.WithLabels("hub", name)
+			sc.Logger = slog.Default().With("hub", name)
+		}
+		s, err := server.NewServerWithConfig(sc)
+		if err != nil {
+			return nil, fmt.Errorf("hub %s: %w", name, err)
+		}
+		if sc.AdminToken == "" && sc.ViewerToken == "" {
+			sc.Logger.Warn("No admin token configured, ADMIN connections will be rejected")
+		}
+		mux := http.NewServeMux()
+		mux.HandleFunc("/", s.HandleConnections)
+		mux.Handle("/admin/", s.AdminHandler())
+		mux.Handle("/events", s.EventsHandler())
+		h := &hub{name: name, server: s, handler: mux}
+		hs.hubs[name] = h
+		for _, host := range cfg.Hubs[name].Hosts {
+			hs.byHost[strings.ToLower(host)] = h
+		}
+	}
+	hs.metrics = hs.hubs[defaultHub].server.MetricsHandler()
+	return hs, nil
+}
+
+func (hs *hubSet) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/metrics" {
+		hs.metrics.ServeHTTP(w, r)
+		return
+	}
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if h, ok := hs.byHost[strings.ToLower(host)]; ok {
+		h.handler.ServeHTTP(w, r)
+		return
+	}
+	if rest, ok := strings.CutPrefix(r.URL.Path, "/hub/"); ok {
+		name, path, _ := strings.Cut(rest, "/")
+		h, ok := hs.hubs[name]
+		if !ok || name == defaultHub {
+			http.NotFound(w, r)
+			return
+		}
+		// Game clients cannot follow redirects, so "/hub/<name>" is served
+		// as if it were "/hub/<name>/".
+		r = r.Clone(r.Context())
+		r.URL.Path = "/" + path
+		r.URL.RawPath = ""
+		h.handler.ServeHTTP(w, r)
+		return
+	}
+	hs.hubs[defaultHub].handler.ServeHTTP(w, r)
+}
+
+// reload applies next to the hubs that still exist. Added or removed hubs
+// need a restart, which restartRequired reports.
+func (hs *hubSet) reload(next Config) {
+	for name, h := range hs.hubs {
+		if _, ok := next.Hubs[name]; !ok && name != defaultHub {
+			continue
+		}
+		if err := h.server.Reload(next.hubServerConfig(name)); err != nil {
+			slog.Error("Reload failed", "hub", name, "err", err)
+		}
+	}
+}
+
+// announce registers every hub with the directory until ctx is done. The
+// returned channel is closed once all hubs are deregistered.
+func (hs *hubSet) announce(ctx context.Context, cfg DirectoryConfig) <-chan struct{} {
+	name := serverName(cfg)
+	client := &directory.Client{URL: cfg.URL, Token: cfg.Token}
+	var wg sync.WaitGroup
+	for hubName, h := range hs.hubs {
+		entry := directory.Server{
+			Kind:    directory.KindRoom,
+			Name:    name,
+			Address: strings.TrimSuffix(cfg.Address, "/"),
+			Region:  cfg.Region,
+		}
+		if hubName != defaultHub {
+			entry.Name += "/" + hubName
+			entry.Address += "/hub/" + hubName
+		}
+		wg.Add(1)
+		go func(s *server.Server) {
+			defer wg.Done()
+			client.Heartbeat(ctx, time.Duration(cfg.Interval), slog.Default(), func() directory.Server {
+				o := s.Occupancy()
+				entry.Rooms, entry.ActiveRooms, entry.Players, entry.Capacity = o.Rooms, o.ActiveRooms, o.Players, o.Capacity
+				return entry
+			})
+		}(h.server)
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	return done
+}
+
+// serverName is the name the server is listed under in the directory and in
+// LAN beacons.
+func serverName(cfg DirectoryConfig) string {
+	if cfg.Name != "" {
+		return cfg.Name
+	}
+	name, _ := os.Hostname()
+	return name
+}
+
+// lanBeacons describes every hub for LAN discovery.
+func (hs *hubSet) lanBeacons(name, scheme string, port int) []lan.Beacon {
+	beacons := make([]lan.Beacon, 0, len(hs.hubs))
+	for hubName, h := range hs.hubs {
+		b := lan.Beacon{Name: name, Scheme: scheme, Port: port, Path: "/"}
+		if hubName != defaultHub {
+			b.Name += "/" + hubName
+			b.Path = "/hub/" + hubName
+		}
+		o := h.server.Occupancy()
+		b.Rooms, b.ActiveRooms, b.Players, b.Capacity = o.Rooms, o.ActiveRooms, o.Players, o.Capacity
+		beacons = append(beacons, b)
+	}
+	return beacons
+}
+
+// closeEventStreams ends the /events responses of all hubs, which would
+// otherwise hold up the shutdown of the HTTP servers.
+func (hs *hubSet) closeEventStreams() {
+	for _, h := range hs.hubs {
+		h.server.CloseEventStreams()
+	}
+}
+
+// shutdown drains all hubs in parallel.
+func (hs *hubSet) shutdown(ctx context.Context) {
+	var wg sync.WaitGroup
+	for name, h := range hs.hubs {
+		wg.Add(1)
+		go func(name string, s *server.Server) {
+			defer wg.Done()
+			if err := s.Shutdown(ctx); err != nil {
+				slog.Warn("Drain incomplete", "hub", name, "err", err)
+			}
+		}(name, h.server)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/zjx20/littlefighterhub/internal/metrics"
+	"github.com/zjx20/littlefighterhub/internal/server"
+)
+
+func testHubConfig() Config {
+	cfg := defaultConfig()
+	cfg.DataDir = "/srv/lf2"
+	cfg.AdminToken = "top"
+	cfg.Bans = []string{"10.0.0.1"}
+	cfg.Hubs = map[string]HubConfig{
+		"alpha": {Hosts: []string{"Alpha.example.com"}, Rooms: 2},
+		"beta":  {Hosts: []string{"beta.example.com"}, RoomCapacity: 4, AdminToken: "beta"},
+	}
+	return cfg
+}
+
+// TestHubRouting replaces the handler of every hub with one that answers
+// with the hub name and the path it was given.
+func TestHubRouting(t *testing.T) {
+	hs, err := newHubSet(testHubConfig(), metrics.NewRegistry())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, h := range hs.hubs {
+		name := name
+		h.handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, name+" "+r.URL.Path)
+		})
+	}
+	hs.metrics = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "metrics")
+	})
+
+	tests := []struct {
+		host string
+		path string
+		want string
+	}{
+		{"lf2.example.com", "/", "default /"},
+		{"lf2.example.com", "/admin/rooms", "default /admin/rooms"},
+		{"lf2.example.com", "/hub/alpha/", "alpha /"},
+		{"lf2.example.com", "/hub/alpha", "alpha /"},
+		{"lf2.example.com", "/hub/alpha/admin/rooms", "alpha /admin/rooms"},
+		{"lf2.example.com", "/hub/default/", "404"},
+		{"lf2.example.com", "/hub/gamma/", "404"},
+		{"alpha.example.com", "/", "alpha /"},
+		{"ALPHA.example.com:8080", "/events", "alpha /events"},
+		// The Host header wins over the path.
+		{"beta.example.com", "/hub/alpha/", "beta /hub/alpha/"},
+		{"lf2.example.com", "/metrics", "metrics"},
+		{"beta.example.com", "/metrics", "metrics"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, tt.path, nil)
+		r.Host = tt.host
+		w := httptest.NewRecorder()
+		hs.ServeHTTP(w, r)
+		got := strings.TrimSpace(w.Body.String())
+		if w.Code == http.StatusNotFound {
+			got = "404"
+		}
+		if got != tt.want {
+			t.Errorf("%s%s served by %q, want %q", tt.host, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestHubServerConfig(t *testing.T) {
+	cfg := testHubConfig()
+	features := server.Features{Events: true}
+	h := cfg.Hubs["beta"]
+	h.Features = &features
+	cfg.Hubs["beta"] = h
+
+	def := cfg.hubServerConfig(defaultHub)
+	if def.AdminToken != "top" || len(def.Bans) != 1 || def.RoomLogDir != filepath.Join("/srv/lf2", "rooms") {
+		t.Errorf("default hub = %+v, want the top level settings", def)
+	}
+
+	alpha := cfg.hubServerConfig("alpha")
+	if alpha.Rooms != 2 || alpha.RoomCapacity != cfg.RoomCapacity || alpha.DefaultLatency != cfg.DefaultLatency {
+		t.Errorf("alpha rooms %d, capacity %d, latency %d; want 2 and the top level capacity and latency",
+			alpha.Rooms, alpha.RoomCapacity, alpha.DefaultLatency)
+	}
+	if alpha.Features != cfg.Features {
+		t.Errorf("alpha features = %+v, want the top level %+v", alpha.Features, cfg.Features)
+	}
+	// Secrets and bans are never inherited.
+	if alpha.AdminToken != "" || alpha.Bans != nil {
+		t.Errorf("alpha admin token %q, bans %v; want none", alpha.AdminToken, alpha.Bans)
+	}
+	if want := filepath.Join("/srv/lf2", "hubs", "alpha", "rooms"); alpha.RoomLogDir != want {
+		t.Errorf("alpha room log dir = %s, want %s", alpha.RoomLogDir, want)
+	}
+
+	beta := cfg.hubServerConfig("beta")
+	if beta.Rooms != cfg.Rooms || beta.RoomCapacity != 4 || beta.AdminToken != "beta" || beta.Features != features {
+		t.Errorf("beta = %+v, want top level rooms, capacity 4, its own token and features", beta)
+	}
+}
+
+func TestValidateHubs(t *testing.T) {
+	tests := []struct {
+		name string
+		hubs map[string]HubConfig
+		want string
+	}{
+		{"valid", map[string]HubConfig{"a": {Hosts: []string{"a.example.com"}}, "b-2": {}}, ""},
+		{"default name", map[string]HubConfig{defaultHub: {}}, `invalid hub name "default"`},
+		{"upper case name", map[string]HubConfig{"Alpha": {}}, `invalid hub name "Alpha"`},
+		{"path in name", map[string]HubConfig{"a/b": {}}, `invalid hub name "a/b"`},
+		{"bad setting", map[string]HubConfig{"a": {RoomCapacity: 9}}, "hub a: room capacity"},
+		{"shared host", map[string]HubConfig{
+			"a": {Hosts: []string{"lf2.example.com"}},
+			"b": {Hosts: []string{"LF2.example.com"}},
+		}, "host lf2.example.com is used by hubs"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := defaultConfig()
+			cfg.Hubs = tt.hubs
+			err := cfg.validateHubs()
+			if tt.want == "" {
+				if err != nil {
+					t.Fatalf("validateHubs = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("validateHubs = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
@@ -1,19 +1,256 @@
 package main
 
 import (
-	"log"
+	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"log/slog"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
 
-	"github.com/zjx20/littlefighterhub/internal/server"
+	"github.com/zjx20/littlefighterhub/internal/directory"
+	"github.com/zjx20/littlefighterhub/internal/lan"
+	"github.com/zjx20/littlefighterhub/internal/logging"
+	"github.com/zjx20/littlefighterhub/internal/metrics"
+	"github.com/zjx20/littlefighterhub/internal/tlsutil"
+)
+
+const (
+	// How often the TLS certificate files are checked for changes.
+	certCheckInterval = 30 * time.Second
+	// How long a new process started by a handoff has to start serving.
+	handoffTimeout = 30 * time.Second
 )
 
 func main() {
-	s := server.NewServer()
-	http.HandleFunc("/", s.HandleConnections)
+	if len(os.Args) > 1 && os.Args[1] == "gencert" {
+		runGencert(os.Args[2:])
+		return
+	}
+
+	f := newFlags(flag.CommandLine)
+	flag.Parse()
+
+	cfg, err := f.load()
+	if err != nil {
+		logging.Fatal("Invalid configuration", "err", err)
+	}
+	logLevel, err := logging.Setup(os.Stderr, cfg.LogFormat, cfg.LogLevel)
+	if err != nil {
+		logging.Fatal("Invalid logging configuration", "err", err)
+	}
+	if err := os.MkdirAll(cfg.DataDir, 0o755); err != nil {
+		logging.Fatal("Failed to create data directory", "dir", cfg.DataDir, "err", err)
+	}
 
-	log.Println("http server started on :8080")
-	err := http.ListenAndServe(":8080", nil)
+	registry := metrics.NewRegistry()
+	registry.RegisterProcess()
+	hubs, err := newHubSet(cfg, registry)
 	if err != nil {
-		log.Fatal("ListenAndServe: ", err)
+		logging.Fatal("Failed to create server", "err", err)
+	}
+
+	// Open every listener before serving so a bad address fails fast. When
+	// started by a handoff, the sockets of the previous process are taken
+	// over instead.
+	inherited, err := inheritedListeners()
+	if err != nil {
+		logging.Fatal("Failed to take over listeners", "err", err)
+	}
+	listen := func(addr string) net.Listener {
+		if ln, ok := inherited[addr]; ok {
+			delete(inherited, addr)
+			return ln
+		}
+		ln, err := net.Listen("tcp", addr)
+		if err != nil {
+			logging.Fatal("Failed to listen", "addr", addr, "err", err)
+		}
+		return ln
+	}
+	var listeners []net.Listener
+	for _, addr := range cfg.Listen {
+		listeners = append(listeners, listen(addr))
+	}
+	// handoffAddrs and handoffListeners are passed on by a handoff.
+	handoffAddrs := append([]string(nil), cfg.Listen...)
+	handoffListeners := append([]net.Listener(nil), listeners...)
+	var redirectListener net.Listener
+	if cfg.HTTPRedirect != "" {
+		redirectListener = listen(cfg.HTTPRedirect)
+		handoffAddrs = append(handoffAddrs, cfg.HTTPRedirect)
+		handoffListeners = append(handoffListeners, redirectListener)
+	}
+	// The previous process listened on addresses no longer configured.
+	for _, ln := range inherited {
+		ln.Close()
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var tlsConfig *tls.Config
+	if cfg.TLSCert != "" {
+		reloader, err := tlsutil.NewCertReloader(cfg.TLSCert, cfg.TLSKey)
+		if err != nil {
+			logging.Fatal("Failed to load TLS certificate", "err", err)
+		}
+		go reloader.Watch(ctx, certCheckInterval)
+		tlsConfig = &tls.Config{GetCertificate: reloader.GetCertificate}
+	}
+
+	// Registration stops as soon as a shutdown begins, so players are not
+	// sent to a draining server. After a handoff, the entries are left
+	// registered for the new process.
+	announceCtx, stopAnnouncing := context.WithCancelCause(ctx)
+	defer stopAnnouncing(nil)
+	var announced <-chan struct{}
+	if cfg.Directory.URL != "" {
+		announced = hubs.announce(announceCtx, cfg.Directory)
+	}
+
+	if cfg.LANAnnounce != "" {
+		_, portStr, _ := net.SplitHostPort(listeners[0].Addr().String())
+		port, _ := strconv.Atoi(portStr)
+		scheme := "ws"
+		if tlsConfig != nil {
+			scheme = "wss"
+		}
+		name := serverName(cfg.Directory)
+		go func() {
+			slog.Info("Announcing on the LAN", "addr", cfg.LANAnnounce)
+			err := lan.Announce(ctx, cfg.LANAnnounce, lan.DefaultInterval, slog.Default(), func() []lan.Beacon {
+				return hubs.lanBeacons(name, scheme, port)
+			})
+			if err != nil {
+				slog.Error("LAN announcements stopped", "err", err)
+			}
+		}()
+	}
+
+	var servers []*http.Server
+	for _, ln := range listeners {
+		httpServer := &http.Server{Handler: hubs, TLSConfig: tlsConfig}
+		httpServer.RegisterOnShutdown(hubs.closeEventStreams)
+		servers = append(servers, httpServer)
+		go func(ln net.Listener) {
+			var err error
+			if tlsConfig != nil {
+				slog.Info("https server started", "addr", ln.Addr().String())
+				err = httpServer.ServeTLS(ln, "", "")
+			} else {
+				slog.Info("http server started", "addr", ln.Addr().String())
+				err = httpServer.Serve(ln)
+			}
+			if err != nil && !errors.Is(err, http.ErrServerClosed) {
+				logging.Fatal("Serve failed", "err", err)
+			}
+		}(ln)
+	}
+
+	if cfg.HTTPRedirect != "" {
+		_, httpsPort, err := net.SplitHostPort(cfg.Listen[0])
+		if err != nil {
+			logging.Fatal("Invalid listen address", "addr", cfg.Listen[0], "err", err)
+		}
+		redirectServer := &http.Server{Handler: tlsutil.RedirectHandler(httpsPort)}
+		servers = append(servers, redirectServer)
+		go func() {
+			slog.Info("Redirecting http to https", "addr", cfg.HTTPRedirect, "https_port", httpsPort)
+			err := redirectServer.Serve(redirectListener)
+			if err != nil && !errors.Is(err, http.ErrServerClosed) {
+				logging.Fatal("Redirect server failed", "err", err)
+			}
+		}()
+	}
+
+	// current is the configuration most recently loaded, including settings
+	// that are only honoured on restart.
+	var currentMu sync.Mutex
+	current := cfg
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			next, err := f.load()
+			if err != nil {
+				slog.Error("Reload failed, keeping the current configuration", "err", err)
+				continue
+			}
+			currentMu.Lock()
+			prev := current
+			currentMu.Unlock()
+			if changed := prev.restartRequired(next); len(changed) > 0 {
+				slog.Warn("Ignoring changes until restart", "settings", changed)
+			}
+			hubs.reload(next)
+			if level, err := logging.ParseLevel(next.LogLevel); err == nil {
+				logLevel.Set(level)
+			}
+			currentMu.Lock()
+			current = next
+			currentMu.Unlock()
+		}
+	}()
+
+	notifyReady()
+
+	// A handoff starts the binary again, which takes over the listeners,
+	// and then drains this process like a shutdown.
+	upgrade := make(chan os.Signal, 1)
+	if sigs := handoffSignals(); len(sigs) > 0 {
+		signal.Notify(upgrade, sigs...)
+	}
+	handedOff := false
+	for !handedOff && ctx.Err() == nil {
+		select {
+		case <-ctx.Done():
+		case <-upgrade:
+			slog.Info("Starting a new process to take over the listeners")
+			if err := handoff(handoffAddrs, handoffListeners, handoffTimeout); err != nil {
+				slog.Error("Handoff failed, continuing to serve", "err", err)
+				continue
+			}
+			slog.Info("New process is serving, draining this one")
+			handedOff = true
+		}
+	}
+	if handedOff {
+		stopAnnouncing(directory.ErrKeepRegistered)
+	}
+	stop()
+	signal.Stop(hup)
+	signal.Stop(upgrade)
+	currentMu.Lock()
+	drainTimeout := time.Duration(current.DrainTimeout)
+	currentMu.Unlock()
+	slog.Info("Shutting down, waiting for running matches", "timeout", drainTimeout)
+
+	drainCtx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+	defer cancel()
+	// Stop accepting new connections first. WebSocket connections are
+	// hijacked and therefore not waited for by http.Server.
+	var wg sync.WaitGroup
+	for _, httpServer := range servers {
+		wg.Add(1)
+		go func(httpServer *http.Server) {
+			defer wg.Done()
+			if err := httpServer.Shutdown(drainCtx); err != nil {
+				slog.Warn("HTTP server shutdown", "err", err)
+			}
+		}(httpServer)
+	}
+	wg.Wait()
+	hubs.shutdown(drainCtx)
+	if announced != nil {
+		<-announced
 	}
+	slog.Info("Server stopped")
 }
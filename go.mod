@@ -1,5 +1,5 @@
 module github.com/zjx20/littlefighterhub
 
-go 1.20
+go 1.21
 
 require github.com/gorilla/websocket v1.5.3
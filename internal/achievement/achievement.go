@@ -0,0 +1,267 @@
+// Package achievement models the comma separated achievement list that LF2
+// clients send in JOIN and UPDATE_ACHIEVEMENTS, e.g.
+// "STAGE_1_EASY,GOLD_DAVIS,SURVIVAL_10,COOP_2P,MODE_VS1".
+package achievement
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Difficulty is a stage mode difficulty.
+type Difficulty int
+
+const (
+	Easy Difficulty = iota
+	Normal
+	Difficult
+	Crazy
+)
+
+var difficultyNames = []string{"EASY", "NORMAL", "DIFFICULT", "CRAZY"}
+
+func (d Difficulty) String() string {
+	if d < 0 || int(d) >= len(difficultyNames) {
+		return "UNKNOWN"
+	}
+	return difficultyNames[d]
+}
+
+// MarshalText encodes the difficulty by name, e.g. in JSON.
+func (d Difficulty) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+// Medal is a per character award.
+type Medal int
+
+const (
+	Gold Medal = iota
+	Silver
+	Bronze
+)
+
+var medalNames = []string{"GOLD", "SILVER", "BRONZE"}
+
+func (m Medal) String() string {
+	if m < 0 || int(m) >= len(medalNames) {
+		return "UNKNOWN"
+	}
+	return medalNames[m]
+}
+
+// MarshalText encodes the medal by name, e.g. in JSON.
+func (m Medal) MarshalText() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+// Stages is the number of stages in stage mode.
+const Stages = 5
+
+// Characters lists the characters medals can be awarded for, in the order
+// the game sends them.
+var Characters = []string{
+	"DAVIS", "WOODY", "DENNIS", "FREEZE", "FIREN", "LOUIS", "RUDOLF",
+	"HENRY", "JOHN", "DEEP", "BAT", "LOUISEX", "FIRZEN", "JULIAN",
+}
+
+// Modes lists the game mode achievements in the order the game sends them.
+var Modes = []string{"VS1", "VS2", "BATTLE1", "BATTLE2", "1_ON_1_CHAMP", "2_ON_2_CHAMP"}
+
+// Set is the parsed form of an achievement list.
+type Set struct {
+	// Stages holds, for each stage from 1 to 5, the difficulties cleared.
+	Stages [Stages][]Difficulty `json:"stages"`
+	// Medals holds the medals won per character.
+	Medals map[string][]Medal `json:"medals"`
+	// Survival holds the survival levels reached, e.g. 10, 20, ... 100.
+	Survival []int `json:"survival"`
+	// Coop holds the co-op player counts completed, e.g. 2, 3, 4, 6, 8.
+	Coop []int `json:"coop"`
+	// Modes holds the game modes completed, e.g. "VS1" or "1_ON_1_CHAMP".
+	Modes []string `json:"modes"`
+	// Unknown keeps the entries that could not be parsed, so nothing sent
+	// by newer clients is lost.
+	Unknown []string `json:"unknown,omitempty"`
+}
+
+// Parse parses a comma separated achievement list. Unrecognized entries are
+// kept in Unknown; Parse never fails.
+func Parse(list string) Set {
+	set := Set{Medals: make(map[string][]Medal)}
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		if !set.add(item) {
+			set.Unknown = append(set.Unknown, item)
+		}
+	}
+	set.normalize()
+	return set
+}
+
+func (s *Set) add(item string) bool {
+	switch {
+	case strings.HasPrefix(item, "STAGE_"):
+		num, diff, ok := strings.Cut(strings.TrimPrefix(item, "STAGE_"), "_")
+		stage, err := strconv.Atoi(num)
+		if !ok || err != nil || stage < 1 || stage > Stages {
+			return false
+		}
+		d := indexOf(difficultyNames, diff)
+		if d < 0 {
+			return false
+		}
+		s.Stages[stage-1] = append(s.Stages[stage-1], Difficulty(d))
+		return true
+	case strings.HasPrefix(item, "SURVIVAL_"):
+		level, err := strconv.Atoi(strings.TrimPrefix(item, "SURVIVAL_"))
+		if err != nil {
+			return false
+		}
+		s.Survival = append(s.Survival, level)
+		return true
+	case strings.HasPrefix(item, "COOP_"):
+		players, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(item, "COOP_"), "P"))
+		if err != nil || !strings.HasSuffix(item, "P") {
+			return false
+		}
+		s.Coop = append(s.Coop, players)
+		return true
+	case strings.HasPrefix(item, "MODE_"):
+		mode := strings.TrimPrefix(item, "MODE_")
+		if indexOf(Modes, mode) < 0 {
+			return false
+		}
+		s.Modes = append(s.Modes, mode)
+		return true
+	}
+
+	medal, char, ok := strings.Cut(item, "_")
+	m := indexOf(medalNames, medal)
+	if !ok || m < 0 || indexOf(Characters, char) < 0 {
+		return false
+	}
+	s.Medals[char] = append(s.Medals[char], Medal(m))
+	return true
+}
+
+// normalize sorts every list and drops duplicates so equal sets compare and
+// encode identically.
+func (s *Set) normalize() {
+	for i := range s.Stages {
+		s.Stages[i] = dedupe(s.Stages[i])
+	}
+	for char, medals := range s.Medals {
+		s.Medals[char] = dedupe(medals)
+	}
+	s.Survival = dedupe(s.Survival)
+	s.Coop = dedupe(s.Coop)
+	sort.Slice(s.Modes, func(i, j int) bool {
+		return indexOf(Modes, s.Modes[i]) < indexOf(Modes, s.Modes[j])
+	})
+	s.Modes = dedupe(s.Modes)
+}
+
+// HasStage reports whether stage (1 to 5) was cleared on the difficulty.
+func (s Set) HasStage(stage int, d Difficulty) bool {
+	if stage < 1 || stage > Stages {
+		return false
+	}
+	for _, got := range s.Stages[stage-1] {
+		if got == d {
+			return true
+		}
+	}
+	return false
+}
+
+// HasMedal reports whether the character won the medal.
+func (s Set) HasMedal(character string, m Medal) bool {
+	for _, got := range s.Medals[strings.ToUpper(character)] {
+		if got == m {
+			return true
+		}
+	}
+	return false
+}
+
+// MaxSurvival returns the highest survival level reached, or 0.
+func (s Set) MaxSurvival() int {
+	if len(s.Survival) == 0 {
+		return 0
+	}
+	return s.Survival[len(s.Survival)-1]
+}
+
+// HasMode reports whether the game mode, e.g. "VS1", was completed.
+func (s Set) HasMode(mode string) bool {
+	return indexOf(s.Modes, strings.ToUpper(mode)) >= 0
+}
+
+// Items returns the recognized achievements as they appear on the wire, in
+// the order the game sends them.
+func (s Set) Items() []string {
+	var items []string
+	for i, diffs := range s.Stages {
+		for _, d := range diffs {
+			items = append(items, "STAGE_"+strconv.Itoa(i+1)+"_"+d.String())
+		}
+	}
+	for m := range medalNames {
+		for _, char := range Characters {
+			if s.HasMedal(char, Medal(m)) {
+				items = append(items, medalNames[m]+"_"+char)
+			}
+		}
+	}
+	for _, level := range s.Survival {
+		items = append(items, "SURVIVAL_"+strconv.Itoa(level))
+	}
+	for _, players := range s.Coop {
+		items = append(items, "COOP_"+strconv.Itoa(players)+"P")
+	}
+	for _, mode := range s.Modes {
+		items = append(items, "MODE_"+mode)
+	}
+	return items
+}
+
+// Count returns the number of recognized achievements.
+func (s Set) Count() int {
+	return len(s.Items())
+}
+
+// String encodes the set as a comma separated list, including the unknown
+// entries at the end.
+func (s Set) String() string {
+	return strings.Join(append(s.Items(), s.Unknown...), ",")
+}
+
+func indexOf(list []string, v string) int {
+	for i, item := range list {
+		if item == v {
+			return i
+		}
+	}
+	return -1
+}
+
+func dedupe[T int | Difficulty | Medal | string](list []T) []T {
+	if len(list) == 0 {
+		return list
+	}
+	if _, isString := any(list[0]).(string); !isString {
+		sort.Slice(list, func(i, j int) bool { return list[i] < list[j] })
+	}
+	out := list[:1]
+	for _, v := range list[1:] {
+		if v != out[len(out)-1] {
+			out = append(out, v)
+		}
+	}
+	return out
+}
@@ -0,0 +1,122 @@
+package achievement
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name string
+		list string
+		want Set
+	}{
+		{"empty", "", Set{Medals: map[string][]Medal{}}},
+		{"blank entries", " , ,", Set{Medals: map[string][]Medal{}}},
+		{"stage", "STAGE_1_EASY,STAGE_5_CRAZY", Set{
+			Stages: [Stages][]Difficulty{{Easy}, nil, nil, nil, {Crazy}},
+			Medals: map[string][]Medal{},
+		}},
+		{"medals", "GOLD_DAVIS,BRONZE_DAVIS,SILVER_JULIAN", Set{
+			Medals: map[string][]Medal{"DAVIS": {Gold, Bronze}, "JULIAN": {Silver}},
+		}},
+		{"survival", "SURVIVAL_20,SURVIVAL_10", Set{
+			Medals:   map[string][]Medal{},
+			Survival: []int{10, 20},
+		}},
+		{"coop", "COOP_8P,COOP_2P", Set{
+			Medals: map[string][]Medal{},
+			Coop:   []int{2, 8},
+		}},
+		{"coop without suffix", "COOP_2", Set{
+			Medals:  map[string][]Medal{},
+			Unknown: []string{"COOP_2"},
+		}},
+		{"coop without count", "COOP_P", Set{
+			Medals:  map[string][]Medal{},
+			Unknown: []string{"COOP_P"},
+		}},
+		{"coop double suffix", "COOP_2PP", Set{
+			Medals:  map[string][]Medal{},
+			Unknown: []string{"COOP_2PP"},
+		}},
+		{"modes in game order", "MODE_2_ON_2_CHAMP,MODE_VS1", Set{
+			Medals: map[string][]Medal{},
+			Modes:  []string{"VS1", "2_ON_2_CHAMP"},
+		}},
+		{"unknown kept in order", "STAGE_6_EASY,PLATINUM_DAVIS,GOLD_BANDIT,MODE_VS3,STAGE_1_HARD", Set{
+			Medals:  map[string][]Medal{},
+			Unknown: []string{"STAGE_6_EASY", "PLATINUM_DAVIS", "GOLD_BANDIT", "MODE_VS3", "STAGE_1_HARD"},
+		}},
+		{"trimmed", " GOLD_DAVIS , SURVIVAL_10 ", Set{
+			Medals:   map[string][]Medal{"DAVIS": {Gold}},
+			Survival: []int{10},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Parse(tt.list); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Parse(%q) = %#v, want %#v", tt.list, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestItems(t *testing.T) {
+	set := Parse("MODE_VS1,COOP_2P,SURVIVAL_10,SILVER_WOODY,GOLD_JULIAN,GOLD_DAVIS,STAGE_2_EASY,STAGE_1_CRAZY,STAGE_1_EASY,X")
+	want := []string{
+		"STAGE_1_EASY", "STAGE_1_CRAZY", "STAGE_2_EASY",
+		"GOLD_DAVIS", "GOLD_JULIAN", "SILVER_WOODY",
+		"SURVIVAL_10", "COOP_2P", "MODE_VS1",
+	}
+	if got := set.Items(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Items = %q, want %q", got, want)
+	}
+	if got := set.Count(); got != len(want) {
+		t.Errorf("Count = %d, want %d", got, len(want))
+	}
+}
+
+func TestDedupe(t *testing.T) {
+	tests := []struct {
+		name string
+		list string
+		want string
+	}{
+		{"stages", "STAGE_1_EASY,STAGE_1_EASY,STAGE_1_NORMAL", "STAGE_1_EASY,STAGE_1_NORMAL"},
+		{"medals", "GOLD_DAVIS,GOLD_DAVIS", "GOLD_DAVIS"},
+		{"survival", "SURVIVAL_10,SURVIVAL_20,SURVIVAL_10", "SURVIVAL_10,SURVIVAL_20"},
+		{"coop", "COOP_2P,COOP_2P", "COOP_2P"},
+		{"modes", "MODE_VS2,MODE_VS1,MODE_VS2", "MODE_VS1,MODE_VS2"},
+		// Unknown entries are kept exactly as sent.
+		{"unknown", "X,X", "X,X"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Parse(tt.list).String(); got != tt.want {
+				t.Errorf("Parse(%q).String() = %q, want %q", tt.list, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestStringRoundTrip checks that String encodes a set that parses back to
+// the same set, unknown entries included, and that it is already canonical.
+func TestStringRoundTrip(t *testing.T) {
+	for _, list := range []string{
+		"",
+		"STAGE_1_EASY,GOLD_DAVIS,SURVIVAL_10,COOP_2P,MODE_VS1",
+		"MODE_1_ON_1_CHAMP,COOP_4P,COOP_3P,BRONZE_FIRZEN,GOLD_FIRZEN,STAGE_3_DIFFICULT,STAGE_3_EASY",
+		"NEW_THING,COOP_2,GOLD_DAVIS",
+	} {
+		set := Parse(list)
+		s := set.String()
+		again := Parse(s)
+		if !reflect.DeepEqual(again, set) {
+			t.Errorf("Parse(%q) = %#v, want %#v", s, again, set)
+		}
+		if got := again.String(); got != s {
+			t.Errorf("String after round trip = %q, want %q", got, s)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+// Package bot implements players driven by a script instead of a person, for
+// filling seats and for trying out matches alone.
+package bot
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/zjx20/littlefighterhub/pkg/lf2client"
+	"github.com/zjx20/littlefighterhub/pkg/protocol"
+)
+
+// FrameInterval is the time between two FRAMEs; LF2 runs at about 30 frames
+// per second.
+const FrameInterval = time.Second / 30
+
+const (
+	// How long the server may take to answer JOIN and LEAVE.
+	joinTimeout  = 5 * time.Second
+	leaveTimeout = time.Second
+	// How long a FRAME write may take.
+	writeTimeout = time.Second
+)
+
+// Bot is a player whose input comes from a Script.
+type Bot struct {
+	Name   string
+	Script Script
+	// Log is used for the bot's messages; slog.Default() when nil.
+	Log *slog.Logger
+}
+
+// Run joins the room over c and plays in it; see Join and Play.
+func (b *Bot) Run(ctx context.Context, c *lf2client.Client, roomID int) error {
+	if err := b.Join(ctx, c, roomID); err != nil {
+		return err
+	}
+	return b.Play(ctx, c, roomID)
+}
+
+// Join enters the room. The server does not answer a JOIN it refuses, so an
+// error is returned after a few seconds in that case.
+func (b *Bot) Join(ctx context.Context, c *lf2client.Client, roomID int) error {
+	joinCtx, cancel := context.WithTimeout(ctx, joinTimeout)
+	defer cancel()
+	if _, err := c.Join(joinCtx, protocol.Join{RoomID: roomID, Name: b.Name, Controls: [4]string{b.Name}}); err != nil {
+		return fmt.Errorf("joining room %d: %w", roomID, err)
+	}
+	b.log(c, roomID).Info("Bot joined room")
+	return nil
+}
+
+func (b *Bot) log(c *lf2client.Client, roomID int) *slog.Logger {
+	log := b.Log
+	if log == nil {
+		log = slog.Default()
+	}
+	return log.With("bot", b.Name, "player_id", c.ID(), "room_id", roomID)
+}
+
+// Play follows the room the bot has joined: every ROOM_NOW_STARTED starts
+// sending FRAMEs from seq 0. It returns when the bot is no longer in the
+// room, when the connection ends, or when ctx is done, in which case the bot
+// leaves the room first.
+func (b *Bot) Play(ctx context.Context, c *lf2client.Client, roomID int) error {
+	log := b.log(c, roomID)
+
+	// The checksum of the last FRAME of another player. A bot cannot
+	// compute the game state, so it repeats what the others report.
+	var checksum atomic.Int64
+	var playing sync.WaitGroup
+	stopPlaying := func() {}
+	defer func() {
+		stopPlaying()
+		playing.Wait()
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			stopPlaying()
+			playing.Wait()
+			leaveCtx, cancel := context.WithTimeout(context.Background(), leaveTimeout)
+			c.Leave(leaveCtx, roomID)
+			cancel()
+			log.Info("Bot left room")
+			return nil
+		case ev, ok := <-c.Events():
+			if !ok {
+				return c.Err()
+			}
+			switch m := ev.Message.(type) {
+			case *protocol.RoomNowStarted:
+				if m.RoomID != roomID {
+					continue
+				}
+				stopPlaying()
+				playing.Wait()
+				playCtx, cancel := context.WithCancel(ctx)
+				stopPlaying = cancel
+				playing.Add(1)
+				go func() {
+					defer playing.Done()
+					b.play(playCtx, c, &checksum, log)
+				}()
+			case *protocol.Frame:
+				checksum.Store(int64(m.Checksum))
+			case *protocol.PlayerList:
+				if m.RoomID == roomID && !hasPlayer(m, c.ID()) {
+					log.Info("Bot is no longer in the room")
+					return nil
+				}
+			case *protocol.LeftRoom:
+				if m.RoomID == roomID {
+					return nil
+				}
+			}
+		}
+	}
+}
+
+// play sends a FRAME per FrameInterval until ctx is done. It runs apart from
+// the event loop, so reading never waits for a write.
+func (b *Bot) play(ctx context.Context, c *lf2client.Client, checksum *atomic.Int64, log *slog.Logger) {
+	ticker := time.NewTicker(FrameInterval)
+	defer ticker.Stop()
+	for seq := 0; ; seq++ {
+		frame := protocol.Frame{Seq: seq, Keys: b.Script.Keys(seq), Checksum: int(checksum.Load())}
+		wctx, cancel := context.WithTimeout(ctx, writeTimeout)
+		err := c.SendFrame(wctx, frame)
+		cancel()
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Warn("Bot failed to send FRAME", "err", err)
+			}
+			return
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func hasPlayer(list *protocol.PlayerList, id int) bool {
+	for _, p := range list.Players {
+		if p.ID == id {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,151 @@
+package bot
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"strings"
+
+	"github.com/zjx20/littlefighterhub/pkg/protocol"
+)
+
+// Script decides the keys a bot presses.
+type Script interface {
+	// Keys returns the keys of the four local controls for frame seq. It is
+	// called with increasing seqs from a single goroutine.
+	Keys(seq int) [4]int
+}
+
+// Idle presses nothing.
+type Idle struct{}
+
+func (Idle) Keys(int) [4]int { return [4]int{} }
+
+// Random mashes the keys of the first control, changing them every few
+// frames like a player would.
+type Random struct {
+	rng  *rand.Rand
+	keys int
+}
+
+// NewRandom returns a Random script seeded with seed.
+func NewRandom(seed int64) *Random {
+	return &Random{rng: rand.New(rand.NewSource(seed))}
+}
+
+func (r *Random) Keys(int) [4]int {
+	if r.rng.Intn(5) == 0 {
+		// Up, down, left, right, attack, jump and defend.
+		r.keys = r.rng.Intn(1 << 7)
+	}
+	return [4]int{r.keys}
+}
+
+// Replay presses the keys recorded for a player, then idles.
+type Replay struct {
+	frames [][4]int
+}
+
+// Frames returns the number of recorded frames.
+func (r *Replay) Frames() int { return len(r.frames) }
+
+func (r *Replay) Keys(seq int) [4]int {
+	if seq < len(r.frames) {
+		return r.frames[seq]
+	}
+	return [4]int{}
+}
+
+// transcriptLine is the part of a room transcript line a replay needs.
+type transcriptLine struct {
+	Direction string `json:"direction"`
+	PlayerID  int    `json:"player_id"`
+	Data      string `json:"data"`
+}
+
+// LoadReplay reads the FRAMEs a player sent from a room transcript, as
+// written by the room server's room_logs feature. With playerID 0 the first
+// player that sent a FRAME is used. Only the last match of the player is
+// kept, as FRAME seqs restart with every match.
+func LoadReplay(r io.Reader, playerID int) (*Replay, error) {
+	replay := &Replay{}
+	sc := bufio.NewScanner(r)
+	sc.Buffer(nil, 1<<20)
+	for sc.Scan() {
+		var line transcriptLine
+		if err := json.Unmarshal(sc.Bytes(), &line); err != nil {
+			return nil, fmt.Errorf("not a room transcript: %w", err)
+		}
+		if line.Direction != "in" || !strings.HasPrefix(line.Data, protocol.CmdFrame+"\n") {
+			continue
+		}
+		m, err := protocol.ParseClient([]byte(line.Data))
+		if err != nil {
+			continue
+		}
+		frame := m.(*protocol.Frame)
+		if playerID == 0 {
+			playerID = frame.PlayerID
+		}
+		if frame.PlayerID != playerID {
+			continue
+		}
+		if frame.Seq < len(replay.frames) {
+			replay.frames = replay.frames[:0]
+		}
+		for len(replay.frames) < frame.Seq {
+			// A FRAME that was not recorded; repeat the last keys.
+			var last [4]int
+			if n := len(replay.frames); n > 0 {
+				last = replay.frames[n-1]
+			}
+			replay.frames = append(replay.frames, last)
+		}
+		replay.frames = append(replay.frames, frame.Keys)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if len(replay.frames) == 0 {
+		return nil, errors.New("no FRAMEs recorded for the player")
+	}
+	return replay, nil
+}
+
+// LoadReplayFile reads a replay from a room transcript file.
+func LoadReplayFile(name string, playerID int) (*Replay, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return LoadReplay(f, playerID)
+}
+
+// Script names accepted by NewScript.
+const (
+	ScriptIdle   = "idle"
+	ScriptRandom = "random"
+	ScriptReplay = "replay"
+)
+
+// NewScript returns the script called name. A replay plays the first player
+// of the transcript file replayFile.
+func NewScript(name, replayFile string) (Script, error) {
+	switch name {
+	case ScriptIdle, "":
+		return Idle{}, nil
+	case ScriptRandom:
+		return NewRandom(rand.Int63()), nil
+	case ScriptReplay:
+		if replayFile == "" {
+			return nil, errors.New("replay needs a room transcript")
+		}
+		return LoadReplayFile(replayFile, 0)
+	}
+	return nil, fmt.Errorf("unknown script %q, want %s, %s or %s", name, ScriptIdle, ScriptRandom, ScriptReplay)
+}
@@ -0,0 +1,123 @@
+// Package capture records the WebSocket traffic between LF2 clients and a
+// room server and compares recordings, for working out how the original
+// lf2-room-server behaves.
+package capture
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Directions of a Record. They are seen from the server, as in the room
+// transcripts of the room server.
+const (
+	// In is a message from the client to the server.
+	In = "in"
+	// Out is a message from the server to the client.
+	Out = "out"
+	// Open marks a new client connection; Data is the client's address.
+	Open = "open"
+	// Close marks the end of a connection; Data says which side ended it.
+	Close = "close"
+)
+
+// Record is one line of a capture file.
+type Record struct {
+	Time time.Time `json:"time"`
+	// Session numbers the client connections from 1 in the order they
+	// were made.
+	Session   int    `json:"session"`
+	Direction string `json:"direction"`
+	Data      string `json:"data"`
+}
+
+// Writer appends records to a capture file as JSON lines. It is safe for
+// concurrent use.
+type Writer struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+	err error
+}
+
+func NewWriter(w io.Writer) *Writer {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	return &Writer{enc: enc}
+}
+
+// Write appends a record stamped with the current time.
+func (w *Writer) Write(session int, direction, data string) error {
+	return w.WriteRecord(Record{Time: time.Now(), Session: session, Direction: direction, Data: data})
+}
+
+// WriteRecord appends a record. After a failed write all further writes
+// fail with the same error.
+func (w *Writer) WriteRecord(rec Record) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err == nil {
+		w.err = w.enc.Encode(rec)
+	}
+	return w.err
+}
+
+// Err returns the error of the first failed write.
+func (w *Writer) Err() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.err
+}
+
+// Read parses a capture file.
+func Read(r io.Reader) ([]Record, error) {
+	var records []Record
+	sc := bufio.NewScanner(r)
+	sc.Buffer(nil, 1<<20)
+	for line := 1; sc.Scan(); line++ {
+		if len(sc.Bytes()) == 0 {
+			continue
+		}
+		var rec Record
+		if err := json.Unmarshal(sc.Bytes(), &rec); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		records = append(records, rec)
+	}
+	return records, sc.Err()
+}
+
+// ReadFile parses the capture file name.
+func ReadFile(name string) ([]Record, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	records, err := Read(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return records, nil
+}
+
+// WriteFile writes records to the capture file name, replacing it.
+func WriteFile(name string, records []Record) error {
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	w := NewWriter(f)
+	for _, rec := range records {
+		w.WriteRecord(rec)
+	}
+	if err := w.Err(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,128 @@
+package capture
+
+import (
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/zjx20/littlefighterhub/pkg/protocol"
+)
+
+// DiffOptions tune Diff.
+type DiffOptions struct {
+	// Frames includes relayed FRAMEs, which are left out by default: they
+	// are passed through unchanged and their interleaving with the other
+	// messages depends on timing.
+	Frames bool
+}
+
+// Diff compares what every session received in two captures of the same
+// client actions, such as a capture of the original server and its Replay
+// against ours. Player IDs and the server's clock are normalized first. The
+// differences are written to w as removed (-, only in want) and added (+,
+// only in got) messages, and Diff reports whether there were any.
+func Diff(w io.Writer, want, got []Record, opts DiffOptions) bool {
+	wantMsgs, gotMsgs := received(want, opts), received(got, opts)
+	sessions := make(map[int]bool)
+	for s := range wantMsgs {
+		sessions[s] = true
+	}
+	for s := range gotMsgs {
+		sessions[s] = true
+	}
+	order := make([]int, 0, len(sessions))
+	for s := range sessions {
+		order = append(order, s)
+	}
+	sort.Ints(order)
+
+	differ := false
+	for _, s := range order {
+		edits := diffLines(wantMsgs[s], gotMsgs[s])
+		if edits == nil {
+			continue
+		}
+		differ = true
+		fmt.Fprintf(w, "session %d:\n", s)
+		for _, e := range edits {
+			fmt.Fprintf(w, "%c %q\n", e.op, e.line)
+		}
+	}
+	return differ
+}
+
+// received returns the normalized messages the server sent, per session.
+func received(records []Record, opts DiffOptions) map[int][]string {
+	ids := playerIDs(records)
+	msgs := make(map[int][]string)
+	for _, rec := range records {
+		if rec.Direction != Out {
+			continue
+		}
+		if !opts.Frames && protocol.Command([]byte(rec.Data)) == protocol.CmdFrame {
+			continue
+		}
+		msgs[rec.Session] = append(msgs[rec.Session], normalize(rec.Data, ids))
+	}
+	return msgs
+}
+
+type edit struct {
+	op   byte // ' ', '-' or '+'
+	line string
+}
+
+// diffLines returns the edits turning a into b, with a line of context
+// around every change, or nil when they are equal.
+func diffLines(a, b []string) []edit {
+	// lcs[i][j] is the length of the longest common subsequence of a[i:]
+	// and b[j:].
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var all []edit
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			all = append(all, edit{' ', a[i]})
+			i++
+			j++
+		case i < len(a) && (j == len(b) || lcs[i+1][j] >= lcs[i][j+1]):
+			all = append(all, edit{'-', a[i]})
+			i++
+		default:
+			all = append(all, edit{'+', b[j]})
+			j++
+		}
+	}
+
+	var out []edit
+	changed := false
+	for k, e := range all {
+		near := e.op != ' ' ||
+			(k > 0 && all[k-1].op != ' ') ||
+			(k+1 < len(all) && all[k+1].op != ' ')
+		if near {
+			out = append(out, e)
+		}
+		if e.op != ' ' {
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+	return out
+}
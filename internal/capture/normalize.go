@@ -0,0 +1,101 @@
+package capture
+
+import (
+	"github.com/zjx20/littlefighterhub/pkg/protocol"
+)
+
+// playerIDs maps the player ID of every session of a capture, taken from the
+// YOUR_ID the session received, to its session number.
+func playerIDs(records []Record) map[int]int {
+	ids := make(map[int]int)
+	for _, rec := range records {
+		if rec.Direction != Out || protocol.Command([]byte(rec.Data)) != protocol.CmdYourID {
+			continue
+		}
+		if m, err := protocol.ParseServer([]byte(rec.Data)); err == nil {
+			ids[m.(*protocol.YourID).PlayerID] = rec.Session
+		}
+	}
+	return ids
+}
+
+// normalize rewrites a message the server sent so that captures of the same
+// client actions against different servers compare equal: player IDs are
+// replaced with session numbers through ids and the server's clock is
+// zeroed. Messages that do not parse are returned as they are.
+func normalize(data string, ids map[int]int) string {
+	m, err := protocol.ParseServer([]byte(data))
+	if err != nil {
+		return data
+	}
+	id := func(playerID int) int {
+		if session, ok := ids[playerID]; ok {
+			return session
+		}
+		return playerID
+	}
+	switch m := m.(type) {
+	case *protocol.YourID:
+		m.PlayerID = id(m.PlayerID)
+	case *protocol.List:
+		for i := range m.Rooms {
+			m.Rooms[i].Elapsed = 0
+		}
+	case *protocol.PlayerList:
+		for i := range m.Players {
+			m.Players[i].ID = id(m.Players[i].ID)
+		}
+	case *protocol.RoomNowStarted:
+		m.Elapsed = 0
+	case *protocol.Chat:
+		m.PlayerID = id(m.PlayerID)
+	case *protocol.RoomList:
+		for i := range m.Rooms {
+			m.Rooms[i].Elapsed = 0
+			for j := range m.Rooms[i].Players {
+				m.Rooms[i].Players[j].ID = id(m.Rooms[i].Players[j].ID)
+			}
+		}
+	case *protocol.Frame:
+		m.PlayerID = id(m.PlayerID)
+	case *protocol.Away:
+		m.PlayerID = id(m.PlayerID)
+	case *protocol.UpdateControlNames:
+		m.PlayerID = id(m.PlayerID)
+	}
+	out, err := protocol.Encode(m)
+	if err != nil {
+		return data
+	}
+	return string(out)
+}
+
+// rewriteClient replaces the player ID in a message a client sends, for
+// replaying it against a server that assigned a different ID. Messages
+// without a player ID, or that do not parse, are returned as they are.
+func rewriteClient(data string, id func(int) int) string {
+	m, err := protocol.ParseClient([]byte(data))
+	if err != nil {
+		return data
+	}
+	switch m := m.(type) {
+	case *protocol.Frame:
+		m.PlayerID = id(m.PlayerID)
+	case *protocol.Away:
+		m.PlayerID = id(m.PlayerID)
+	case *protocol.UpdateControlNames:
+		m.PlayerID = id(m.PlayerID)
+	case *protocol.UpdateAchievements:
+		if m.PlayerID == 0 {
+			return data
+		}
+		m.PlayerID = id(m.PlayerID)
+	default:
+		return data
+	}
+	out, err := protocol.Encode(m)
+	if err != nil {
+		return data
+	}
+	return string(out)
+}
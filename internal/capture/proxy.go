@@ -0,0 +1,114 @@
+package capture
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Time allowed to pass a close frame on to the other side.
+const closeWriteWait = time.Second
+
+// Proxy sits between LF2 clients and an upstream room server. Every client
+// connection gets its own upstream connection, and the messages relayed in
+// both directions are recorded to Capture.
+type Proxy struct {
+	// Upstream is the WebSocket URL of the room server, e.g.
+	// ws://127.0.0.1:8080/.
+	Upstream string
+	Capture  *Writer
+	// Log is used for connection events; slog.Default() when nil.
+	Log *slog.Logger
+
+	sessions atomic.Int64
+	upgrader websocket.Upgrader
+	// captureFailed is set once writing the capture failed, which is
+	// logged only once.
+	captureFailed atomic.Bool
+}
+
+func (p *Proxy) log() *slog.Logger {
+	if p.Log == nil {
+		return slog.Default()
+	}
+	return p.Log
+}
+
+func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// Connect upstream first, so a client is refused the way the room
+	// server refused us.
+	server, resp, err := websocket.DefaultDialer.DialContext(r.Context(), p.Upstream, nil)
+	if err != nil {
+		p.log().Warn("Upstream connection failed", "remote_addr", r.RemoteAddr, "err", err)
+		status := http.StatusBadGateway
+		if resp != nil {
+			status = resp.StatusCode
+		}
+		http.Error(w, "Upstream connection failed", status)
+		return
+	}
+	defer server.Close()
+
+	p.upgrader.CheckOrigin = func(*http.Request) bool { return true }
+	client, err := p.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		p.log().Warn("Upgrade failed", "remote_addr", r.RemoteAddr, "err", err)
+		return
+	}
+	defer client.Close()
+
+	session := int(p.sessions.Add(1))
+	log := p.log().With("session", session)
+	log.Info("Client connected", "remote_addr", r.RemoteAddr)
+	p.record(log, session, Open, r.RemoteAddr)
+
+	// Each side is written to by a single pump; close frames go through
+	// WriteControl, which may be called concurrently.
+	ended := make(chan string, 2)
+	go p.pump(log, session, client, server, In, ended)
+	go p.pump(log, session, server, client, Out, ended)
+	reason := <-ended
+	p.record(log, session, Close, reason)
+	log.Info("Connection closed", "reason", reason)
+	client.Close()
+	server.Close()
+	<-ended
+}
+
+// pump relays the messages read from src to dst until src fails, then
+// passes the close on to dst and reports why the connection ended.
+func (p *Proxy) pump(log *slog.Logger, session int, src, dst *websocket.Conn, direction string, ended chan<- string) {
+	side, peer := "client", "server"
+	if direction == Out {
+		side, peer = peer, side
+	}
+	for {
+		typ, data, err := src.ReadMessage()
+		if err != nil {
+			closeMsg := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")
+			var ce *websocket.CloseError
+			if errors.As(err, &ce) {
+				closeMsg = websocket.FormatCloseMessage(ce.Code, ce.Text)
+			}
+			dst.WriteControl(websocket.CloseMessage, closeMsg, time.Now().Add(closeWriteWait))
+			ended <- fmt.Sprintf("%s: %v", side, err)
+			return
+		}
+		p.record(log, session, direction, string(data))
+		if err := dst.WriteMessage(typ, data); err != nil {
+			ended <- fmt.Sprintf("writing to %s: %v", peer, err)
+			return
+		}
+	}
+}
+
+func (p *Proxy) record(log *slog.Logger, session int, direction, data string) {
+	if err := p.Capture.Write(session, direction, data); err != nil && !p.captureFailed.Swap(true) {
+		log.Error("Failed to write capture", "err", err)
+	}
+}
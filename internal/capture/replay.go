@@ -0,0 +1,157 @@
+package capture
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/zjx20/littlefighterhub/pkg/protocol"
+)
+
+// ReplayOptions tune Replay.
+type ReplayOptions struct {
+	// Speed scales the pace of the capture; 2 replays it twice as fast.
+	// Zero means 1.
+	Speed float64
+	// Wait is how long to keep listening after the last message was sent.
+	Wait time.Duration
+	// Dialer connects the clients; websocket.DefaultDialer when nil.
+	Dialer *websocket.Dialer
+}
+
+// replaySession is a client connection of a replay.
+type replaySession struct {
+	conn *websocket.Conn
+	// id is the player ID the server assigned. It is set before idCh is
+	// closed, which happens when YOUR_ID arrives or the connection ends.
+	id   int
+	idCh chan struct{}
+}
+
+// Replay plays the clients of a capture against the server at url: it opens
+// a connection for every session and sends what the client sent, at the
+// pace of the capture. It returns a capture of the replay with the same
+// session numbers, which Diff can compare with the original.
+func Replay(ctx context.Context, url string, records []Record, opts ReplayOptions) ([]Record, error) {
+	if len(records) == 0 {
+		return nil, nil
+	}
+	if opts.Speed <= 0 {
+		opts.Speed = 1
+	}
+	dialer := opts.Dialer
+	if dialer == nil {
+		dialer = websocket.DefaultDialer
+	}
+	// The sessions of the capture by player ID, to find the connection
+	// a player ID in a client message refers to.
+	capturedIDs := playerIDs(records)
+
+	var mu sync.Mutex
+	var out []Record
+	add := func(session int, direction, data string) {
+		mu.Lock()
+		defer mu.Unlock()
+		out = append(out, Record{Time: time.Now(), Session: session, Direction: direction, Data: data})
+	}
+
+	sessions := make(map[int]*replaySession)
+	var readers sync.WaitGroup
+	defer func() {
+		for _, s := range sessions {
+			s.conn.Close()
+		}
+		readers.Wait()
+	}()
+	// id maps a player ID of the capture to the one the server assigned to
+	// the same session in the replay.
+	id := func(captured int) int {
+		if s, ok := sessions[capturedIDs[captured]]; ok {
+			<-s.idCh
+			return s.id
+		}
+		return captured
+	}
+
+	start, base := time.Now(), records[0].Time
+	for _, rec := range records {
+		at := start.Add(time.Duration(float64(rec.Time.Sub(base)) / opts.Speed))
+		select {
+		case <-time.After(time.Until(at)):
+		case <-ctx.Done():
+			return out, ctx.Err()
+		}
+		s := sessions[rec.Session]
+		switch rec.Direction {
+		case Open:
+			conn, _, err := dialer.DialContext(ctx, url, nil)
+			if err != nil {
+				return out, fmt.Errorf("session %d: %w", rec.Session, err)
+			}
+			add(rec.Session, Open, conn.LocalAddr().String())
+			s = &replaySession{conn: conn, idCh: make(chan struct{})}
+			sessions[rec.Session] = s
+			readers.Add(1)
+			go func(session int) {
+				defer readers.Done()
+				readReplay(s, session, add)
+			}(rec.Session)
+		case In:
+			if s == nil {
+				continue
+			}
+			data := rewriteClient(rec.Data, id)
+			add(rec.Session, In, data)
+			if err := s.conn.WriteMessage(websocket.TextMessage, []byte(data)); err != nil {
+				return out, fmt.Errorf("session %d: %w", rec.Session, err)
+			}
+		case Close:
+			if s == nil {
+				continue
+			}
+			s.conn.WriteControl(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(closeWriteWait))
+		}
+	}
+
+	select {
+	case <-time.After(opts.Wait):
+	case <-ctx.Done():
+	}
+	for _, s := range sessions {
+		s.conn.Close()
+	}
+	readers.Wait()
+	mu.Lock()
+	defer mu.Unlock()
+	return out, nil
+}
+
+// readReplay records the messages the server sends to a session, noting the
+// player ID from YOUR_ID.
+func readReplay(s *replaySession, session int, add func(session int, direction, data string)) {
+	known := false
+	defer func() {
+		if !known {
+			close(s.idCh)
+		}
+	}()
+	for {
+		_, data, err := s.conn.ReadMessage()
+		if err != nil {
+			add(session, Close, err.Error())
+			return
+		}
+		add(session, Out, string(data))
+		if !known && protocol.Command(data) == protocol.CmdYourID {
+			if m, err := protocol.ParseServer(data); err == nil {
+				s.id = m.(*protocol.YourID).PlayerID
+			}
+			known = true
+			close(s.idCh)
+		}
+	}
+}
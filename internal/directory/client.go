@@ -0,0 +1,150 @@
+package directory
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// ErrKeepRegistered, as the cause of cancelling a Heartbeat's context, stops
+// it without deregistering, for when another process takes over the address.
+var ErrKeepRegistered = errors.New("directory: keep registered")
+
+// Client talks to a directory.
+type Client struct {
+	// URL is the base URL of the directory, e.g. "https://dir.example.com".
+	URL string
+	// Token is sent as a bearer token when registering.
+	Token string
+	// HTTP is the client used for requests; http.DefaultClient when nil.
+	HTTP *http.Client
+}
+
+func (c *Client) endpoint(query url.Values) string {
+	u := strings.TrimSuffix(c.URL, "/") + APIPath
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	return u
+}
+
+func (c *Client) do(ctx context.Context, method, u string, body any) (*http.Response, error) {
+	var r io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		r = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, r)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+	hc := c.HTTP
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+	resp, err := hc.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("%s %s: %s: %s", method, u, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return resp, nil
+}
+
+// List returns the live servers matching f.
+func (c *Client) List(ctx context.Context, f Filter) ([]Server, error) {
+	q := url.Values{}
+	if f.Kind != "" {
+		q.Set("kind", f.Kind)
+	}
+	if f.Region != "" {
+		q.Set("region", f.Region)
+	}
+	resp, err := c.do(ctx, http.MethodGet, c.endpoint(q), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var servers []Server
+	if err := json.NewDecoder(resp.Body).Decode(&servers); err != nil {
+		return nil, fmt.Errorf("decode server list: %w", err)
+	}
+	return servers, nil
+}
+
+// Register adds or refreshes a server.
+func (c *Client) Register(ctx context.Context, s Server) error {
+	resp, err := c.do(ctx, http.MethodPost, c.endpoint(nil), s)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Deregister removes a server.
+func (c *Client) Deregister(ctx context.Context, address string) error {
+	resp, err := c.do(ctx, http.MethodDelete, c.endpoint(url.Values{"address": {address}}), nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Heartbeat registers the server returned by status every interval until ctx
+// is done, then deregisters it unless the cause is ErrKeepRegistered.
+// Failures are logged and retried on the next beat.
+func (c *Client) Heartbeat(ctx context.Context, interval time.Duration, log *slog.Logger, status func() Server) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	registered := false
+	var address string
+	for ctx.Err() == nil {
+		s := status()
+		address = s.Address
+		if err := c.Register(ctx, s); err != nil {
+			if ctx.Err() == nil {
+				log.Warn("Directory registration failed", "directory", c.URL, "err", err)
+			}
+			registered = false
+		} else if !registered {
+			log.Info("Registered with directory", "directory", c.URL, "name", s.Name, "address", s.Address)
+			registered = true
+		}
+		select {
+		case <-ctx.Done():
+		case <-ticker.C:
+		}
+	}
+
+	if errors.Is(context.Cause(ctx), ErrKeepRegistered) {
+		return
+	}
+	// ctx is done; use a fresh one so the deregistration is still sent.
+	dctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := c.Deregister(dctx, address); err != nil {
+		log.Warn("Directory deregistration failed", "directory", c.URL, "err", err)
+	}
+}
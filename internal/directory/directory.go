@@ -0,0 +1,161 @@
+// Package directory implements a registry where room servers and proxy
+// servers announce themselves, so players can pick one without knowing its
+// address in advance. Servers heartbeat by registering again periodically and
+// are dropped once they stop.
+package directory
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Kinds of servers listed in the directory.
+const (
+	KindRoom  = "room"
+	KindProxy = "proxy"
+)
+
+// Server is a directory entry. Servers are identified by Address.
+type Server struct {
+	Kind string `json:"kind"`
+	Name string `json:"name"`
+	// Address is the URL clients connect to, e.g. "ws://lf2.example.com:8080".
+	Address string `json:"address"`
+	// Region is a free form location tag such as "eu" or "cn-east".
+	Region string `json:"region,omitempty"`
+
+	// Rooms is the number of rooms; for proxy servers, the registered hosts.
+	Rooms int `json:"rooms"`
+	// ActiveRooms counts the rooms with players in them.
+	ActiveRooms int `json:"active_rooms"`
+	// Players is the number of players in rooms; for proxy servers, the
+	// active tunnels.
+	Players int `json:"players"`
+	// Capacity is the maximum number of players, or 0 if unlimited.
+	Capacity int `json:"capacity"`
+
+	// LastSeen is set by the directory when the server registers.
+	LastSeen time.Time `json:"last_seen"`
+}
+
+// Validate checks the fields a server must provide when registering.
+func (s Server) Validate() error {
+	switch s.Kind {
+	case KindRoom, KindProxy:
+	default:
+		return fmt.Errorf("unknown kind %q", s.Kind)
+	}
+	if s.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	u, err := url.Parse(s.Address)
+	if err != nil || u.Host == "" {
+		return fmt.Errorf("invalid address %q", s.Address)
+	}
+	switch u.Scheme {
+	case "ws", "wss", "http", "https":
+	default:
+		return fmt.Errorf("invalid address %q: scheme must be ws, wss, http or https", s.Address)
+	}
+	return nil
+}
+
+// Free returns the number of free player slots, or -1 if unlimited.
+func (s Server) Free() int {
+	if s.Capacity == 0 {
+		return -1
+	}
+	return s.Capacity - s.Players
+}
+
+// Filter selects servers in List. Empty fields match everything.
+type Filter struct {
+	Kind   string
+	Region string
+}
+
+func (f Filter) match(s Server) bool {
+	return (f.Kind == "" || f.Kind == s.Kind) &&
+		(f.Region == "" || strings.EqualFold(f.Region, s.Region))
+}
+
+// Registry holds the registered servers.
+type Registry struct {
+	ttl time.Duration
+
+	mu      sync.Mutex
+	servers map[string]Server
+}
+
+// NewRegistry creates a registry dropping servers that have not registered
+// for ttl.
+func NewRegistry(ttl time.Duration) *Registry {
+	return &Registry{ttl: ttl, servers: make(map[string]Server)}
+}
+
+// TTL returns how long a registration lasts without a heartbeat.
+func (r *Registry) TTL() time.Duration {
+	return r.ttl
+}
+
+// Register adds or refreshes a server. It reports whether the server is new.
+func (r *Registry) Register(s Server) (bool, error) {
+	if err := s.Validate(); err != nil {
+		return false, err
+	}
+	s.LastSeen = time.Now()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, known := r.servers[s.Address]
+	r.servers[s.Address] = s
+	return !known, nil
+}
+
+// Remove drops a server, reporting whether it was registered.
+func (r *Registry) Remove(address string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, ok := r.servers[address]
+	delete(r.servers, address)
+	return ok
+}
+
+// List returns the live servers matching f, by region, then name.
+func (r *Registry) List(f Filter) []Server {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	servers := []Server{}
+	for _, s := range r.servers {
+		if f.match(s) && time.Since(s.LastSeen) < r.ttl {
+			servers = append(servers, s)
+		}
+	}
+	sort.Slice(servers, func(i, j int) bool {
+		if servers[i].Region != servers[j].Region {
+			return servers[i].Region < servers[j].Region
+		}
+		if servers[i].Name != servers[j].Name {
+			return servers[i].Name < servers[j].Name
+		}
+		return servers[i].Address < servers[j].Address
+	})
+	return servers
+}
+
+// Expire drops the servers that missed their heartbeats and returns them.
+func (r *Registry) Expire() []Server {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var expired []Server
+	for addr, s := range r.servers {
+		if time.Since(s.LastSeen) >= r.ttl {
+			expired = append(expired, s)
+			delete(r.servers, addr)
+		}
+	}
+	return expired
+}
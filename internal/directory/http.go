@@ -0,0 +1,126 @@
+package directory
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// APIPath is where servers are registered and listed.
+const APIPath = "/api/servers"
+
+// Handler serves the directory:
+//
+//	GET    /api/servers?kind=&region=  live servers as JSON
+//	POST   /api/servers                register or heartbeat, body is a Server
+//	DELETE /api/servers?address=       deregister
+//	GET    /                           HTML listing
+//
+// When token is set, POST and DELETE require it as a bearer token.
+func (r *Registry) Handler(token string, log *slog.Logger) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(APIPath, func(w http.ResponseWriter, req *http.Request) {
+		switch req.Method {
+		case http.MethodGet:
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(r.List(filterFromQuery(req)))
+		case http.MethodPost:
+			if !authorized(req, token) {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			var s Server
+			if err := json.NewDecoder(http.MaxBytesReader(w, req.Body, 64<<10)).Decode(&s); err != nil {
+				http.Error(w, "invalid request: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			added, err := r.Register(s)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if added {
+				log.Info("Server registered", "kind", s.Kind, "name", s.Name, "address", s.Address,
+					"region", s.Region, "remote_addr", req.RemoteAddr)
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]string{"ttl": r.TTL().String()})
+		case http.MethodDelete:
+			if !authorized(req, token) {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			address := req.URL.Query().Get("address")
+			if !r.Remove(address) {
+				http.NotFound(w, req)
+				return
+			}
+			log.Info("Server deregistered", "address", address)
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.Header().Set("Allow", "GET, POST, DELETE")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+	mux.HandleFunc("/", func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/" {
+			http.NotFound(w, req)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		listingTemplate.Execute(w, r.List(filterFromQuery(req)))
+	})
+	return mux
+}
+
+func filterFromQuery(req *http.Request) Filter {
+	q := req.URL.Query()
+	return Filter{Kind: q.Get("kind"), Region: q.Get("region")}
+}
+
+func authorized(req *http.Request, token string) bool {
+	if token == "" {
+		return true
+	}
+	got := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(got), []byte(token)) == 1
+}
+
+var listingTemplate = template.Must(template.New("listing").Funcs(template.FuncMap{
+	"ago": func(t time.Time) string { return time.Since(t).Round(time.Second).String() },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta http-equiv="refresh" content="30">
+<title>Little Fighter Hub servers</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; }
+th, td { border: 1px solid #ccc; padding: 4px 10px; text-align: left; }
+</style>
+</head>
+<body>
+<h1>Little Fighter Hub servers</h1>
+{{if .}}
+<table>
+<tr><th>Name</th><th>Kind</th><th>Region</th><th>Address</th><th>Rooms in use</th><th>Players</th><th>Last seen</th></tr>
+{{range .}}
+<tr>
+<td>{{.Name}}</td><td>{{.Kind}}</td><td>{{.Region}}</td><td><code>{{.Address}}</code></td>
+<td>{{.ActiveRooms}} / {{.Rooms}}</td>
+<td>{{.Players}}{{if .Capacity}} / {{.Capacity}}{{end}}</td>
+<td>{{ago .LastSeen}} ago</td>
+</tr>
+{{end}}
+</table>
+{{else}}
+<p>No servers are online.</p>
+{{end}}
+</body>
+</html>
+`))
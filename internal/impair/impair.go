@@ -0,0 +1,222 @@
+// Package impair simulates a poor network link by holding messages back
+// before delivering them: a fixed delay with random jitter, a bandwidth cap,
+// and periodic stalls during which nothing gets through. Messages are always
+// delivered in order, as over a TCP connection.
+package impair
+
+import (
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Settings describe an impaired link. The zero value impairs nothing.
+type Settings struct {
+	// DelayMS is added to every message.
+	DelayMS int `json:"delay_ms"`
+	// JitterMS is the most that is added to the delay at random.
+	JitterMS int `json:"jitter_ms"`
+	// BandwidthKbps caps the throughput in kilobits per second; 0 means
+	// unlimited.
+	BandwidthKbps int `json:"bandwidth_kbps"`
+	// StallMS out of every StallEveryMS the link delivers nothing, and what
+	// was due in the stall arrives in a burst at its end.
+	StallEveryMS int `json:"stall_every_ms"`
+	StallMS      int `json:"stall_ms"`
+}
+
+// IsZero reports whether s impairs nothing.
+func (s Settings) IsZero() bool {
+	return s == Settings{}
+}
+
+// Validate reports settings that are out of range.
+func (s Settings) Validate() error {
+	if s.DelayMS < 0 || s.JitterMS < 0 || s.BandwidthKbps < 0 || s.StallEveryMS < 0 || s.StallMS < 0 {
+		return errors.New("impairment settings must not be negative")
+	}
+	if s.StallMS > 0 && s.StallMS >= s.StallEveryMS {
+		return errors.New("stall_ms must be below stall_every_ms")
+	}
+	return nil
+}
+
+type item struct {
+	msg []byte
+	due time.Time
+}
+
+// Queue delivers the messages pushed to it after the hold-up its settings
+// call for, one at a time from its own goroutine.
+type Queue struct {
+	deliver func([]byte)
+	start   time.Time
+	wake    chan struct{}
+	done    chan struct{}
+
+	mu       sync.Mutex
+	settings Settings
+	rng      *rand.Rand
+	pending  []item
+	// lastDue is the due time of the last message pushed; later messages
+	// are not delivered before it.
+	lastDue time.Time
+	// freeAt is when the link has sent everything at the bandwidth cap.
+	freeAt time.Time
+	closed bool
+}
+
+// NewQueue starts a queue passing messages to deliver.
+func NewQueue(s Settings, deliver func(msg []byte)) *Queue {
+	q := &Queue{
+		deliver:  deliver,
+		start:    time.Now(),
+		wake:     make(chan struct{}, 1),
+		done:     make(chan struct{}),
+		settings: s,
+		rng:      rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+	go q.run()
+	return q
+}
+
+// Settings returns the current settings.
+func (q *Queue) Settings() Settings {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.settings
+}
+
+// SetSettings changes the settings for the messages pushed from now on.
+func (q *Queue) SetSettings(s Settings) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.settings = s
+}
+
+// Push queues a message. It is dropped if the queue is closed.
+func (q *Queue) Push(msg []byte) {
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		return
+	}
+	now := time.Now()
+	s := q.settings
+	due := now.Add(time.Duration(s.DelayMS) * time.Millisecond)
+	if s.JitterMS > 0 {
+		due = due.Add(time.Duration(q.rng.Intn(s.JitterMS+1)) * time.Millisecond)
+	}
+	if s.BandwidthKbps > 0 {
+		send := q.freeAt
+		if send.Before(now) {
+			send = now
+		}
+		q.freeAt = send.Add(time.Duration(len(msg)) * 8 * time.Millisecond / time.Duration(s.BandwidthKbps))
+		if q.freeAt.After(due) {
+			due = q.freeAt
+		}
+	}
+	if s.StallMS > 0 {
+		every := time.Duration(s.StallEveryMS) * time.Millisecond
+		stall := time.Duration(s.StallMS) * time.Millisecond
+		if phase := due.Sub(q.start) % every; phase < stall {
+			due = due.Add(stall - phase)
+		}
+	}
+	if due.Before(q.lastDue) {
+		due = q.lastDue
+	}
+	q.lastDue = due
+	q.pending = append(q.pending, item{msg: msg, due: due})
+	q.mu.Unlock()
+
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Len returns the number of messages waiting.
+func (q *Queue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.pending)
+}
+
+// Close drops the messages still waiting and waits for a delivery in
+// progress to finish.
+func (q *Queue) Close() {
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		<-q.done
+		return
+	}
+	q.closed = true
+	q.pending = nil
+	q.mu.Unlock()
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+	<-q.done
+}
+
+func (q *Queue) run() {
+	defer close(q.done)
+	timer := time.NewTimer(time.Hour)
+	timer.Stop()
+	for {
+		q.mu.Lock()
+		if q.closed {
+			q.mu.Unlock()
+			return
+		}
+		var wait time.Duration = -1
+		if len(q.pending) > 0 {
+			next := q.pending[0]
+			if wait = time.Until(next.due); wait <= 0 {
+				q.pending[0] = item{}
+				q.pending = q.pending[1:]
+				q.mu.Unlock()
+				q.deliver(next.msg)
+				continue
+			}
+		}
+		q.mu.Unlock()
+
+		if wait < 0 {
+			<-q.wake
+			continue
+		}
+		timer.Reset(wait)
+		select {
+		case <-timer.C:
+		case <-q.wake:
+			// A stale tick after this only causes another look at
+			// the queue.
+			timer.Stop()
+		}
+	}
+}
+
+// Link impairs both directions of a connection with a queue each.
+type Link struct {
+	// In holds back the messages from the client, Out those to it.
+	In  *Queue
+	Out *Queue
+}
+
+// SetSettings changes the settings of both directions.
+func (l *Link) SetSettings(s Settings) {
+	l.In.SetSettings(s)
+	l.Out.SetSettings(s)
+}
+
+// Close closes both queues.
+func (l *Link) Close() {
+	l.In.Close()
+	l.Out.Close()
+}
@@ -0,0 +1,152 @@
+package impair
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		s    Settings
+		want bool
+	}{
+		{Settings{}, true},
+		{Settings{DelayMS: 100, JitterMS: 20, BandwidthKbps: 64}, true},
+		{Settings{StallEveryMS: 1000, StallMS: 200}, true},
+		{Settings{DelayMS: -1}, false},
+		{Settings{BandwidthKbps: -1}, false},
+		{Settings{StallEveryMS: 1000, StallMS: 1000}, false},
+		{Settings{StallMS: 200}, false},
+	}
+	for _, tt := range tests {
+		if err := tt.s.Validate(); (err == nil) != tt.want {
+			t.Errorf("%+v.Validate() = %v, want valid %v", tt.s, err, tt.want)
+		}
+	}
+}
+
+// recorder collects delivered messages with the time they arrived.
+type recorder struct {
+	mu   sync.Mutex
+	msgs []string
+	at   []time.Time
+	all  chan struct{}
+	want int
+}
+
+func newRecorder(want int) *recorder {
+	return &recorder{all: make(chan struct{}), want: want}
+}
+
+func (r *recorder) deliver(msg []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.msgs = append(r.msgs, string(msg))
+	r.at = append(r.at, time.Now())
+	if len(r.msgs) == r.want {
+		close(r.all)
+	}
+}
+
+func (r *recorder) wait(t *testing.T) {
+	t.Helper()
+	select {
+	case <-r.all:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("got %d of %d messages", len(r.msgs), r.want)
+	}
+}
+
+func TestQueueDelay(t *testing.T) {
+	const delay = 50 * time.Millisecond
+	r := newRecorder(1)
+	q := NewQueue(Settings{DelayMS: int(delay / time.Millisecond)}, r.deliver)
+	defer q.Close()
+	pushed := time.Now()
+	q.Push([]byte("a"))
+	r.wait(t)
+	if got := r.at[0].Sub(pushed); got < delay {
+		t.Errorf("delivered after %v, want at least %v", got, delay)
+	}
+}
+
+// TestQueueOrder checks that jitter never reorders messages.
+func TestQueueOrder(t *testing.T) {
+	const n = 50
+	r := newRecorder(n)
+	q := NewQueue(Settings{DelayMS: 1, JitterMS: 20}, r.deliver)
+	defer q.Close()
+	for i := 0; i < n; i++ {
+		q.Push([]byte(fmt.Sprint(i)))
+	}
+	r.wait(t)
+	for i, msg := range r.msgs {
+		if msg != fmt.Sprint(i) {
+			t.Fatalf("message %d = %s, want %d", i, msg, i)
+		}
+	}
+}
+
+func TestQueueBandwidth(t *testing.T) {
+	// 8 kbps sends one byte per millisecond.
+	r := newRecorder(3)
+	q := NewQueue(Settings{BandwidthKbps: 8}, r.deliver)
+	defer q.Close()
+	pushed := time.Now()
+	msg := make([]byte, 20)
+	for i := 0; i < 3; i++ {
+		q.Push(msg)
+	}
+	r.wait(t)
+	if got := r.at[2].Sub(pushed); got < 60*time.Millisecond {
+		t.Errorf("3 messages of 20 bytes took %v at 8 kbps, want at least 60ms", got)
+	}
+}
+
+// TestQueueStall pushes a message at the start of a stall and checks that it
+// is held until the stall ends.
+func TestQueueStall(t *testing.T) {
+	q := NewQueue(Settings{StallEveryMS: 10000, StallMS: 5000}, func([]byte) {})
+	defer q.Close()
+	q.Push([]byte("a"))
+	q.mu.Lock()
+	due := q.pending[0].due.Sub(q.start)
+	q.mu.Unlock()
+	if due < 5*time.Second {
+		t.Errorf("due %v after the start, want the end of the stall at 5s", due)
+	}
+}
+
+func TestQueueSetSettings(t *testing.T) {
+	r := newRecorder(1)
+	q := NewQueue(Settings{DelayMS: 10000}, r.deliver)
+	defer q.Close()
+	q.SetSettings(Settings{})
+	if got := q.Settings(); !got.IsZero() {
+		t.Fatalf("Settings = %+v, want zero", got)
+	}
+	q.Push([]byte("a"))
+	r.wait(t)
+}
+
+func TestQueueClose(t *testing.T) {
+	delivered := make(chan []byte, 2)
+	q := NewQueue(Settings{DelayMS: 10000}, func(msg []byte) { delivered <- msg })
+	q.Push([]byte("held"))
+	if q.Len() != 1 {
+		t.Fatalf("Len = %d, want 1", q.Len())
+	}
+	q.Close()
+	q.Close()
+	q.Push([]byte("after close"))
+	if q.Len() != 0 {
+		t.Errorf("Len after Close = %d, want 0", q.Len())
+	}
+	select {
+	case msg := <-delivered:
+		t.Errorf("delivered %q after Close", msg)
+	case <-time.After(20 * time.Millisecond):
+	}
+}
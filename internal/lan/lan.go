@@ -0,0 +1,169 @@
+// Package lan announces room servers on the local network with UDP beacons
+// and finds them again, so LAN players do not have to type IP addresses.
+//
+// A beacon is a JSON datagram sent every few seconds to a broadcast or
+// multicast address. It carries the port and path but not the host, which
+// the scanner takes from the sender address.
+package lan
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+	"time"
+)
+
+const (
+	// DefaultPort is the UDP port beacons are sent to.
+	DefaultPort = 47777
+	// DefaultInterval is how often beacons are sent.
+	DefaultInterval = 5 * time.Second
+	// magic identifies our beacons among other traffic on the port.
+	magic = "lf2hub"
+	// maxBeaconSize bounds the datagrams we read.
+	maxBeaconSize = 2048
+)
+
+// DefaultAddress is the broadcast address beacons are sent to by default.
+var DefaultAddress = net.JoinHostPort("255.255.255.255", strconv.Itoa(DefaultPort))
+
+// Beacon describes a room server.
+type Beacon struct {
+	Magic string `json:"magic"`
+	Name  string `json:"name"`
+	// Scheme is "ws" or "wss".
+	Scheme string `json:"scheme"`
+	Port   int    `json:"port"`
+	// Path is where the server is mounted, e.g. "/" or "/hub/club".
+	Path        string `json:"path"`
+	Rooms       int    `json:"rooms"`
+	ActiveRooms int    `json:"active_rooms"`
+	Players     int    `json:"players"`
+	Capacity    int    `json:"capacity"`
+}
+
+// Found is a server discovered by Scan.
+type Found struct {
+	Beacon
+	// Address is the URL to connect to, built from the sender IP.
+	Address  string    `json:"address"`
+	LastSeen time.Time `json:"last_seen"`
+}
+
+// Announce sends the beacons returned by status to addr every interval until
+// ctx is done. addr may be a broadcast address such as 255.255.255.255:47777
+// or a multicast group such as 239.255.76.70:47777.
+func Announce(ctx context.Context, addr string, interval time.Duration, log *slog.Logger, status func() []Beacon) error {
+	raddr, err := net.ResolveUDPAddr("udp4", addr)
+	if err != nil {
+		return err
+	}
+	conn, err := net.DialUDP("udp4", nil, raddr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	failing := false
+	for {
+		for _, b := range status() {
+			b.Magic = magic
+			data, err := json.Marshal(b)
+			if err != nil {
+				return err
+			}
+			if _, err := conn.Write(data); err != nil {
+				if !failing {
+					log.Warn("Failed to send LAN beacon", "addr", addr, "err", err)
+				}
+				failing = true
+			} else {
+				failing = false
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// Scan listens on addr for beacons until ctx is done and returns the servers
+// found, sorted by address. For a multicast group, addr is the group address;
+// otherwise it is a local address such as ":47777".
+func Scan(ctx context.Context, addr string) ([]Found, error) {
+	laddr, err := net.ResolveUDPAddr("udp4", addr)
+	if err != nil {
+		return nil, err
+	}
+	var conn *net.UDPConn
+	if laddr.IP != nil && laddr.IP.IsMulticast() {
+		conn, err = net.ListenMulticastUDP("udp4", nil, laddr)
+	} else {
+		conn, err = net.ListenUDP("udp4", laddr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	found := make(map[string]Found)
+	buf := make([]byte, maxBeaconSize)
+	for {
+		n, from, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+				break
+			}
+			return nil, err
+		}
+		var b Beacon
+		if json.Unmarshal(buf[:n], &b) != nil || b.Magic != magic || b.Port <= 0 {
+			continue
+		}
+		f := Found{Beacon: b, Address: address(b, from.IP), LastSeen: time.Now()}
+		found[f.Address] = f
+	}
+
+	list := make([]Found, 0, len(found))
+	for _, f := range found {
+		list = append(list, f)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Address < list[j].Address })
+	return list, nil
+}
+
+func address(b Beacon, ip net.IP) string {
+	scheme := b.Scheme
+	if scheme != "wss" {
+		scheme = "ws"
+	}
+	path := b.Path
+	if path == "/" {
+		path = ""
+	}
+	return fmt.Sprintf("%s://%s%s", scheme, net.JoinHostPort(ip.String(), strconv.Itoa(b.Port)), path)
+}
+
+// WriteTable prints the servers as a table.
+func WriteTable(w io.Writer, found []Found) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tADDRESS\tROOMS IN USE\tPLAYERS")
+	for _, f := range found {
+		fmt.Fprintf(tw, "%s\t%s\t%d/%d\t%d/%d\n", f.Name, f.Address, f.ActiveRooms, f.Rooms, f.Players, f.Capacity)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,79 @@
+// Package logging configures the structured logger shared by the binaries.
+package logging
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// LevelTrace is below debug and used for messages that are far too frequent
+// for normal debugging, such as every FRAME of a match.
+const LevelTrace = slog.LevelDebug - 4
+
+// ParseLevel converts a level name as used in flags and config files.
+func ParseLevel(name string) (slog.Level, error) {
+	switch strings.ToLower(name) {
+	case "trace":
+		return LevelTrace, nil
+	case "debug":
+		return slog.LevelDebug, nil
+	case "info", "":
+		return slog.LevelInfo, nil
+	case "warn":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	default:
+		return 0, fmt.Errorf("unknown log level %q", name)
+	}
+}
+
+// NewHandler creates a text or JSON handler writing to w at the level held
+// by level, which may be changed later to adjust the verbosity at runtime.
+func NewHandler(w io.Writer, format string, level *slog.LevelVar) (slog.Handler, error) {
+	opts := &slog.HandlerOptions{
+		Level: level,
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if a.Key == slog.LevelKey && len(groups) == 0 {
+				if l, ok := a.Value.Any().(slog.Level); ok && l <= LevelTrace {
+					a.Value = slog.StringValue("TRACE")
+				}
+			}
+			return a
+		},
+	}
+	switch strings.ToLower(format) {
+	case "text", "":
+		return slog.NewTextHandler(w, opts), nil
+	case "json":
+		return slog.NewJSONHandler(w, opts), nil
+	default:
+		return nil, fmt.Errorf("unknown log format %q", format)
+	}
+}
+
+// Setup installs a text or JSON handler writing to w as the default logger
+// and returns the level variable controlling it.
+func Setup(w io.Writer, format, level string) (*slog.LevelVar, error) {
+	l, err := ParseLevel(level)
+	if err != nil {
+		return nil, err
+	}
+	var lv slog.LevelVar
+	lv.Set(l)
+	h, err := NewHandler(w, format, &lv)
+	if err != nil {
+		return nil, err
+	}
+	slog.SetDefault(slog.New(h))
+	return &lv, nil
+}
+
+// Fatal logs msg at error level with the given attributes and exits.
+func Fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
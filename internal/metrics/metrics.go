@@ -0,0 +1,223 @@
+// Package metrics is a small, dependency free implementation of counters,
+// gauges and histograms exposed in the Prometheus text format.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Registry holds metric families and renders them for scraping. Several
+// families may share a name as long as they have the same type, which lets
+// independent components publish the same metric under different constant
+// labels.
+type Registry struct {
+	mu       sync.Mutex
+	families []family
+	labels   []labelPair
+	parent   *Registry
+}
+
+// family is implemented by every metric type.
+type family interface {
+	name() string
+	help() string
+	kind() string
+	constantLabels() []labelPair
+	// samples appends the lines of the family, without HELP and TYPE.
+	samples(b *strings.Builder)
+}
+
+type labelPair struct {
+	name, value string
+}
+
+// NewRegistry returns an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// WithLabels returns a view of r that adds the given constant label pairs,
+// given as name, value, name, value..., to every metric registered through it.
+func (r *Registry) WithLabels(pairs ...string) *Registry {
+	if len(pairs)%2 != 0 {
+		panic("metrics: WithLabels needs name/value pairs")
+	}
+	labels := append([]labelPair(nil), r.labels...)
+	for i := 0; i < len(pairs); i += 2 {
+		labels = append(labels, labelPair{pairs[i], pairs[i+1]})
+	}
+	return &Registry{labels: labels, parent: r.root()}
+}
+
+func (r *Registry) root() *Registry {
+	if r.parent != nil {
+		return r.parent
+	}
+	return r
+}
+
+func (r *Registry) register(f family) {
+	root := r.root()
+	root.mu.Lock()
+	defer root.mu.Unlock()
+	root.families = append(root.families, f)
+}
+
+// Unregister removes every family that was registered through r or a view
+// derived from it with the same constant labels.
+func (r *Registry) Unregister(names ...string) {
+	root := r.root()
+	root.mu.Lock()
+	defer root.mu.Unlock()
+	drop := make(map[string]bool)
+	for _, n := range names {
+		drop[n] = true
+	}
+	kept := root.families[:0]
+	for _, f := range root.families {
+		if drop[f.name()] && sameLabels(f.constantLabels(), r.labels) {
+			continue
+		}
+		kept = append(kept, f)
+	}
+	root.families = kept
+}
+
+// WriteText writes all metrics in the Prometheus text exposition format.
+func (r *Registry) WriteText(w io.Writer) error {
+	root := r.root()
+	root.mu.Lock()
+	families := append([]family(nil), root.families...)
+	root.mu.Unlock()
+
+	sort.SliceStable(families, func(i, j int) bool {
+		return families[i].name() < families[j].name()
+	})
+
+	bw := bufio.NewWriter(w)
+	for i, f := range families {
+		if i == 0 || families[i-1].name() != f.name() {
+			fmt.Fprintf(bw, "# HELP %s %s\n", f.name(), escapeHelp(f.help()))
+			fmt.Fprintf(bw, "# TYPE %s %s\n", f.name(), f.kind())
+		}
+		var b strings.Builder
+		f.samples(&b)
+		bw.WriteString(b.String())
+	}
+	return bw.Flush()
+}
+
+// Handler serves the registry for Prometheus scrapes.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		r.WriteText(w)
+	})
+}
+
+// desc is the identity shared by all metric types.
+type desc struct {
+	fqName     string
+	helpText   string
+	labelNames []string
+	constant   []labelPair
+}
+
+func (d *desc) name() string { return d.fqName }
+func (d *desc) help() string { return d.helpText }
+
+func (d *desc) constantLabels() []labelPair { return d.constant }
+
+func (r *Registry) newDesc(name, help string, labelNames []string) desc {
+	return desc{
+		fqName:     name,
+		helpText:   help,
+		labelNames: labelNames,
+		constant:   r.labels,
+	}
+}
+
+func sameLabels(a, b []labelPair) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// labelString renders {a="1",b="2"} for the constant labels followed by the
+// given names and values, plus an optional extra pair.
+func (d *desc) labelString(values []string, extra ...string) string {
+	var pairs []string
+	for _, l := range d.constant {
+		pairs = append(pairs, l.name+"="+quoteLabel(l.value))
+	}
+	for i, n := range d.labelNames {
+		pairs = append(pairs, n+"="+quoteLabel(values[i]))
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, extra[i]+"="+quoteLabel(extra[i+1]))
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func (d *desc) checkValues(values []string) {
+	if len(values) != len(d.labelNames) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", d.fqName, len(d.labelNames), len(values)))
+	}
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func escapeHelp(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	return strings.ReplaceAll(s, "\n", `\n`)
+}
+
+// quoteLabel quotes a label value. The text format only escapes backslash,
+// double quote and line feed; everything else, UTF-8 included, is written as
+// is.
+func quoteLabel(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	return `"` + strings.ReplaceAll(s, "\n", `\n`) + `"`
+}
+
+func key(values []string) string {
+	return strings.Join(values, "\xff")
+}
+
+// sortedKeys returns the keys of m in a stable order.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
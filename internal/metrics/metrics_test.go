@@ -0,0 +1,30 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestWriteText(t *testing.T) {
+	r := NewRegistry()
+	c := r.WithLabels("hub", "main").NewCounterVec("lf2_messages_total", "Messages\nreceived.", "command")
+	c.WithLabelValues("FRAME").Add(3)
+	c.WithLabelValues("a\\b \"c\"\nd é").Inc()
+	r.NewGauge("lf2_rooms", "Rooms in use.").Set(2)
+
+	var b strings.Builder
+	if err := r.WriteText(&b); err != nil {
+		t.Fatalf("WriteText: %v", err)
+	}
+	want := `# HELP lf2_messages_total Messages\nreceived.
+# TYPE lf2_messages_total counter
+lf2_messages_total{hub="main",command="FRAME"} 3
+lf2_messages_total{hub="main",command="a\\b \"c\"\nd é"} 1
+# HELP lf2_rooms Rooms in use.
+# TYPE lf2_rooms gauge
+lf2_rooms 2
+`
+	if got := b.String(); got != want {
+		t.Errorf("WriteText =\n%s\nwant\n%s", got, want)
+	}
+}
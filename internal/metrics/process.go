@@ -0,0 +1,62 @@
+package metrics
+
+import "runtime/metrics"
+
+// CounterFunc is a counter whose value is computed at scrape time.
+type CounterFunc struct {
+	GaugeFunc
+}
+
+// NewCounterFunc registers a counter computed by fn on every scrape. fn must
+// never return less than it did before.
+func (r *Registry) NewCounterFunc(name, help string, fn func() float64) *CounterFunc {
+	c := &CounterFunc{GaugeFunc{
+		desc: r.newDesc(name, help, nil),
+		fn: func() map[string]float64 {
+			return map[string]float64{"": fn()}
+		},
+	}}
+	r.register(c)
+	return c
+}
+
+func (c *CounterFunc) kind() string { return "counter" }
+
+// RegisterProcess registers the usual process_* and go_* metrics describing
+// the resource usage of the running process. The process metrics that the
+// platform cannot provide are left out.
+func (r *Registry) RegisterProcess() {
+	if _, ok := processCPUSeconds(); ok {
+		r.NewCounterFunc("process_cpu_seconds_total", "Total user and system CPU time spent in seconds.", func() float64 {
+			v, _ := processCPUSeconds()
+			return v
+		})
+	}
+	if _, ok := processResidentBytes(); ok {
+		r.NewGaugeFunc("process_resident_memory_bytes", "Resident memory size in bytes.", func() float64 {
+			v, _ := processResidentBytes()
+			return v
+		})
+	}
+	r.NewGaugeFunc("go_goroutines", "Number of goroutines that currently exist.",
+		runtimeValue("/sched/goroutines:goroutines"))
+	r.NewGaugeFunc("go_memstats_heap_alloc_bytes", "Number of heap bytes allocated and still in use.",
+		runtimeValue("/memory/classes/heap/objects:bytes"))
+	r.NewGaugeFunc("go_memstats_sys_bytes", "Number of bytes obtained from the system.",
+		runtimeValue("/memory/classes/total:bytes"))
+}
+
+// runtimeValue returns a function reading a single runtime/metrics value.
+func runtimeValue(name string) func() float64 {
+	return func() float64 {
+		s := []metrics.Sample{{Name: name}}
+		metrics.Read(s)
+		switch s[0].Value.Kind() {
+		case metrics.KindUint64:
+			return float64(s[0].Value.Uint64())
+		case metrics.KindFloat64:
+			return s[0].Value.Float64()
+		}
+		return 0
+	}
+}
@@ -0,0 +1,7 @@
+//go:build !unix
+
+package metrics
+
+func processCPUSeconds() (float64, bool) { return 0, false }
+
+func processResidentBytes() (float64, bool) { return 0, false }
@@ -0,0 +1,322 @@
+package metrics
+
+import (
+	"math"
+	"strings"
+	"sync"
+)
+
+// Counter is a monotonically increasing value.
+type Counter struct {
+	mu sync.Mutex
+	v  float64
+}
+
+// Inc adds one to the counter.
+func (c *Counter) Inc() { c.Add(1) }
+
+// Add adds v, which must not be negative, to the counter.
+func (c *Counter) Add(v float64) {
+	if v < 0 {
+		return
+	}
+	c.mu.Lock()
+	c.v += v
+	c.mu.Unlock()
+}
+
+// Value returns the current count.
+func (c *Counter) Value() float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.v
+}
+
+// CounterVec is a family of counters partitioned by label values.
+type CounterVec struct {
+	desc
+	mu       sync.Mutex
+	children map[string]*Counter
+	values   map[string][]string
+}
+
+// NewCounter registers an unlabeled counter.
+func (r *Registry) NewCounter(name, help string) *Counter {
+	return r.NewCounterVec(name, help).WithLabelValues()
+}
+
+// NewCounterVec registers a counter family with the given label names.
+func (r *Registry) NewCounterVec(name, help string, labelNames ...string) *CounterVec {
+	v := &CounterVec{
+		desc:     r.newDesc(name, help, labelNames),
+		children: make(map[string]*Counter),
+		values:   make(map[string][]string),
+	}
+	r.register(v)
+	return v
+}
+
+// WithLabelValues returns the counter for the given label values, creating
+// it on first use.
+func (v *CounterVec) WithLabelValues(values ...string) *Counter {
+	v.checkValues(values)
+	k := key(values)
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	c, ok := v.children[k]
+	if !ok {
+		c = &Counter{}
+		v.children[k] = c
+		v.values[k] = append([]string(nil), values...)
+	}
+	return c
+}
+
+// Delete drops the counter for the given label values.
+func (v *CounterVec) Delete(values ...string) {
+	k := key(values)
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	delete(v.children, k)
+	delete(v.values, k)
+}
+
+func (v *CounterVec) kind() string { return "counter" }
+
+func (v *CounterVec) samples(b *strings.Builder) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	for _, k := range sortedKeys(v.children) {
+		b.WriteString(v.fqName + v.labelString(v.values[k]) + " " + formatValue(v.children[k].Value()) + "\n")
+	}
+}
+
+// Gauge is a value that can go up and down.
+type Gauge struct {
+	mu sync.Mutex
+	v  float64
+}
+
+// Set replaces the value of the gauge.
+func (g *Gauge) Set(v float64) {
+	g.mu.Lock()
+	g.v = v
+	g.mu.Unlock()
+}
+
+// Add adds v, which may be negative, to the gauge.
+func (g *Gauge) Add(v float64) {
+	g.mu.Lock()
+	g.v += v
+	g.mu.Unlock()
+}
+
+// Inc adds one to the gauge.
+func (g *Gauge) Inc() { g.Add(1) }
+
+// Dec subtracts one from the gauge.
+func (g *Gauge) Dec() { g.Add(-1) }
+
+// Value returns the current value.
+func (g *Gauge) Value() float64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.v
+}
+
+// GaugeVec is a family of gauges partitioned by label values.
+type GaugeVec struct {
+	desc
+	mu       sync.Mutex
+	children map[string]*Gauge
+	values   map[string][]string
+}
+
+// NewGauge registers an unlabeled gauge.
+func (r *Registry) NewGauge(name, help string) *Gauge {
+	return r.NewGaugeVec(name, help).WithLabelValues()
+}
+
+// NewGaugeVec registers a gauge family with the given label names.
+func (r *Registry) NewGaugeVec(name, help string, labelNames ...string) *GaugeVec {
+	v := &GaugeVec{
+		desc:     r.newDesc(name, help, labelNames),
+		children: make(map[string]*Gauge),
+		values:   make(map[string][]string),
+	}
+	r.register(v)
+	return v
+}
+
+// WithLabelValues returns the gauge for the given label values, creating it
+// on first use.
+func (v *GaugeVec) WithLabelValues(values ...string) *Gauge {
+	v.checkValues(values)
+	k := key(values)
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	g, ok := v.children[k]
+	if !ok {
+		g = &Gauge{}
+		v.children[k] = g
+		v.values[k] = append([]string(nil), values...)
+	}
+	return g
+}
+
+// Delete drops the gauge for the given label values.
+func (v *GaugeVec) Delete(values ...string) {
+	k := key(values)
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	delete(v.children, k)
+	delete(v.values, k)
+}
+
+func (v *GaugeVec) kind() string { return "gauge" }
+
+func (v *GaugeVec) samples(b *strings.Builder) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	for _, k := range sortedKeys(v.children) {
+		b.WriteString(v.fqName + v.labelString(v.values[k]) + " " + formatValue(v.children[k].Value()) + "\n")
+	}
+}
+
+// GaugeFunc is a gauge whose values are computed at scrape time.
+type GaugeFunc struct {
+	desc
+	fn func() map[string]float64
+}
+
+// NewGaugeFunc registers a gauge computed by fn on every scrape.
+func (r *Registry) NewGaugeFunc(name, help string, fn func() float64) *GaugeFunc {
+	g := &GaugeFunc{
+		desc: r.newDesc(name, help, nil),
+		fn: func() map[string]float64 {
+			return map[string]float64{"": fn()}
+		},
+	}
+	r.register(g)
+	return g
+}
+
+// NewGaugeVecFunc registers a gauge with a single label whose values are
+// computed by fn on every scrape. fn returns the value for each label value.
+func (r *Registry) NewGaugeVecFunc(name, help, label string, fn func() map[string]float64) *GaugeFunc {
+	g := &GaugeFunc{
+		desc: r.newDesc(name, help, []string{label}),
+		fn:   fn,
+	}
+	r.register(g)
+	return g
+}
+
+func (g *GaugeFunc) kind() string { return "gauge" }
+
+func (g *GaugeFunc) samples(b *strings.Builder) {
+	values := g.fn()
+	for _, k := range sortedKeys(values) {
+		var labels []string
+		if len(g.labelNames) > 0 {
+			labels = []string{k}
+		}
+		b.WriteString(g.fqName + g.labelString(labels) + " " + formatValue(values[k]) + "\n")
+	}
+}
+
+// Histogram counts observations in cumulative buckets.
+type Histogram struct {
+	mu      sync.Mutex
+	bounds  []float64
+	counts  []uint64
+	sum     float64
+	samples uint64
+}
+
+// Observe records a single value.
+func (h *Histogram) Observe(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i, bound := range h.bounds {
+		if v <= bound {
+			h.counts[i]++
+		}
+	}
+	h.sum += v
+	h.samples++
+}
+
+// HistogramVec is a family of histograms partitioned by label values.
+type HistogramVec struct {
+	desc
+	buckets  []float64
+	mu       sync.Mutex
+	children map[string]*Histogram
+	values   map[string][]string
+}
+
+// DefaultBuckets suit latencies measured in seconds.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// NewHistogram registers an unlabeled histogram with the given upper bounds,
+// which must be sorted.
+func (r *Registry) NewHistogram(name, help string, buckets []float64) *Histogram {
+	return r.NewHistogramVec(name, help, buckets).WithLabelValues()
+}
+
+// NewHistogramVec registers a histogram family with the given label names.
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labelNames ...string) *HistogramVec {
+	v := &HistogramVec{
+		desc:     r.newDesc(name, help, labelNames),
+		buckets:  buckets,
+		children: make(map[string]*Histogram),
+		values:   make(map[string][]string),
+	}
+	r.register(v)
+	return v
+}
+
+// WithLabelValues returns the histogram for the given label values, creating
+// it on first use.
+func (v *HistogramVec) WithLabelValues(values ...string) *Histogram {
+	v.checkValues(values)
+	k := key(values)
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	h, ok := v.children[k]
+	if !ok {
+		h = &Histogram{bounds: v.buckets, counts: make([]uint64, len(v.buckets))}
+		v.children[k] = h
+		v.values[k] = append([]string(nil), values...)
+	}
+	return h
+}
+
+// Delete drops the histogram for the given label values.
+func (v *HistogramVec) Delete(values ...string) {
+	k := key(values)
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	delete(v.children, k)
+	delete(v.values, k)
+}
+
+func (v *HistogramVec) kind() string { return "histogram" }
+
+func (v *HistogramVec) samples(b *strings.Builder) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	for _, k := range sortedKeys(v.children) {
+		h := v.children[k]
+		values := v.values[k]
+		h.mu.Lock()
+		for i, bound := range h.bounds {
+			b.WriteString(v.fqName + "_bucket" + v.labelString(values, "le", formatValue(bound)) + " " + formatValue(float64(h.counts[i])) + "\n")
+		}
+		b.WriteString(v.fqName + "_bucket" + v.labelString(values, "le", formatValue(math.Inf(1))) + " " + formatValue(float64(h.samples)) + "\n")
+		b.WriteString(v.fqName + "_sum" + v.labelString(values) + " " + formatValue(h.sum) + "\n")
+		b.WriteString(v.fqName + "_count" + v.labelString(values) + " " + formatValue(float64(h.samples)) + "\n")
+		h.mu.Unlock()
+	}
+}
@@ -0,0 +1,81 @@
+// Package replay checks the room server against golden transcripts: captures
+// of client sessions, as recorded by lf2-sniff, whose server messages are the
+// expected behaviour. Every transcript is replayed against a fresh
+// in-process server and the replies are compared after normalizing player
+// IDs and the server's clock.
+package replay
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/zjx20/littlefighterhub/internal/capture"
+	"github.com/zjx20/littlefighterhub/internal/server"
+)
+
+// Ext is the file extension of golden transcripts.
+const Ext = ".jsonl"
+
+// Options tune Check.
+type Options struct {
+	// Config is the server configuration; server.DefaultConfig() when nil.
+	Config *server.Config
+	Replay capture.ReplayOptions
+	Diff   capture.DiffOptions
+	// Update rewrites the transcripts with what the server replied instead
+	// of comparing.
+	Update bool
+}
+
+// Run replays a capture against a new server with cfg, served over
+// httptest, and returns the capture of the replay.
+func Run(ctx context.Context, cfg server.Config, records []capture.Record, opts capture.ReplayOptions) ([]capture.Record, error) {
+	if cfg.Logger == nil {
+		cfg.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	}
+	s, err := server.NewServerWithConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	ts := httptest.NewServer(http.HandlerFunc(s.HandleConnections))
+	defer ts.Close()
+	return capture.Replay(ctx, "ws"+strings.TrimPrefix(ts.URL, "http")+"/", records, opts)
+}
+
+// Files returns the golden transcripts in dir, sorted by name.
+func Files(dir string) ([]string, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*"+Ext))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// Check replays the golden transcript in file and writes the differences to
+// w. It reports whether the server behaved as recorded.
+func Check(ctx context.Context, w io.Writer, file string, opts Options) (bool, error) {
+	want, err := capture.ReadFile(file)
+	if err != nil {
+		return false, err
+	}
+	cfg := server.DefaultConfig()
+	if opts.Config != nil {
+		cfg = *opts.Config
+	}
+	got, err := Run(ctx, cfg, want, opts.Replay)
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", file, err)
+	}
+	if opts.Update {
+		return true, capture.WriteFile(file, got)
+	}
+	return !capture.Diff(w, want, got, opts.Diff), nil
+}
@@ -0,0 +1,46 @@
+package replay
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/zjx20/littlefighterhub/internal/capture"
+)
+
+var update = flag.Bool("update", false, "Rewrite the golden transcripts with the server's current replies")
+
+// TestGolden replays every transcript in tests/golden. After an intended
+// change of behaviour, run it with -update and review the result with git
+// diff.
+func TestGolden(t *testing.T) {
+	files, err := Files(filepath.Join("..", "..", "tests", "golden"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Fatal("no golden transcripts found")
+	}
+	opts := Options{
+		Replay: capture.ReplayOptions{Speed: 1, Wait: 500 * time.Millisecond},
+		Diff:   capture.DiffOptions{Frames: true},
+		Update: *update,
+	}
+	for _, file := range files {
+		file := file
+		t.Run(filepath.Base(file), func(t *testing.T) {
+			t.Parallel()
+			var diff bytes.Buffer
+			ok, err := Check(context.Background(), &diff, file, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !ok {
+				t.Errorf("server replies differ from the transcript:\n%s", diff.Bytes())
+			}
+		})
+	}
+}
@@ -0,0 +1,62 @@
+package room
+
+// checksumWindow is how many seqs behind the latest one a seq is kept
+// waiting for the rest of its checksums. Older seqs were left incomplete by a
+// player who stopped sending and are forgotten.
+const checksumWindow = 256
+
+// Checksums compares the state checksums the players of a match report in
+// their FRAMEs. Clients that agree on the game state report the same
+// checksum for the same seq; a difference means the match has desynced.
+type Checksums struct {
+	bySeq    map[int]map[int]int
+	latest   int
+	desynced bool
+}
+
+// NewChecksums starts comparing the checksums of a new match.
+func NewChecksums() *Checksums {
+	return &Checksums{bySeq: make(map[int]map[int]int)}
+}
+
+// Add records the checksum a player reported for seq. Once every one of
+// players has reported the seq, it is compared; if the checksums differ,
+// they are returned by player. Only the first desync of a match is returned,
+// since the states stay apart from then on.
+func (c *Checksums) Add(playerID, seq, checksum int, players []int) map[int]int {
+	if c.desynced {
+		return nil
+	}
+	if seq > c.latest {
+		c.latest = seq
+	} else if seq < c.latest-checksumWindow {
+		return nil
+	}
+	if len(c.bySeq) > checksumWindow {
+		for old := range c.bySeq {
+			if old < c.latest-checksumWindow {
+				delete(c.bySeq, old)
+			}
+		}
+	}
+	reported := c.bySeq[seq]
+	if reported == nil {
+		reported = make(map[int]int)
+		c.bySeq[seq] = reported
+	}
+	reported[playerID] = checksum
+
+	for _, id := range players {
+		if _, ok := reported[id]; !ok {
+			return nil
+		}
+	}
+	delete(c.bySeq, seq)
+	for _, sum := range reported {
+		if sum != checksum {
+			c.desynced = true
+			return reported
+		}
+	}
+	return nil
+}
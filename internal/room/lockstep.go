@@ -0,0 +1,165 @@
+package room
+
+import (
+	"sort"
+	"time"
+)
+
+// Lockstep collects the FRAMEs of a match by seq and releases each seq only
+// once every player has sent it, with the frames in a fixed player order.
+// The frames of a seq are released together but stay separate messages, as
+// clients expect one FRAME per message.
+// It relies on each player's seqs increasing: a seq is complete once every
+// player has sent a frame with that seq or a later one.
+type Lockstep struct {
+	// order is the seat order at the start of the match, which frames of a
+	// seq are released in.
+	order []int
+	// latest is the highest seq received from each player still playing.
+	latest map[int]int
+	// pending holds the frames not released yet by seq and player.
+	pending map[int]map[int][]byte
+	// since is when the first frame of each pending seq arrived.
+	since map[int]time.Time
+	// released is the highest seq released so far.
+	released int
+}
+
+// LockstepWindow is how far past the last seq released a frame may be.
+// Frames further ahead are refused, so that the frames pending stay bounded
+// however fast a player sends.
+const LockstepWindow = 512
+
+// LockstepResult tells what Add did with a frame.
+type LockstepResult int
+
+const (
+	// LockstepHeld means the frame waits for the other players' frames of
+	// its seq.
+	LockstepHeld LockstepResult = iota
+	// LockstepLate means the frame cannot wait for the others: its sender
+	// is not in the match, or its seq was released already. It must be
+	// relayed on its own.
+	LockstepLate
+	// LockstepAhead means the frame's seq is more than LockstepWindow past
+	// the last seq released. It was refused.
+	LockstepAhead
+)
+
+// LockstepRound is a seq ready to be released.
+type LockstepRound struct {
+	Seq int
+	// Frames are the messages of the seq in release order.
+	Frames []LockstepFrame
+	// Wait is how long the seq waited, from its first frame until now.
+	Wait time.Duration
+}
+
+// LockstepFrame is a FRAME message and its sender.
+type LockstepFrame struct {
+	PlayerID int
+	Msg      []byte
+}
+
+// NewLockstep starts collecting the frames of the given players, in seat
+// order.
+func NewLockstep(players []int) *Lockstep {
+	l := &Lockstep{
+		order:    append([]int(nil), players...),
+		latest:   make(map[int]int),
+		pending:  make(map[int]map[int][]byte),
+		since:    make(map[int]time.Time),
+		released: -1,
+	}
+	for _, id := range players {
+		l.latest[id] = -1
+	}
+	return l
+}
+
+// Add records a frame received at now, unless the result says otherwise.
+func (l *Lockstep) Add(playerID, seq int, msg []byte, now time.Time) LockstepResult {
+	latest, ok := l.latest[playerID]
+	if !ok || seq <= l.released {
+		return LockstepLate
+	}
+	if seq > l.released+LockstepWindow {
+		return LockstepAhead
+	}
+	if seq > latest {
+		l.latest[playerID] = seq
+	}
+	frames := l.pending[seq]
+	if frames == nil {
+		frames = make(map[int][]byte)
+		l.pending[seq] = frames
+		l.since[seq] = now
+	}
+	frames[playerID] = msg
+	return LockstepHeld
+}
+
+// Stalled returns the players, in seat order, holding up a seq that has
+// been pending for longer than timeout.
+func (l *Lockstep) Stalled(now time.Time, timeout time.Duration) []int {
+	oldest, first := 0, true
+	for seq := range l.pending {
+		if first || seq < oldest {
+			oldest, first = seq, false
+		}
+	}
+	if first || now.Sub(l.since[oldest]) <= timeout {
+		return nil
+	}
+	var stalled []int
+	for _, id := range l.order {
+		if latest, ok := l.latest[id]; ok && latest < oldest {
+			stalled = append(stalled, id)
+		}
+	}
+	return stalled
+}
+
+// Remove stops waiting for a player who left the match. Their frames that
+// are still pending are released with the others.
+func (l *Lockstep) Remove(playerID int) {
+	delete(l.latest, playerID)
+}
+
+// Ready removes and returns the seqs that every player has sent, in seq
+// order. Once no player is left, everything pending is ready.
+func (l *Lockstep) Ready(now time.Time) []LockstepRound {
+	complete := -1
+	first := true
+	for _, seq := range l.latest {
+		if first || seq < complete {
+			complete = seq
+			first = false
+		}
+	}
+	var seqs []int
+	for seq := range l.pending {
+		if first || seq <= complete {
+			seqs = append(seqs, seq)
+		}
+	}
+	sort.Ints(seqs)
+
+	rounds := make([]LockstepRound, 0, len(seqs))
+	for _, seq := range seqs {
+		frames := l.pending[seq]
+		round := LockstepRound{Seq: seq, Wait: now.Sub(l.since[seq])}
+		for _, id := range l.order {
+			if msg, ok := frames[id]; ok {
+				round.Frames = append(round.Frames, LockstepFrame{PlayerID: id, Msg: msg})
+			}
+		}
+		rounds = append(rounds, round)
+		delete(l.pending, seq)
+		delete(l.since, seq)
+		if seq > l.released {
+			l.released = seq
+		}
+	}
+	return rounds
+}
@@ -0,0 +1,55 @@
+package room
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLockstepWindow(t *testing.T) {
+	l := NewLockstep([]int{1, 2})
+	now := time.Now()
+	if got := l.Add(1, LockstepWindow-1, nil, now); got != LockstepHeld {
+		t.Fatalf("Add within the window = %v, want %v", got, LockstepHeld)
+	}
+	if got := l.Add(1, LockstepWindow, nil, now); got != LockstepAhead {
+		t.Fatalf("Add past the window = %v, want %v", got, LockstepAhead)
+	}
+	l.Add(1, 0, nil, now)
+	l.Add(2, 0, nil, now)
+	if rounds := l.Ready(now); len(rounds) != 1 || rounds[0].Seq != 0 {
+		t.Fatalf("Ready = %+v, want seq 0", rounds)
+	}
+	if got := l.Add(1, LockstepWindow, nil, now); got != LockstepHeld {
+		t.Fatalf("Add after the window moved = %v, want %v", got, LockstepHeld)
+	}
+	if got := l.Add(2, 0, nil, now); got != LockstepLate {
+		t.Fatalf("Add of a released seq = %v, want %v", got, LockstepLate)
+	}
+}
+
+func TestLockstepStalled(t *testing.T) {
+	l := NewLockstep([]int{1, 2, 3})
+	start := time.Now()
+	for seq := 0; seq < 3; seq++ {
+		l.Add(1, seq, nil, start)
+		l.Add(3, seq, nil, start)
+	}
+	l.Add(2, 0, nil, start)
+	l.Ready(start)
+
+	if got := l.Stalled(start.Add(time.Second), 2*time.Second); got != nil {
+		t.Fatalf("Stalled before the timeout = %v, want none", got)
+	}
+	later := start.Add(3 * time.Second)
+	got := l.Stalled(later, 2*time.Second)
+	if len(got) != 1 || got[0] != 2 {
+		t.Fatalf("Stalled = %v, want [2]", got)
+	}
+	l.Remove(2)
+	if rounds := l.Ready(later); len(rounds) != 2 {
+		t.Fatalf("Ready after Remove = %+v, want seqs 1 and 2", rounds)
+	}
+	if got := l.Stalled(later, 2*time.Second); got != nil {
+		t.Fatalf("Stalled with nothing pending = %v, want none", got)
+	}
+}
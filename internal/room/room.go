@@ -1,11 +1,16 @@
 package room
 
 import (
+	"fmt"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
+
+	"github.com/zjx20/littlefighterhub/internal/achievement"
+	"github.com/zjx20/littlefighterhub/internal/impair"
 )
 
 type Player struct {
@@ -18,19 +23,76 @@ type Player struct {
 	P2           string
 	P3           string
 	P4           string
+
+	// AchievementSet is Achievements parsed. Use SetAchievements to keep the
+	// two in sync.
+	AchievementSet achievement.Set
+
+	// WriteMu serializes writes to Conn, which supports only one concurrent
+	// writer.
+	WriteMu sync.Mutex
+
+	// roomID is the room the player is in, or 0. It is updated under the
+	// room's lock but may be read without it.
+	roomID atomic.Int32
+
+	// LastFrameSeq is the seq of the last FRAME relayed for the player in
+	// the current match, or -1 before the first one. It is guarded by the
+	// room's lock.
+	LastFrameSeq int
+
+	// violations counts the messages dropped for failing validation.
+	violations atomic.Int64
+
+	// Credential is the admin secret presented during the WebSocket
+	// handshake, if any. It is only consulted when the client sends ADMIN.
+	Credential string
+
+	// Link holds back the messages to and from the player once an
+	// impairment was set for them; nil for most players. It stays in place
+	// until the player disconnects, so messages are never reordered.
+	Link atomic.Pointer[impair.Link]
+}
+
+// SetAchievements stores the achievement list as sent by the client along
+// with its parsed form.
+func (p *Player) SetAchievements(list string) {
+	p.Achievements = list
+	p.AchievementSet = achievement.Parse(list)
+}
+
+// AddViolation records a dropped message and returns the player's total.
+func (p *Player) AddViolation() int64 {
+	return p.violations.Add(1)
+}
+
+// Violations returns the number of messages dropped for failing validation.
+func (p *Player) Violations() int64 {
+	return p.violations.Load()
 }
 
 type Room struct {
 	ID      int
 	State   string // VACANT, LOBBY, STARTED
 	Players map[int]*Player
-	Time    time.Time
-	Latency int
-	Mu      sync.Mutex
+	// Seats lists the IDs of the players in seat order, which is join order
+	// unless changed with MoveSeat. Everything that lists or iterates the
+	// players of a room uses this order. The first seat is the owner's.
+	Seats []int
+	Time  time.Time
+	// StartedAt is when the current match was started.
+	StartedAt time.Time
+	Latency   int
+	Mu        sync.Mutex
 
 	// For synchronizing frames at the beginning of a match
 	IsSynchronizing bool
 	SyncFrameBuffer map[int][][]byte
+	// Lockstep collects the FRAMEs of the current match when it is relayed
+	// in lockstep; it is nil otherwise.
+	Lockstep *Lockstep
+	// Checksums compares the FRAME checksums of the current match.
+	Checksums *Checksums
 }
 
 func NewRoom(id int) *Room {
@@ -45,16 +107,76 @@ func NewRoom(id int) *Room {
 	}
 }
 
+// RoomID returns the ID of the room the player is in, or 0 if none.
+func (p *Player) RoomID() int {
+	return int(p.roomID.Load())
+}
+
 func (r *Room) AddPlayer(player *Player) {
+	if _, ok := r.Players[player.ID]; !ok {
+		r.Seats = append(r.Seats, player.ID)
+	}
 	r.Players[player.ID] = player
+	player.roomID.Store(int32(r.ID))
+	player.LastFrameSeq = -1
 	if len(r.Players) > 0 && r.State == "VACANT" {
 		r.State = "LOBBY"
 	}
 }
 
 func (r *Room) RemovePlayer(playerID int) {
+	if p, ok := r.Players[playerID]; ok {
+		p.roomID.Store(0)
+	}
 	delete(r.Players, playerID)
+	for i, id := range r.Seats {
+		if id == playerID {
+			r.Seats = append(r.Seats[:i], r.Seats[i+1:]...)
+			break
+		}
+	}
 	if len(r.Players) == 0 {
 		r.State = "VACANT"
 	}
 }
+
+// SeatedPlayers returns the players of the room in seat order.
+func (r *Room) SeatedPlayers() []*Player {
+	players := make([]*Player, 0, len(r.Seats))
+	for _, id := range r.Seats {
+		if p, ok := r.Players[id]; ok {
+			players = append(players, p)
+		}
+	}
+	return players
+}
+
+// Owner returns the player in the first seat, or nil if the room is empty.
+// When the owner leaves, the next player in seat order takes over.
+func (r *Room) Owner() *Player {
+	if len(r.Seats) == 0 {
+		return nil
+	}
+	return r.Players[r.Seats[0]]
+}
+
+// MoveSeat moves a player to seat (counted from 0), shifting the players in
+// between. Moving a player to seat 0 makes them the owner.
+func (r *Room) MoveSeat(playerID, seat int) error {
+	from := -1
+	for i, id := range r.Seats {
+		if id == playerID {
+			from = i
+			break
+		}
+	}
+	if from < 0 {
+		return fmt.Errorf("player %d is not in room %d", playerID, r.ID)
+	}
+	if seat < 0 || seat >= len(r.Seats) {
+		return fmt.Errorf("seat %d out of range, room %d has %d players", seat, r.ID, len(r.Seats))
+	}
+	r.Seats = append(r.Seats[:from], r.Seats[from+1:]...)
+	r.Seats = append(r.Seats[:seat], append([]int{playerID}, r.Seats[seat:]...)...)
+	return nil
+}
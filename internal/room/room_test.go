@@ -0,0 +1,106 @@
+package room
+
+import (
+	"reflect"
+	"testing"
+)
+
+// seatedIDs returns the IDs of the room's players in seat order.
+func seatedIDs(r *Room) []int {
+	var ids []int
+	for _, p := range r.SeatedPlayers() {
+		ids = append(ids, p.ID)
+	}
+	return ids
+}
+
+func TestSeatOrder(t *testing.T) {
+	r := NewRoom(1)
+	if r.Owner() != nil {
+		t.Fatalf("Owner of an empty room = %v, want nil", r.Owner())
+	}
+	for _, id := range []int{5, 2, 9, 7} {
+		r.AddPlayer(&Player{ID: id})
+	}
+	if got, want := seatedIDs(r), []int{5, 2, 9, 7}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("seats after joining = %v, want %v", got, want)
+	}
+	if r.State != "LOBBY" {
+		t.Errorf("State = %s, want LOBBY", r.State)
+	}
+
+	// Adding a player again, as a repeated JOIN does, keeps their seat.
+	r.AddPlayer(&Player{ID: 2})
+	if got, want := seatedIDs(r), []int{5, 2, 9, 7}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("seats after joining again = %v, want %v", got, want)
+	}
+
+	r.RemovePlayer(9)
+	if got, want := seatedIDs(r), []int{5, 2, 7}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("seats after a player left = %v, want %v", got, want)
+	}
+
+	// The next player in seat order takes over from a leaving owner.
+	r.RemovePlayer(5)
+	if owner := r.Owner(); owner == nil || owner.ID != 2 {
+		t.Fatalf("Owner after the owner left = %v, want player 2", owner)
+	}
+	r.AddPlayer(&Player{ID: 5})
+	if got, want := seatedIDs(r), []int{2, 7, 5}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("seats after rejoining = %v, want %v", got, want)
+	}
+
+	for _, id := range []int{2, 7, 5} {
+		r.RemovePlayer(id)
+	}
+	if len(r.Seats) != 0 || r.Owner() != nil || r.State != "VACANT" {
+		t.Errorf("empty room has seats %v, owner %v, state %s", r.Seats, r.Owner(), r.State)
+	}
+}
+
+func TestRemovePlayerNotInRoom(t *testing.T) {
+	r := NewRoom(1)
+	r.AddPlayer(&Player{ID: 1})
+	r.AddPlayer(&Player{ID: 2})
+	r.RemovePlayer(3)
+	if got, want := seatedIDs(r), []int{1, 2}; !reflect.DeepEqual(got, want) {
+		t.Errorf("seats = %v, want %v", got, want)
+	}
+}
+
+func TestMoveSeat(t *testing.T) {
+	tests := []struct {
+		name     string
+		playerID int
+		seat     int
+		want     []int
+		wantErr  bool
+	}{
+		{"to owner", 3, 0, []int{3, 1, 2, 4}, false},
+		{"to last", 1, 3, []int{2, 3, 4, 1}, false},
+		{"forward", 2, 3, []int{1, 3, 4, 2}, false},
+		{"backward", 4, 1, []int{1, 4, 2, 3}, false},
+		{"same seat", 2, 1, []int{1, 2, 3, 4}, false},
+		{"unknown player", 9, 0, []int{1, 2, 3, 4}, true},
+		{"negative seat", 2, -1, []int{1, 2, 3, 4}, true},
+		{"seat out of range", 2, 4, []int{1, 2, 3, 4}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewRoom(1)
+			for id := 1; id <= 4; id++ {
+				r.AddPlayer(&Player{ID: id})
+			}
+			err := r.MoveSeat(tt.playerID, tt.seat)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("MoveSeat(%d, %d) = %v, want error %v", tt.playerID, tt.seat, err, tt.wantErr)
+			}
+			if got := seatedIDs(r); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("seats = %v, want %v", got, tt.want)
+			}
+			if owner := r.Owner(); owner.ID != tt.want[0] {
+				t.Errorf("Owner = %d, want %d", owner.ID, tt.want[0])
+			}
+		})
+	}
+}
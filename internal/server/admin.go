@@ -0,0 +1,257 @@
+package server
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/zjx20/littlefighterhub/internal/room"
+	"github.com/zjx20/littlefighterhub/pkg/protocol"
+)
+
+// AdminRole is the privilege level granted to an ADMIN connection.
+type AdminRole int
+
+const (
+	// RoleNone means the connection is not allowed to use the admin channel.
+	RoleNone AdminRole = iota
+	// RoleViewer may watch STATS and ROOM_LIST, with player IPs hidden.
+	RoleViewer
+	// RoleModerator sees everything and may manage rooms and players.
+	RoleModerator
+)
+
+func (r AdminRole) String() string {
+	switch r {
+	case RoleViewer:
+		return "viewer"
+	case RoleModerator:
+		return "moderator"
+	default:
+		return "none"
+	}
+}
+
+// ParseAdminRole converts a role name as used in configuration to an AdminRole.
+func ParseAdminRole(name string) (AdminRole, error) {
+	switch strings.ToLower(name) {
+	case "viewer":
+		return RoleViewer, nil
+	case "moderator":
+		return RoleModerator, nil
+	default:
+		return RoleNone, fmt.Errorf("unknown admin role %q", name)
+	}
+}
+
+const (
+	// How long an ADMIN connection may take to send its AUTH message.
+	adminAuthTimeout = 10 * time.Second
+	// How often ADMIN connections get STATS and ROOM_LIST.
+	adminStatusInterval = 5 * time.Second
+)
+
+// SetAdminToken grants role to clients presenting token. Passing RoleNone
+// revokes the token. With no tokens configured the admin channel is closed.
+func (s *Server) SetAdminToken(token string, role AdminRole) {
+	if token == "" {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if role == RoleNone {
+		delete(s.adminTokens, token)
+		return
+	}
+	s.adminTokens[token] = role
+}
+
+// adminTokens returns the roles granted by the tokens in c. A token given as
+// both the admin and the viewer token grants the viewer role.
+func (c Config) adminTokens() map[string]AdminRole {
+	tokens := make(map[string]AdminRole)
+	if c.AdminToken != "" {
+		tokens[c.AdminToken] = RoleModerator
+	}
+	if c.ViewerToken != "" {
+		tokens[c.ViewerToken] = RoleViewer
+	}
+	return tokens
+}
+
+// adminRole looks up the role granted to token.
+func (s *Server) adminRole(token string) AdminRole {
+	if token == "" {
+		return RoleNone
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	role := RoleNone
+	for t, r := range s.adminTokens {
+		// Compare every token in constant time so the lookup does not leak
+		// how much of a guess was right.
+		if subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+			role = r
+		}
+	}
+	return role
+}
+
+// adminCredential extracts an admin secret from the handshake request. The
+// token may be passed as the "token" query parameter, as a bearer token in the
+// Authorization header, or in the X-Admin-Token header.
+func adminCredential(r *http.Request) string {
+	if token := r.URL.Query().Get("token"); token != "" {
+		return token
+	}
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimSpace(strings.TrimPrefix(auth, "Bearer "))
+	}
+	return r.Header.Get("X-Admin-Token")
+}
+
+// authenticateAdmin determines the role of a connection that sent ADMIN. The
+// secret is taken from the handshake, from the line following ADMIN, or from
+// a follow-up "AUTH\n<token>" message, in that order.
+func (s *Server) authenticateAdmin(player *room.Player, admin *protocol.Admin) AdminRole {
+	token := player.Credential
+	if token == "" {
+		token = admin.Token
+	}
+	if token == "" {
+		player.Conn.SetReadDeadline(time.Now().Add(adminAuthTimeout))
+		_, msg, err := player.Conn.ReadMessage()
+		player.Conn.SetReadDeadline(time.Time{})
+		if err != nil {
+			s.log.Warn("Admin did not authenticate", "player_id", player.ID, "err", err)
+			return RoleNone
+		}
+		m, err := protocol.ParseClient(msg)
+		auth, ok := m.(*protocol.Auth)
+		if !ok {
+			s.log.Warn("Admin sent a command other than AUTH", "player_id", player.ID,
+				"command", protocol.Command(msg), "err", err)
+			return RoleNone
+		}
+		token = auth.Token
+	}
+	return s.adminRole(token)
+}
+
+func (s *Server) handleAdmin(player *room.Player, admin *protocol.Admin) {
+	if !s.config().Features.Admin {
+		s.log.Warn("Rejected ADMIN, admin channel disabled", "player_id", player.ID, "ip", player.IP.String())
+		disconnect(player.Conn, "admin disabled")
+		return
+	}
+
+	role := s.authenticateAdmin(player, admin)
+	if role == RoleNone {
+		s.log.Warn("Rejected unauthorized ADMIN", "player_id", player.ID, "ip", player.IP.String())
+		disconnect(player.Conn, "unauthorized")
+		return
+	}
+
+	s.log.Info("Admin connected", "player_id", player.ID, "ip", player.IP.String(), "role", role.String())
+	done := make(chan struct{})
+	defer close(done)
+	go s.pushAdminStatus(player, role, done)
+
+	// The connection is only read here from now on. On an error, the read
+	// loop of HandleConnections gets the same error and ends the connection.
+	for {
+		_, msg, err := player.Conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		s.metrics.bytesIn.Add(float64(len(msg)))
+		s.handleAdminCommand(player, role, msg)
+	}
+}
+
+// pushAdminStatus sends STATS and ROOM_LIST every adminStatusInterval until
+// done is closed. A failed send closes the connection.
+func (s *Server) pushAdminStatus(player *room.Player, role AdminRole, done <-chan struct{}) {
+	ticker := time.NewTicker(adminStatusInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-done:
+			return
+		}
+
+		// Send STATS
+		if err := s.sendMessage(player, &protocol.Stats{}); err != nil {
+			s.log.Warn("Failed to send STATS", "player_id", player.ID, "err", err)
+			player.Conn.Close()
+			return
+		}
+
+		// Send ROOM_LIST
+		list := &protocol.RoomList{}
+		for i := 1; i <= len(s.Rooms); i++ {
+			r := s.Rooms[i]
+			r.Mu.Lock()
+			status := protocol.RoomStatus{
+				ID:      r.ID,
+				State:   r.State,
+				Latency: r.Latency,
+				Elapsed: time.Since(r.Time).Milliseconds(),
+			}
+			for _, p := range r.SeatedPlayers() {
+				ip := "hidden"
+				if role >= RoleModerator {
+					ip = p.IP.String()
+				}
+				status.Players = append(status.Players, protocol.RoomListPlayer{Name: p.Name, ID: p.ID, IP: ip})
+			}
+			list.Rooms = append(list.Rooms, status)
+			r.Mu.Unlock()
+		}
+		if err := s.sendMessage(player, list); err != nil {
+			s.log.Warn("Failed to send ROOM_LIST", "player_id", player.ID, "err", err)
+			player.Conn.Close()
+			return
+		}
+	}
+}
+
+// handleAdminCommand runs a command sent on an admin connection and answers
+// with OK or ERROR. Only moderators may run commands.
+func (s *Server) handleAdminCommand(player *room.Player, role AdminRole, msg []byte) {
+	command := protocol.Command(msg)
+	m, err := protocol.ParseClient(msg)
+	if err == nil && role < RoleModerator {
+		err = errors.New(RoleModerator.String() + " role required")
+	}
+	if err == nil {
+		switch m := m.(type) {
+		case *protocol.Kick:
+			err = s.Kick(m.PlayerID)
+		case *protocol.ResetRoom:
+			err = s.ResetRoom(m.RoomID)
+		case *protocol.SetLatency:
+			err = s.SetLatency(m.RoomID, m.Latency)
+		case *protocol.Broadcast:
+			s.Broadcast(m.Text)
+		default:
+			err = errors.New("not an admin command")
+		}
+	}
+
+	var reply protocol.Message = &protocol.AdminOK{For: command}
+	if err != nil {
+		s.log.Warn("Admin command failed", "player_id", player.ID, "command", command, "err", err)
+		reply = &protocol.AdminError{For: command, Reason: err.Error()}
+	} else {
+		s.log.Info("Admin command", "player_id", player.ID, "command", command)
+	}
+	if err := s.sendMessage(player, reply); err != nil {
+		s.log.Warn("Failed to send "+reply.Command(), "player_id", player.ID, "err", err)
+	}
+}
@@ -0,0 +1,275 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/zjx20/littlefighterhub/pkg/protocol"
+)
+
+// testServer starts a server with cfg behind a loopback HTTP server and
+// returns it with its WebSocket URL. Both are closed when the test ends.
+func testServer(t *testing.T, cfg Config) (*Server, string) {
+	t.Helper()
+	cfg.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	s, err := NewServerWithConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(http.HandlerFunc(s.HandleConnections))
+	t.Cleanup(srv.Close)
+	return s, "ws" + strings.TrimPrefix(srv.URL, "http")
+}
+
+// dialServer connects to url with the given handshake headers and reads the
+// YOUR_ID greeting, returning the connection and the player id it gives. The
+// connection is closed when the test ends.
+func dialServer(t *testing.T, url string, header http.Header) (*websocket.Conn, int) {
+	t.Helper()
+	conn, _, err := websocket.DefaultDialer.Dial(url, header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	msg := readServer(t, conn)
+	m, err := protocol.ParseServer([]byte(msg))
+	yourID, ok := m.(*protocol.YourID)
+	if !ok {
+		t.Fatalf("first message = %q, %v; want YOUR_ID", msg, err)
+	}
+	return conn, yourID.PlayerID
+}
+
+// readServer returns the next message the server sends on conn.
+func readServer(t *testing.T, conn *websocket.Conn) string {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, msg, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	return string(msg)
+}
+
+// writeServer sends msg to the server on conn.
+func writeServer(t *testing.T, conn *websocket.Conn, msg string) {
+	t.Helper()
+	if err := conn.WriteMessage(websocket.TextMessage, []byte(msg)); err != nil {
+		t.Fatalf("write %q: %v", msg, err)
+	}
+}
+
+// expectDisconnect checks that the server closes conn with a policy
+// violation giving reason.
+func expectDisconnect(t *testing.T, conn *websocket.Conn, reason string) {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		_, msg, err := conn.ReadMessage()
+		if err == nil {
+			// Broadcasts sent before the close are skipped.
+			continue
+		}
+		var ce *websocket.CloseError
+		if !errors.As(err, &ce) || ce.Code != websocket.ClosePolicyViolation || ce.Text != reason {
+			t.Fatalf("read = %q, %v; want close %d %q", msg, err, websocket.ClosePolicyViolation, reason)
+		}
+		return
+	}
+}
+
+func TestAdminRole(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.AdminToken = "moderator-secret"
+	cfg.ViewerToken = "viewer-secret"
+	s, _ := testServer(t, cfg)
+
+	tests := []struct {
+		token string
+		want  AdminRole
+	}{
+		{"", RoleNone},
+		{"wrong", RoleNone},
+		{"moderator", RoleNone},
+		{"moderator-secret ", RoleNone},
+		{"moderator-secret", RoleModerator},
+		{"viewer-secret", RoleViewer},
+	}
+	for _, tt := range tests {
+		if got := s.adminRole(tt.token); got != tt.want {
+			t.Errorf("adminRole(%q) = %v, want %v", tt.token, got, tt.want)
+		}
+	}
+}
+
+func TestAdminRoleWithoutTokens(t *testing.T) {
+	s, _ := testServer(t, DefaultConfig())
+	for _, token := range []string{"", "anything"} {
+		if got := s.adminRole(token); got != RoleNone {
+			t.Errorf("adminRole(%q) = %v, want %v", token, got, RoleNone)
+		}
+	}
+}
+
+func TestAdminRoleAfterReload(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.AdminToken = "old"
+	s, _ := testServer(t, cfg)
+
+	cfg.AdminToken = "new"
+	cfg.ViewerToken = "view"
+	if err := s.Reload(cfg); err != nil {
+		t.Fatal(err)
+	}
+	for token, want := range map[string]AdminRole{"old": RoleNone, "new": RoleModerator, "view": RoleViewer} {
+		if got := s.adminRole(token); got != want {
+			t.Errorf("adminRole(%q) = %v, want %v", token, got, want)
+		}
+	}
+}
+
+// TestAdminAuth connects to the admin channel in each way a secret can be
+// given. An accepted connection is told apart by its answer to a KICK: only
+// a moderator may run it, and there is no player 99 to kick.
+func TestAdminAuth(t *testing.T) {
+	const (
+		moderatorAnswer = "ERROR KICK player 99 is not connected"
+		viewerAnswer    = "ERROR KICK moderator role required"
+	)
+	tests := []struct {
+		name     string
+		disabled bool
+		query    string
+		header   http.Header
+		messages []string
+		// reason is the close reason of a rejected connection; answer is
+		// the reply to the KICK of an accepted one.
+		reason string
+		answer string
+	}{
+		{name: "token line moderator", messages: []string{"ADMIN\nmod"}, answer: moderatorAnswer},
+		{name: "token line viewer", messages: []string{"ADMIN\nview"}, answer: viewerAnswer},
+		{name: "auth message", messages: []string{"ADMIN", "AUTH\nmod"}, answer: moderatorAnswer},
+		{name: "query parameter", query: "?token=mod", messages: []string{"ADMIN"}, answer: moderatorAnswer},
+		{name: "bearer header", header: http.Header{"Authorization": {"Bearer view"}},
+			messages: []string{"ADMIN"}, answer: viewerAnswer},
+		{name: "header", header: http.Header{"X-Admin-Token": {"mod"}}, messages: []string{"ADMIN"}, answer: moderatorAnswer},
+		{name: "wrong token", messages: []string{"ADMIN\nwrong"}, reason: "unauthorized"},
+		{name: "wrong auth", messages: []string{"ADMIN", "AUTH\nwrong"}, reason: "unauthorized"},
+		{name: "empty auth", messages: []string{"ADMIN", "AUTH\n"}, reason: "unauthorized"},
+		{name: "missing auth", messages: []string{"ADMIN", "LIST"}, reason: "unauthorized"},
+		{name: "disabled", disabled: true, messages: []string{"ADMIN\nmod"}, reason: "admin disabled"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := DefaultConfig()
+			cfg.AdminToken = "mod"
+			cfg.ViewerToken = "view"
+			cfg.Features.Admin = !tt.disabled
+			_, url := testServer(t, cfg)
+			conn, _ := dialServer(t, url+tt.query, tt.header)
+			for _, msg := range tt.messages {
+				writeServer(t, conn, msg)
+			}
+			if tt.reason != "" {
+				expectDisconnect(t, conn, tt.reason)
+				return
+			}
+			writeServer(t, conn, "KICK 99")
+			if got := readServer(t, conn); got != tt.answer {
+				t.Errorf("answer = %q, want %q", got, tt.answer)
+			}
+		})
+	}
+}
+
+// TestAdminCommands runs each admin command from a moderator connection and
+// checks the answer and what the players get.
+func TestAdminCommands(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.AdminToken = "mod"
+	_, url := testServer(t, cfg)
+	p1, p1ID := joinServer(t, url, 1, "a")
+	p2, _ := joinServer(t, url, 1, "b")
+	p3, _ := joinServer(t, url, 2, "c")
+	admin, _ := dialServer(t, url, nil)
+	writeServer(t, admin, "ADMIN\nmod")
+
+	run := func(command, want string) {
+		t.Helper()
+		writeServer(t, admin, command)
+		if got := readServer(t, admin); got != want {
+			t.Fatalf("%s answered %q, want %q", command, got, want)
+		}
+	}
+	latencyOf := func(msg string) int {
+		t.Helper()
+		m, err := protocol.ParseServer([]byte(msg))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return m.(*protocol.PlayerList).Latency
+	}
+
+	run("SET_LATENCY 1 5", "OK SET_LATENCY")
+	if got := latencyOf(readUntil(t, p2, protocol.CmdPlayerList)); got != 5 {
+		t.Errorf("latency after SET_LATENCY = %d, want 5", got)
+	}
+	run("SET_LATENCY 1 0", "ERROR SET_LATENCY latency must be at least 1, got 0")
+	run("SET_LATENCY 9 5", "ERROR SET_LATENCY room 9 does not exist")
+
+	run("BROADCAST maintenance in 5 minutes", "OK BROADCAST")
+	for _, p := range []*websocket.Conn{p2, p3} {
+		want := fmt.Sprintf("CHAT\n%d\n%s\nmaintenance in 5 minutes", SystemPlayerID, systemChatName)
+		if got := readUntil(t, p, protocol.CmdChat); got != want {
+			t.Errorf("broadcast = %q, want %q", got, want)
+		}
+	}
+
+	run(fmt.Sprintf("KICK %d", p1ID), "OK KICK")
+	expectDisconnect(t, p1, "kicked")
+
+	run("RESET_ROOM 1", "OK RESET_ROOM")
+	if got := readUntil(t, p2, protocol.CmdLeftRoom); got != "LEFT_ROOM\n1" {
+		t.Errorf("after RESET_ROOM got %q, want LEFT_ROOM 1", got)
+	}
+	run("RESET_ROOM 9", "ERROR RESET_ROOM room 9 does not exist")
+
+	writeServer(t, admin, "KICK x")
+	if got := readServer(t, admin); !strings.HasPrefix(got, "ERROR KICK ") {
+		t.Errorf("KICK x answered %q, want an ERROR", got)
+	}
+	run("LIST", "ERROR LIST not an admin command")
+}
+
+// TestChangeLatencyBelowOne checks that a room keeps its latency when a
+// player asks for less than 1.
+func TestChangeLatencyBelowOne(t *testing.T) {
+	s, url := testServer(t, DefaultConfig())
+	p, _ := joinServer(t, url, 1, "a")
+	writeServer(t, p, "CHANGE_LATENCY\n0")
+	writeServer(t, p, "CHANGE_LATENCY\n4")
+	// Only the second one is answered with a PLAYER_LIST.
+	m, err := protocol.ParseServer([]byte(readUntil(t, p, protocol.CmdPlayerList)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := m.(*protocol.PlayerList).Latency; got != 4 {
+		t.Errorf("latency = %d, want 4", got)
+	}
+	r := s.Rooms[1]
+	r.Mu.Lock()
+	defer r.Mu.Unlock()
+	if r.Latency != 4 {
+		t.Errorf("room latency = %d, want 4", r.Latency)
+	}
+}
@@ -0,0 +1,367 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/zjx20/littlefighterhub/internal/achievement"
+	"github.com/zjx20/littlefighterhub/internal/impair"
+	"github.com/zjx20/littlefighterhub/pkg/protocol"
+)
+
+// PlayerInfo describes a player in a room, as reported by the admin API.
+type PlayerInfo struct {
+	ID           int             `json:"id"`
+	Name         string          `json:"name"`
+	RoomID       int             `json:"room_id"`
+	Seat         int             `json:"seat"`
+	IP           string          `json:"ip"`
+	ControlNames [4]string       `json:"control_names"`
+	Achievements achievement.Set `json:"achievements"`
+	// Violations counts the messages dropped for failing validation.
+	Violations int64 `json:"violations"`
+	// Bot is set for bot players added with AddBot.
+	Bot bool `json:"bot,omitempty"`
+	// Impairment is the simulated network impairment of the player's
+	// connection, if any.
+	Impairment *impair.Settings `json:"impairment,omitempty"`
+}
+
+// AchievementStats summarizes the achievements of the players in rooms.
+type AchievementStats struct {
+	Players int `json:"players"`
+	// Holders counts, per achievement, the players who have it.
+	Holders map[string]int `json:"holders"`
+	// MaxSurvival is the highest survival level reached by any player.
+	MaxSurvival int `json:"max_survival"`
+}
+
+// Occupancy summarizes how busy the server is.
+type Occupancy struct {
+	Rooms int `json:"rooms"`
+	// ActiveRooms counts the rooms in LOBBY or STARTED state.
+	ActiveRooms int `json:"active_rooms"`
+	Players     int `json:"players"`
+	// Capacity is the number of players all rooms can hold together.
+	Capacity int `json:"capacity"`
+}
+
+// Occupancy counts the rooms in use and the players in them.
+func (s *Server) Occupancy() Occupancy {
+	o := Occupancy{Rooms: len(s.Rooms), Capacity: len(s.Rooms) * s.config().RoomCapacity}
+	for _, r := range s.Rooms {
+		r.Mu.Lock()
+		if r.State != "VACANT" {
+			o.ActiveRooms++
+		}
+		o.Players += len(r.Players)
+		r.Mu.Unlock()
+	}
+	return o
+}
+
+// Players returns a snapshot of the players in all rooms, ordered by room
+// and seat. IPs are only included for moderators.
+func (s *Server) Players(role AdminRole) []PlayerInfo {
+	players := []PlayerInfo{}
+	for i := 1; i <= len(s.Rooms); i++ {
+		r := s.Rooms[i]
+		r.Mu.Lock()
+		for seat, p := range r.SeatedPlayers() {
+			ip := "hidden"
+			if role >= RoleModerator {
+				ip = p.IP.String()
+			}
+			players = append(players, PlayerInfo{
+				ID:           p.ID,
+				Name:         p.Name,
+				RoomID:       r.ID,
+				Seat:         seat,
+				IP:           ip,
+				ControlNames: [4]string{p.P1, p.P2, p.P3, p.P4},
+				Achievements: p.AchievementSet,
+				Violations:   p.Violations(),
+				Bot:          s.bots.isBot(p.ID),
+				Impairment:   impairmentOf(p),
+			})
+		}
+		r.Mu.Unlock()
+	}
+	return players
+}
+
+// AchievementStats aggregates the achievements of the players in all rooms.
+func (s *Server) AchievementStats() AchievementStats {
+	stats := AchievementStats{Holders: make(map[string]int)}
+	for _, p := range s.Players(RoleViewer) {
+		stats.Players++
+		for _, item := range p.Achievements.Items() {
+			stats.Holders[item]++
+		}
+		if survival := p.Achievements.MaxSurvival(); survival > stats.MaxSurvival {
+			stats.MaxSurvival = survival
+		}
+	}
+	return stats
+}
+
+// AdminHandler serves the HTTP admin API, authenticated with the same tokens
+// as the ADMIN channel:
+//
+//	GET  /admin/players                  players in rooms with their parsed achievements
+//	GET  /admin/achievements             achievement statistics over those players
+//	POST /admin/rooms/<id>/seats         move a player to another seat (moderator)
+//	GET  /admin/bans                     list the banned IPs and ranges
+//	POST /admin/bans                     ban an IP or range and kick its clients (moderator)
+//	DELETE /admin/bans?ip=<ip>           lift a ban added through the API (moderator)
+//	GET  /admin/impairments              list the simulated network impairments
+//	PUT  /admin/players/<id>/impairment  impair a player's connection (moderator)
+//	PUT  /admin/rooms/<id>/impairment    impair everyone in a room (moderator)
+//	DELETE .../impairment                remove either impairment (moderator)
+func (s *Server) AdminHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.config().Features.Admin {
+			http.NotFound(w, r)
+			return
+		}
+		role := s.adminRole(adminCredential(r))
+		if role == RoleNone {
+			s.log.Warn("Rejected unauthorized admin API request", "path", r.URL.Path, "remote_addr", r.RemoteAddr)
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		path := strings.TrimSuffix(r.URL.Path, "/")
+		switch {
+		case path == "/admin/players":
+			if allowMethod(w, r, http.MethodGet) {
+				writeJSON(w, s.Players(role))
+			}
+		case path == "/admin/achievements":
+			if allowMethod(w, r, http.MethodGet) {
+				writeJSON(w, s.AchievementStats())
+			}
+		case path == "/admin/bans":
+			s.serveBans(w, r, role)
+		case path == "/admin/impairments":
+			if allowMethod(w, r, http.MethodGet) {
+				writeJSON(w, s.Impairments())
+			}
+		case strings.HasPrefix(path, "/admin/players/"):
+			s.servePlayerAdmin(w, r, role, strings.TrimPrefix(path, "/admin/players/"))
+		case strings.HasPrefix(path, "/admin/rooms/"):
+			s.serveRoomAdmin(w, r, role, strings.TrimPrefix(path, "/admin/rooms/"))
+		default:
+			http.NotFound(w, r)
+		}
+	})
+}
+
+// serveRoomAdmin handles the /admin/rooms/<id>/... endpoints.
+func (s *Server) serveRoomAdmin(w http.ResponseWriter, r *http.Request, role AdminRole, path string) {
+	idStr, action, _ := strings.Cut(path, "/")
+	roomID, err := strconv.Atoi(idStr)
+	if err != nil || roomID < 1 || roomID > len(s.Rooms) {
+		http.NotFound(w, r)
+		return
+	}
+	switch action {
+	case "seats":
+		if !allowMethod(w, r, http.MethodPost) || !requireRole(w, role, RoleModerator) {
+			return
+		}
+		var req struct {
+			PlayerID int `json:"player_id"`
+			Seat     int `json:"seat"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid request: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := s.MoveSeat(roomID, req.PlayerID, req.Seat); err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		writeJSON(w, s.Players(role))
+	case "impairment":
+		s.serveImpairment(w, r, role, func(set impair.Settings) error {
+			return s.SetRoomImpairment(roomID, set)
+		})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// servePlayerAdmin handles the /admin/players/<id>/... endpoints.
+func (s *Server) servePlayerAdmin(w http.ResponseWriter, r *http.Request, role AdminRole, path string) {
+	idStr, action, _ := strings.Cut(path, "/")
+	playerID, err := strconv.Atoi(idStr)
+	if err != nil || action != "impairment" {
+		http.NotFound(w, r)
+		return
+	}
+	s.serveImpairment(w, r, role, func(set impair.Settings) error {
+		return s.SetPlayerImpairment(playerID, set)
+	})
+}
+
+// serveImpairment sets (PUT) or removes (DELETE) an impairment with set.
+func (s *Server) serveImpairment(w http.ResponseWriter, r *http.Request, role AdminRole, set func(impair.Settings) error) {
+	var req impair.Settings
+	switch r.Method {
+	case http.MethodPut:
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid request: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	case http.MethodDelete:
+	default:
+		w.Header().Set("Allow", "PUT, DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !requireRole(w, role, RoleModerator) {
+		return
+	}
+	if err := set(req); err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, errImpairmentDisabled) {
+			status = http.StatusForbidden
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	writeJSON(w, s.Impairments())
+}
+
+// serveBans handles /admin/bans.
+func (s *Server) serveBans(w http.ResponseWriter, r *http.Request, role AdminRole) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, s.Bans())
+	case http.MethodPost:
+		if !requireRole(w, role, RoleModerator) {
+			return
+		}
+		var req struct {
+			IP string `json:"ip"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid request: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		kicked, err := s.Ban(req.IP)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, map[string]any{"bans": s.Bans(), "disconnected": kicked})
+	case http.MethodDelete:
+		if !requireRole(w, role, RoleModerator) {
+			return
+		}
+		if !s.Unban(r.URL.Query().Get("ip")) {
+			http.Error(w, "no such ban added through the API", http.StatusNotFound)
+			return
+		}
+		writeJSON(w, s.Bans())
+	default:
+		w.Header().Set("Allow", "GET, POST, DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// MoveSeat moves a player of a room to another seat, counted from 0, and
+// broadcasts the new order. Seat 0 makes the player the room owner.
+func (s *Server) MoveSeat(roomID, playerID, seat int) error {
+	r, ok := s.Rooms[roomID]
+	if !ok {
+		return fmt.Errorf("room %d does not exist", roomID)
+	}
+	r.Mu.Lock()
+	defer r.Mu.Unlock()
+	if err := r.MoveSeat(playerID, seat); err != nil {
+		return err
+	}
+	s.log.Info("Player seat changed", "room_id", roomID, "player_id", playerID, "seat", seat)
+	s.broadcastPlayerList(r)
+	return nil
+}
+
+// ResetRoom takes every player out of a room as if they had left, ending a
+// match in progress. The players stay connected and get LEFT_ROOM.
+func (s *Server) ResetRoom(roomID int) error {
+	r, ok := s.Rooms[roomID]
+	if !ok {
+		return fmt.Errorf("room %d does not exist", roomID)
+	}
+	r.Mu.Lock()
+	defer r.Mu.Unlock()
+	players := r.SeatedPlayers()
+	for _, p := range players {
+		s.removeFromRoom(r, p, false)
+		if err := s.sendMessage(p, &protocol.LeftRoom{RoomID: roomID}); err != nil {
+			s.log.Warn("Failed to send LEFT_ROOM", "player_id", p.ID, "err", err)
+		}
+	}
+	s.log.Info("Room reset", "room_id", roomID, "players", len(players))
+	return nil
+}
+
+// SetLatency changes the latency of a room and broadcasts the new
+// PLAYER_LIST, like CHANGE_LATENCY from a player in it.
+func (s *Server) SetLatency(roomID, latency int) error {
+	r, ok := s.Rooms[roomID]
+	if !ok {
+		return fmt.Errorf("room %d does not exist", roomID)
+	}
+	// The same bound as Config.Validate puts on DefaultLatency.
+	if latency < 1 {
+		return fmt.Errorf("latency must be at least 1, got %d", latency)
+	}
+	r.Mu.Lock()
+	defer r.Mu.Unlock()
+	r.Latency = latency
+	s.log.Info("Room latency set", "room_id", roomID, "latency", latency)
+	s.broadcastPlayerList(r)
+	return nil
+}
+
+// Broadcast sends a system CHAT to the players of every room.
+func (s *Server) Broadcast(text string) {
+	for _, r := range s.Rooms {
+		r.Mu.Lock()
+		s.broadcastSystemChat(r, text)
+		r.Mu.Unlock()
+	}
+	s.log.Info("Broadcast sent", "text", text)
+}
+
+func allowMethod(w http.ResponseWriter, r *http.Request, method string) bool {
+	if r.Method != method {
+		w.Header().Set("Allow", method)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return false
+	}
+	return true
+}
+
+func requireRole(w http.ResponseWriter, role, required AdminRole) bool {
+	if role < required {
+		http.Error(w, required.String()+" role required", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
@@ -0,0 +1,162 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/zjx20/littlefighterhub/internal/room"
+)
+
+// parseBan converts an IP address or CIDR range to a network.
+func parseBan(entry string) (*net.IPNet, error) {
+	entry = strings.TrimSpace(entry)
+	if strings.Contains(entry, "/") {
+		_, ipNet, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ban %q: %w", entry, err)
+		}
+		return ipNet, nil
+	}
+	ip := net.ParseIP(entry)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid ban %q: not an IP address or CIDR range", entry)
+	}
+	bits := 8 * net.IPv6len
+	if ip4 := ip.To4(); ip4 != nil {
+		ip, bits = ip4, 8*net.IPv4len
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+}
+
+func parseBans(entries []string) ([]*net.IPNet, error) {
+	bans := make([]*net.IPNet, 0, len(entries))
+	for _, entry := range entries {
+		ipNet, err := parseBan(entry)
+		if err != nil {
+			return nil, err
+		}
+		bans = append(bans, ipNet)
+	}
+	return bans, nil
+}
+
+// addrIP extracts the IP of a "host:port" address, or of a net.Addr.
+func addrIP(addr string) net.IP {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	return net.ParseIP(host)
+}
+
+// isBanned reports whether the address falls into a configured ban or one
+// added at runtime with Ban.
+func (s *Server) isBanned(addr string) bool {
+	ip := addrIP(addr)
+	if ip == nil {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, list := range [][]*net.IPNet{s.bans, s.runtimeBans} {
+		for _, ipNet := range list {
+			if ipNet.Contains(ip) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Bans lists the configured bans followed by the ones added with Ban.
+func (s *Server) Bans() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	bans := []string{}
+	for _, list := range [][]*net.IPNet{s.bans, s.runtimeBans} {
+		for _, ipNet := range list {
+			bans = append(bans, ipNet.String())
+		}
+	}
+	return bans
+}
+
+// Ban refuses further connections from an IP address or CIDR range and
+// disconnects the matching clients, returning how many were disconnected.
+// Runtime bans survive Reload but not a restart; use Config.Bans for
+// permanent ones.
+func (s *Server) Ban(entry string) (int, error) {
+	ipNet, err := parseBan(entry)
+	if err != nil {
+		return 0, err
+	}
+	s.mu.Lock()
+	s.runtimeBans = append(s.runtimeBans, ipNet)
+	var conns []*websocket.Conn
+	for conn, p := range s.Clients {
+		if ip := addrIP(p.IP.String()); ip != nil && ipNet.Contains(ip) {
+			conns = append(conns, conn)
+			s.emit(PlayerKicked{PlayerID: p.ID, Name: p.Name, RoomID: p.RoomID(), Reason: "banned"})
+		}
+	}
+	s.mu.Unlock()
+
+	for _, conn := range conns {
+		disconnect(conn, "banned")
+	}
+	s.log.Info("Banned", "ban", ipNet.String(), "disconnected", len(conns))
+	return len(conns), nil
+}
+
+// Kick disconnects a client with a policy violation close frame.
+func (s *Server) Kick(playerID int) error {
+	s.mu.Lock()
+	var target *room.Player
+	for _, p := range s.Clients {
+		if p.ID == playerID {
+			target = p
+			break
+		}
+	}
+	if target == nil {
+		s.mu.Unlock()
+		return fmt.Errorf("player %d is not connected", playerID)
+	}
+	s.emit(PlayerKicked{PlayerID: target.ID, Name: target.Name, RoomID: target.RoomID(), Reason: "kicked"})
+	s.mu.Unlock()
+
+	disconnect(target.Conn, "kicked")
+	s.log.Info("Player kicked", "player_id", playerID)
+	return nil
+}
+
+// disconnect closes a connection with a policy violation close frame giving
+// the reason.
+func disconnect(conn *websocket.Conn, reason string) {
+	closeMsg := websocket.FormatCloseMessage(websocket.ClosePolicyViolation, reason)
+	conn.WriteControl(websocket.CloseMessage, closeMsg, time.Now().Add(time.Second))
+	conn.Close()
+}
+
+// Unban removes a ban added with Ban. Configured bans can only be removed
+// from the configuration.
+func (s *Server) Unban(entry string) bool {
+	ipNet, err := parseBan(entry)
+	if err != nil {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, b := range s.runtimeBans {
+		if b.String() == ipNet.String() {
+			s.runtimeBans = append(s.runtimeBans[:i], s.runtimeBans[i+1:]...)
+			s.log.Info("Unbanned", "ban", ipNet.String())
+			return true
+		}
+	}
+	return false
+}
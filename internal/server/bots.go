@@ -0,0 +1,379 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/zjx20/littlefighterhub/internal/bot"
+	"github.com/zjx20/littlefighterhub/internal/room"
+	"github.com/zjx20/littlefighterhub/pkg/lf2client"
+)
+
+// Chat commands of the room owner, handled while the bots feature is on:
+//
+//	/addbot [idle|random|replay <transcript>]
+//	/removebot [<player id>|all]
+//
+// A replay transcript is named without its ".log" suffix and is looked up in
+// RoomLogDir. /removebot without an argument removes the bot added last.
+const (
+	cmdAddBot    = "/addbot"
+	cmdRemoveBot = "/removebot"
+)
+
+// How long a bot may take to leave its room when removed.
+const botStopTimeout = 2 * time.Second
+
+var transcriptNameRe = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// ErrNotBot is returned by RemoveBot for players that are not bots.
+var ErrNotBot = errors.New("not a bot")
+
+// bots are the bot players of a server. A bot connects like any other
+// client, over an in-memory pipe served by HandleConnections, so it takes the
+// same code paths as a person would.
+type bots struct {
+	mu       sync.Mutex
+	listener *pipeListener // nil until the first bot is added
+	http     *http.Server
+	running  map[int]*runningBot
+	// added numbers the bots for their names.
+	added int
+}
+
+type runningBot struct {
+	id     int
+	roomID int
+	name   string
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// dialer returns a dialer connecting to the server over the pipe listener,
+// starting it first if needed. bots.mu must be held.
+func (b *bots) dialer(s *Server) *lf2client.Dialer {
+	if b.listener == nil {
+		b.listener = newPipeListener()
+		b.http = &http.Server{Handler: http.HandlerFunc(s.HandleConnections)}
+		go b.http.Serve(b.listener)
+	}
+	return &lf2client.Dialer{WebSocket: &websocket.Dialer{
+		NetDialContext:   b.listener.DialContext,
+		HandshakeTimeout: 5 * time.Second,
+	}}
+}
+
+func (b *bots) isBot(playerID int) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	_, ok := b.running[playerID]
+	return ok
+}
+
+func (b *bots) count() float64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return float64(len(b.running))
+}
+
+// AddBot connects a bot that joins the room and plays script once a match
+// starts. It returns the bot's player ID after the bot has joined.
+func (s *Server) AddBot(roomID int, script bot.Script) (int, error) {
+	if s.isDraining() {
+		return 0, errors.New("server is shutting down")
+	}
+	r, ok := s.Rooms[roomID]
+	if !ok {
+		return 0, fmt.Errorf("room %d does not exist", roomID)
+	}
+	capacity := s.config().RoomCapacity
+	r.Mu.Lock()
+	full := len(r.Players) >= capacity
+	r.Mu.Unlock()
+	if full {
+		return 0, fmt.Errorf("room %d is full", roomID)
+	}
+
+	s.bots.mu.Lock()
+	dialer := s.bots.dialer(s)
+	s.bots.added++
+	name := "Bot" + strconv.Itoa(s.bots.added)
+	s.bots.mu.Unlock()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	c, err := dialer.Dial(ctx, "ws://bot/")
+	if err != nil {
+		cancel()
+		return 0, fmt.Errorf("connecting bot: %w", err)
+	}
+	rb := &runningBot{id: c.ID(), roomID: roomID, name: name, cancel: cancel, done: make(chan struct{})}
+	// Registered before joining, so the bot counts as one as soon as it is
+	// in the room.
+	s.bots.mu.Lock()
+	if s.bots.running == nil {
+		s.bots.running = make(map[int]*runningBot)
+	}
+	s.bots.running[rb.id] = rb
+	s.bots.mu.Unlock()
+
+	b := &bot.Bot{Name: name, Script: script, Log: s.log}
+	if err := b.Join(ctx, c, roomID); err != nil {
+		s.endBot(rb, c)
+		return 0, err
+	}
+	go func() {
+		if err := b.Play(ctx, c, roomID); err != nil && !errors.Is(err, lf2client.ErrClosed) {
+			s.log.Warn("Bot stopped", "bot", name, "player_id", rb.id, "err", err)
+		}
+		s.endBot(rb, c)
+	}()
+	return rb.id, nil
+}
+
+// endBot disconnects a bot and forgets it.
+func (s *Server) endBot(rb *runningBot, c *lf2client.Client) {
+	rb.cancel()
+	c.Close()
+	s.bots.mu.Lock()
+	delete(s.bots.running, rb.id)
+	s.bots.mu.Unlock()
+	close(rb.done)
+}
+
+// RemoveBot makes a bot leave its room and disconnect.
+func (s *Server) RemoveBot(playerID int) error {
+	s.bots.mu.Lock()
+	rb, ok := s.bots.running[playerID]
+	s.bots.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("player %d: %w", playerID, ErrNotBot)
+	}
+	rb.cancel()
+	select {
+	case <-rb.done:
+	case <-time.After(botStopTimeout):
+		return fmt.Errorf("bot %d did not stop in time", playerID)
+	}
+	return nil
+}
+
+// Bots returns the player IDs of the bots in a room, oldest first.
+func (s *Server) Bots(roomID int) []int {
+	s.bots.mu.Lock()
+	defer s.bots.mu.Unlock()
+	var ids []int
+	for id, rb := range s.bots.running {
+		if rb.roomID == roomID {
+			ids = append(ids, id)
+		}
+	}
+	// Player IDs grow with every connection.
+	sort.Ints(ids)
+	return ids
+}
+
+// stopLonelyBots cancels the bots of r, which must be locked, once no person
+// is left in it. They leave on their own, so this does not wait.
+func (s *Server) stopLonelyBots(r *room.Room) {
+	s.bots.mu.Lock()
+	defer s.bots.mu.Unlock()
+	for id := range r.Players {
+		if _, ok := s.bots.running[id]; !ok {
+			return
+		}
+	}
+	for id := range r.Players {
+		s.bots.running[id].cancel()
+	}
+}
+
+// closeBots stops every bot and the listener they connect through.
+func (s *Server) closeBots() {
+	s.bots.mu.Lock()
+	running := make([]*runningBot, 0, len(s.bots.running))
+	for _, rb := range s.bots.running {
+		running = append(running, rb)
+	}
+	srv := s.bots.http
+	s.bots.mu.Unlock()
+
+	for _, rb := range running {
+		rb.cancel()
+		<-rb.done
+	}
+	if srv != nil {
+		srv.Close()
+	}
+}
+
+// handleBotCommand runs /addbot and /removebot sent by the room owner in the
+// lobby. It reports whether text was such a command and must not be relayed.
+func (s *Server) handleBotCommand(player *room.Player, r *room.Room, text string) bool {
+	if !s.config().Features.Bots {
+		return false
+	}
+	args := strings.Fields(text)
+	if len(args) == 0 || (args[0] != cmdAddBot && args[0] != cmdRemoveBot) {
+		return false
+	}
+
+	r.Mu.Lock()
+	owner, state := r.Owner(), r.State
+	r.Mu.Unlock()
+	switch {
+	case owner == nil || owner.ID != player.ID:
+		s.replySystemChat(player, "Only the room owner can add or remove bots.")
+		return true
+	case state != "LOBBY":
+		s.replySystemChat(player, "Bots can only be changed in the lobby.")
+		return true
+	}
+
+	// Joining takes the room's lock, so the command runs apart from the
+	// connection's read loop.
+	go func() {
+		var reply string
+		var err error
+		if args[0] == cmdAddBot {
+			reply, err = s.runAddBot(r.ID, args[1:])
+		} else {
+			reply, err = s.runRemoveBot(r.ID, args[1:])
+		}
+		if err != nil {
+			s.replySystemChat(player, fmt.Sprintf("%s failed: %v", args[0], err))
+			return
+		}
+		r.Mu.Lock()
+		s.broadcastSystemChat(r, reply)
+		r.Mu.Unlock()
+	}()
+	return true
+}
+
+func (s *Server) runAddBot(roomID int, args []string) (string, error) {
+	name, transcript := bot.ScriptIdle, ""
+	if len(args) > 0 {
+		name = args[0]
+	}
+	if name == bot.ScriptReplay {
+		if len(args) < 2 || !transcriptNameRe.MatchString(args[1]) {
+			return "", fmt.Errorf("usage is %s %s <transcript>", cmdAddBot, bot.ScriptReplay)
+		}
+		dir := s.config().RoomLogDir
+		if dir == "" {
+			return "", errors.New("no room transcripts to replay")
+		}
+		transcript = filepath.Join(dir, args[1]+".log")
+	}
+	script, err := bot.NewScript(name, transcript)
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", fmt.Errorf("no transcript %s", args[1])
+	}
+	if err != nil {
+		return "", err
+	}
+	id, err := s.AddBot(roomID, script)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Added bot %d (%s).", id, name), nil
+}
+
+func (s *Server) runRemoveBot(roomID int, args []string) (string, error) {
+	ids := s.Bots(roomID)
+	if len(ids) == 0 {
+		return "", errors.New("there are no bots in the room")
+	}
+	switch {
+	case len(args) == 0:
+		ids = ids[len(ids)-1:]
+	case args[0] == "all":
+	default:
+		id, err := strconv.Atoi(args[0])
+		if err != nil || !containsID(ids, id) {
+			return "", fmt.Errorf("%s is not a bot in the room", args[0])
+		}
+		ids = []int{id}
+	}
+	for _, id := range ids {
+		if err := s.RemoveBot(id); err != nil {
+			return "", err
+		}
+	}
+	if len(ids) == 1 {
+		return fmt.Sprintf("Removed bot %d.", ids[0]), nil
+	}
+	return fmt.Sprintf("Removed %d bots.", len(ids)), nil
+}
+
+func containsID(ids []int, id int) bool {
+	for _, v := range ids {
+		if v == id {
+			return true
+		}
+	}
+	return false
+}
+
+// pipeListener is a net.Listener whose connections are made in memory by
+// DialContext.
+type pipeListener struct {
+	conns  chan net.Conn
+	closed chan struct{}
+	once   sync.Once
+}
+
+func newPipeListener() *pipeListener {
+	return &pipeListener{conns: make(chan net.Conn), closed: make(chan struct{})}
+}
+
+func (l *pipeListener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.conns:
+		return c, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *pipeListener) Close() error {
+	l.once.Do(func() { close(l.closed) })
+	return nil
+}
+
+func (l *pipeListener) Addr() net.Addr { return pipeAddr{} }
+
+// DialContext connects to the listener; network and address are ignored.
+func (l *pipeListener) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	server, client := net.Pipe()
+	select {
+	case l.conns <- server:
+		return client, nil
+	case <-ctx.Done():
+		server.Close()
+		client.Close()
+		return nil, ctx.Err()
+	case <-l.closed:
+		server.Close()
+		client.Close()
+		return nil, net.ErrClosed
+	}
+}
+
+type pipeAddr struct{}
+
+func (pipeAddr) Network() string { return "pipe" }
+func (pipeAddr) String() string  { return "bot" }
@@ -0,0 +1,133 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/zjx20/littlefighterhub/internal/room"
+	"github.com/zjx20/littlefighterhub/pkg/protocol"
+)
+
+// BenchmarkBroadcast measures sending one message to the other 7 players of
+// a full room, encoding it for each connection versus framing it once as a
+// websocket.PreparedMessage, with and without permessage-deflate. The
+// connections are real loopback WebSockets whose clients discard what they
+// read. prepare only uses PreparedMessage with compression, because of what
+// this shows:
+//
+//	go test -run XXX -bench BenchmarkBroadcast ./internal/server
+func BenchmarkBroadcast(b *testing.B) {
+	const recipients = 7
+	for _, compress := range []bool{false, true} {
+		for _, kind := range []string{"frame", "player_list"} {
+			msg := benchmarkMessage(b, kind)
+			s, players := benchmarkPlayers(b, recipients, compress)
+			name := fmt.Sprintf("compress=%v/%s", compress, kind)
+			b.Run(name+"/per-connection", func(b *testing.B) {
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					for _, p := range players {
+						if err := s.send(p, msg); err != nil {
+							b.Fatal(err)
+						}
+					}
+				}
+			})
+			b.Run(name+"/prepared", func(b *testing.B) {
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					pm, err := websocket.NewPreparedMessage(websocket.TextMessage, msg)
+					if err != nil {
+						b.Fatal(err)
+					}
+					for _, p := range players {
+						if err := s.sendPrepared(p, pm, msg); err != nil {
+							b.Fatal(err)
+						}
+					}
+				}
+			})
+		}
+	}
+}
+
+// benchmarkMessage encodes a typical message of the given kind.
+func benchmarkMessage(b *testing.B, kind string) []byte {
+	var m protocol.Message
+	switch kind {
+	case "frame":
+		m = &protocol.Frame{PlayerID: 3, Seq: 1234, Keys: [4]int{17, 0, 0, 0}, Checksum: 58213}
+	case "player_list":
+		list := &protocol.PlayerList{RoomID: 1, Latency: 3}
+		for i := 1; i <= 8; i++ {
+			list.Players = append(list.Players, protocol.PlayerEntry{
+				ID:           i,
+				Name:         fmt.Sprintf("Player%d", i),
+				Controls:     [4]string{fmt.Sprintf("Player%d", i), "Com", "", ""},
+				Achievements: strings.Repeat("0", 64),
+			})
+		}
+		m = list
+	}
+	msg, err := protocol.Encode(m)
+	if err != nil {
+		b.Fatal(err)
+	}
+	return msg
+}
+
+// benchmarkPlayers returns a server and n players connected to it over
+// loopback, which are closed when the benchmark ends.
+func benchmarkPlayers(b *testing.B, n int, compress bool) (*Server, []*room.Player) {
+	cfg := DefaultConfig()
+	cfg.Features.Compression = compress
+	cfg.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	s, err := NewServerWithConfig(cfg)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	accepted := make(chan *websocket.Conn)
+	upgrader := websocket.Upgrader{EnableCompression: compress}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		accepted <- ws
+	}))
+	b.Cleanup(srv.Close)
+	dialer := websocket.Dialer{EnableCompression: compress}
+	url := "ws" + strings.TrimPrefix(srv.URL, "http")
+
+	var players []*room.Player
+	for i := 0; i < n; i++ {
+		client, _, err := dialer.Dial(url, nil)
+		if err != nil {
+			b.Fatal(err)
+		}
+		conn := <-accepted
+		b.Cleanup(func() {
+			client.Close()
+			conn.Close()
+		})
+		go func() {
+			for {
+				_, r, err := client.NextReader()
+				if err != nil {
+					return
+				}
+				io.Copy(io.Discard, r)
+			}
+		}()
+		players = append(players, &room.Player{ID: i + 1, Conn: conn, IP: conn.RemoteAddr()})
+	}
+	return s, players
+}
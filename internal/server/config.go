@@ -0,0 +1,147 @@
+package server
+
+import (
+	"fmt"
+	"log/slog"
+
+	"github.com/zjx20/littlefighterhub/internal/metrics"
+)
+
+// Config holds the tunable settings of a Server.
+type Config struct {
+	// Rooms is the number of rooms, numbered from 1. It is fixed for the
+	// lifetime of the server.
+	Rooms int
+	// RoomCapacity is the maximum number of players per room.
+	RoomCapacity int
+	// DefaultLatency is the latency given to vacant rooms.
+	DefaultLatency int
+	// AdminToken grants moderator access to the ADMIN channel.
+	AdminToken string
+	// ViewerToken grants read-only access to the ADMIN channel.
+	ViewerToken string
+	// Bans lists the IP addresses and CIDR ranges refused at connect time.
+	Bans []string
+	// RoomLogDir is where per-room transcripts are written when the
+	// RoomLogs feature is on. It cannot be changed by Reload.
+	RoomLogDir string
+	Features   Features
+	// Webhooks receive the server's events.
+	Webhooks []Webhook
+	// Registry receives the server's metrics. A new registry is created
+	// when it is nil. It cannot be changed by Reload.
+	Registry *metrics.Registry
+	// Logger is used for all logging. slog.Default() is used when it is nil.
+	// It cannot be changed by Reload.
+	Logger *slog.Logger
+}
+
+// Features are optional behaviours that can be switched on and off.
+type Features struct {
+	// Admin enables the ADMIN channel.
+	Admin bool `json:"admin"`
+	// Metrics enables the /metrics endpoint.
+	Metrics bool `json:"metrics"`
+	// RoomLogs writes a protocol transcript per room to RoomLogDir.
+	RoomLogs bool `json:"room_logs"`
+	// StrictRelay validates FRAME and AWAY before relaying them and drops
+	// messages that fail. UPDATE_CONTROL_NAMES is always validated.
+	StrictRelay bool `json:"strict_relay"`
+	// Bots lets room owners add bot players with the /addbot and
+	// /removebot chat commands.
+	Bots bool `json:"bots"`
+	// Impairment lets moderators simulate poor connections through the
+	// admin API.
+	Impairment bool `json:"impairment"`
+	// Compression negotiates permessage-deflate with clients that offer
+	// it. Broadcasts are then compressed once for all of their recipients.
+	Compression bool `json:"compression"`
+	// Lockstep relays the FRAMEs of a match one seq at a time, once every
+	// player has sent it, in seat order. It takes effect at the next START.
+	Lockstep bool `json:"lockstep"`
+	// Events serves the server's events as a server-sent event stream at
+	// /events.
+	Events bool `json:"events"`
+}
+
+// DefaultConfig returns the settings matching the original LF2 room server.
+func DefaultConfig() Config {
+	return Config{
+		Rooms:          8,
+		RoomCapacity:   8,
+		DefaultLatency: 3,
+		Features: Features{
+			Admin:       true,
+			Metrics:     true,
+			StrictRelay: true,
+		},
+	}
+}
+
+// Validate reports the first setting that is out of range.
+func (c Config) Validate() error {
+	if c.Rooms < 1 {
+		return fmt.Errorf("rooms must be at least 1, got %d", c.Rooms)
+	}
+	if c.RoomCapacity < 1 || c.RoomCapacity > 8 {
+		return fmt.Errorf("room capacity must be between 1 and 8, got %d", c.RoomCapacity)
+	}
+	if c.DefaultLatency < 1 {
+		return fmt.Errorf("default latency must be at least 1, got %d", c.DefaultLatency)
+	}
+	if _, err := parseBans(c.Bans); err != nil {
+		return err
+	}
+	for _, h := range c.Webhooks {
+		if err := h.validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// config returns the current configuration. It takes no lock, so it may be
+// called with a room locked.
+func (s *Server) config() Config {
+	return *s.cfg.Load()
+}
+
+// Reload applies the settings that can change while the server is running:
+// room capacity, default latency, admin tokens, bans, message logging,
+// webhooks and feature toggles. Bans added with Ban are kept. The number of
+// rooms cannot change without a restart.
+func (s *Server) Reload(cfg Config) error {
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	bans, _ := parseBans(cfg.Bans)
+	tokens := cfg.adminTokens()
+
+	s.mu.Lock()
+	current := s.config()
+	if cfg.Rooms != current.Rooms {
+		s.log.Warn("Changing the number of rooms requires a restart", "rooms", current.Rooms)
+		cfg.Rooms = current.Rooms
+	}
+	cfg.Registry = current.Registry
+	cfg.Logger = current.Logger
+	cfg.RoomLogDir = current.RoomLogDir
+	s.cfg.Store(&cfg)
+	s.adminTokens = tokens
+	s.bans = bans
+	s.mu.Unlock()
+
+	s.transcripts.setEnabled(cfg.Features.RoomLogs)
+	s.webhooks.configure(cfg.Webhooks)
+
+	for _, r := range s.Rooms {
+		r.Mu.Lock()
+		if r.State == "VACANT" {
+			r.Latency = cfg.DefaultLatency
+		}
+		r.Mu.Unlock()
+	}
+	s.log.Info("Configuration reloaded", "room_capacity", cfg.RoomCapacity,
+		"default_latency", cfg.DefaultLatency, "features", cfg.Features)
+	return nil
+}
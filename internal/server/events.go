@@ -0,0 +1,339 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zjx20/littlefighterhub/internal/room"
+	"github.com/zjx20/littlefighterhub/pkg/protocol"
+)
+
+// Event types, as found in Event.Type.
+const (
+	EventPlayerConnected = "player_connected"
+	EventPlayerJoined    = "player_joined"
+	EventPlayerLeft      = "player_left"
+	EventRoomStarted     = "room_started"
+	EventMatchEnded      = "match_ended"
+	EventDesync          = "desync"
+	EventPlayerKicked    = "player_kicked"
+)
+
+// eventTypes lists the valid event types.
+var eventTypes = []string{
+	EventPlayerConnected, EventPlayerJoined, EventPlayerLeft, EventRoomStarted,
+	EventMatchEnded, EventDesync, EventPlayerKicked,
+}
+
+const (
+	// recentEvents is how many events are kept for /events clients that
+	// reconnect with Last-Event-ID.
+	recentEvents = 256
+	// streamBuffer is how many events an /events client may fall behind
+	// before events are dropped for it.
+	streamBuffer = 64
+	// How often an idle /events stream gets a comment, so that proxies do
+	// not time it out.
+	streamKeepalive = 15 * time.Second
+)
+
+// Event is something that happened on the server, as delivered to webhooks
+// and /events clients.
+type Event struct {
+	// ID numbers the events of the server from 1.
+	ID   int64     `json:"id"`
+	Type string    `json:"type"`
+	Time time.Time `json:"time"`
+	Data EventData `json:"data"`
+}
+
+// EventData is the payload of an event. Its type determines the event type.
+type EventData interface {
+	EventType() string
+}
+
+// PlayerConnected is published for every new WebSocket client, including
+// ADMIN connections, which are only told apart by their first message.
+type PlayerConnected struct {
+	PlayerID int `json:"player_id"`
+}
+
+// PlayerJoined is published when a player enters a room.
+type PlayerJoined struct {
+	PlayerID int    `json:"player_id"`
+	Name     string `json:"name"`
+	RoomID   int    `json:"room_id"`
+	// Players is the number of players in the room now.
+	Players int `json:"players"`
+}
+
+// PlayerLeft is published when a player leaves a room, on their own or by
+// disconnecting.
+type PlayerLeft struct {
+	PlayerID     int    `json:"player_id"`
+	Name         string `json:"name"`
+	RoomID       int    `json:"room_id"`
+	Disconnected bool   `json:"disconnected"`
+}
+
+// RoomStarted is published for every START.
+type RoomStarted struct {
+	RoomID int `json:"room_id"`
+	// Players are the names of the players in seat order.
+	Players  []string `json:"players"`
+	Lockstep bool     `json:"lockstep"`
+}
+
+// MatchEnded is published when the last player leaves a started room.
+type MatchEnded struct {
+	RoomID          int     `json:"room_id"`
+	DurationSeconds float64 `json:"duration_seconds"`
+}
+
+// Desync is published the first time the players of a match report
+// different state checksums for the same FRAME seq.
+type Desync struct {
+	RoomID int `json:"room_id"`
+	Seq    int `json:"seq"`
+	// Checksums maps player IDs to the checksum they reported.
+	Checksums map[int]int `json:"checksums"`
+}
+
+// PlayerKicked is published when the server disconnects a player because
+// of a ban, a KICK from an admin or a stalled lockstep match. A PlayerLeft
+// follows if they were in a room.
+type PlayerKicked struct {
+	PlayerID int    `json:"player_id"`
+	Name     string `json:"name"`
+	RoomID   int    `json:"room_id"`
+	Reason   string `json:"reason"`
+}
+
+func (PlayerConnected) EventType() string { return EventPlayerConnected }
+func (PlayerJoined) EventType() string    { return EventPlayerJoined }
+func (PlayerLeft) EventType() string      { return EventPlayerLeft }
+func (RoomStarted) EventType() string     { return EventRoomStarted }
+func (MatchEnded) EventType() string      { return EventMatchEnded }
+func (Desync) EventType() string          { return EventDesync }
+func (PlayerKicked) EventType() string    { return EventPlayerKicked }
+
+// eventFilter returns whether to pass events of a type, given the types
+// asked for; no types means all of them.
+func eventFilter(types []string) func(string) bool {
+	if len(types) == 0 {
+		return func(string) bool { return true }
+	}
+	want := make(map[string]bool)
+	for _, t := range types {
+		want[t] = true
+	}
+	return func(t string) bool { return want[t] }
+}
+
+// checkEventTypes reports an unknown event type.
+func checkEventTypes(types []string) error {
+	for _, t := range types {
+		known := false
+		for _, k := range eventTypes {
+			known = known || t == k
+		}
+		if !known {
+			return fmt.Errorf("unknown event type %q", t)
+		}
+	}
+	return nil
+}
+
+// eventBus hands the events of a server to its subscribers: the webhooks
+// and the /events streams. Publishing never blocks; a subscriber that falls
+// behind loses events.
+type eventBus struct {
+	mu     sync.Mutex
+	nextID int64
+	recent []Event
+	subs   map[*subscription]bool
+	onDrop func()
+}
+
+// subscription receives events on ch, which is closed when it ends.
+type subscription struct {
+	ch     chan Event
+	accept func(string) bool
+	// stream is set for /events clients.
+	stream bool
+}
+
+func newEventBus(onDrop func()) *eventBus {
+	return &eventBus{nextID: 1, subs: make(map[*subscription]bool), onDrop: onDrop}
+}
+
+func (b *eventBus) publish(data EventData) Event {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	ev := Event{ID: b.nextID, Type: data.EventType(), Time: time.Now(), Data: data}
+	b.nextID++
+	if len(b.recent) == recentEvents {
+		copy(b.recent, b.recent[1:])
+		b.recent = b.recent[:recentEvents-1]
+	}
+	b.recent = append(b.recent, ev)
+	for sub := range b.subs {
+		if !sub.accept(ev.Type) {
+			continue
+		}
+		select {
+		case sub.ch <- ev:
+		default:
+			b.onDrop()
+		}
+	}
+	return ev
+}
+
+// subscribe starts a subscription to the events accepted. The events after
+// afterID still remembered are returned to be handled first.
+func (b *eventBus) subscribe(buffer int, accept func(string) bool, stream bool, afterID int64) (*subscription, []Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	sub := &subscription{ch: make(chan Event, buffer), accept: accept, stream: stream}
+	b.subs[sub] = true
+	var backlog []Event
+	if afterID > 0 {
+		for _, ev := range b.recent {
+			if ev.ID > afterID && accept(ev.Type) {
+				backlog = append(backlog, ev)
+			}
+		}
+	}
+	return sub, backlog
+}
+
+// unsubscribe ends a subscription unless it already ended.
+func (b *eventBus) unsubscribe(sub *subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.subs[sub] {
+		delete(b.subs, sub)
+		close(sub.ch)
+	}
+}
+
+// closeStreams ends the subscriptions of all /events clients.
+func (b *eventBus) closeStreams() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for sub := range b.subs {
+		if sub.stream {
+			delete(b.subs, sub)
+			close(sub.ch)
+		}
+	}
+}
+
+// emit publishes an event to the webhooks and /events clients.
+func (s *Server) emit(data EventData) {
+	ev := s.events.publish(data)
+	s.metrics.events.WithLabelValues(ev.Type).Inc()
+}
+
+// checkDesync compares the checksum of a FRAME with those the other players
+// reported for the same seq. The room must be locked.
+func (s *Server) checkDesync(r *room.Room, player *room.Player, frame *protocol.Frame) {
+	if r.Checksums == nil {
+		return
+	}
+	sums := r.Checksums.Add(player.ID, frame.Seq, frame.Checksum, r.Seats)
+	if sums == nil {
+		return
+	}
+	s.log.Warn("Match desynced", "room_id", r.ID, "seq", frame.Seq, "checksums", sums)
+	s.emit(Desync{RoomID: r.ID, Seq: frame.Seq, Checksums: sums})
+}
+
+// CloseEventStreams ends the responses of all /events clients. Call it when
+// the HTTP server shuts down, which otherwise waits for them.
+func (s *Server) CloseEventStreams() {
+	s.events.closeStreams()
+}
+
+// EventsHandler serves the events as a server-sent event stream while the
+// events feature is enabled, authenticated like the admin API. The types
+// query parameter takes a comma separated list of the event types wanted,
+// and a client reconnecting with Last-Event-ID first gets the recent events
+// it missed.
+func (s *Server) EventsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.config().Features.Events {
+			http.NotFound(w, r)
+			return
+		}
+		if s.adminRole(adminCredential(r)) == RoleNone {
+			s.log.Warn("Rejected unauthorized events request", "remote_addr", r.RemoteAddr)
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if !allowMethod(w, r, http.MethodGet) {
+			return
+		}
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+			return
+		}
+		var types []string
+		if v := r.URL.Query().Get("types"); v != "" {
+			types = strings.Split(v, ",")
+		}
+		if err := checkEventTypes(types); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		lastID, _ := strconv.ParseInt(r.Header.Get("Last-Event-ID"), 10, 64)
+
+		sub, backlog := s.events.subscribe(streamBuffer, eventFilter(types), true, lastID)
+		defer s.events.unsubscribe(sub)
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+		for _, ev := range backlog {
+			writeEvent(w, ev)
+		}
+		flusher.Flush()
+
+		keepalive := time.NewTicker(streamKeepalive)
+		defer keepalive.Stop()
+		for {
+			select {
+			case ev, ok := <-sub.ch:
+				if !ok {
+					return
+				}
+				if err := writeEvent(w, ev); err != nil {
+					return
+				}
+			case <-keepalive.C:
+				if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+					return
+				}
+			case <-r.Context().Done():
+				return
+			}
+			flusher.Flush()
+		}
+	})
+}
+
+func writeEvent(w http.ResponseWriter, ev Event) error {
+	data, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", ev.ID, ev.Type, data)
+	return err
+}
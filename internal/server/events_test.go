@@ -0,0 +1,144 @@
+package server
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestEventBus(t *testing.T) {
+	drops := 0
+	b := newEventBus(func() { drops++ })
+	all, _ := b.subscribe(8, eventFilter(nil), false, 0)
+	joins, _ := b.subscribe(1, eventFilter([]string{EventPlayerJoined}), true, 0)
+
+	b.publish(PlayerConnected{PlayerID: 1})
+	b.publish(PlayerJoined{PlayerID: 1, RoomID: 2})
+	b.publish(PlayerJoined{PlayerID: 3, RoomID: 2})
+
+	for want := int64(1); want <= 3; want++ {
+		if ev := <-all.ch; ev.ID != want {
+			t.Errorf("event id = %d, want %d", ev.ID, want)
+		}
+	}
+	if ev := <-joins.ch; ev.Type != EventPlayerJoined || ev.ID != 2 {
+		t.Errorf("filtered event = %+v, want player_joined 2", ev)
+	}
+	// The second player_joined did not fit in the buffer of joins.
+	if drops != 1 {
+		t.Errorf("drops = %d, want 1", drops)
+	}
+
+	_, backlog := b.subscribe(8, eventFilter([]string{EventPlayerJoined}), true, 1)
+	if len(backlog) != 2 || backlog[0].ID != 2 || backlog[1].ID != 3 {
+		t.Errorf("backlog after 1 = %+v, want player_joined 2 and 3", backlog)
+	}
+
+	b.closeStreams()
+	if _, ok := <-joins.ch; ok {
+		t.Error("stream subscription still open after closeStreams")
+	}
+	b.publish(PlayerConnected{PlayerID: 4})
+	if ev := <-all.ch; ev.ID != 4 {
+		t.Errorf("event after closeStreams = %+v, want id 4", ev)
+	}
+	b.unsubscribe(all)
+	b.unsubscribe(all)
+	if _, ok := <-all.ch; ok {
+		t.Error("subscription still open after unsubscribe")
+	}
+}
+
+func TestEventsHandlerRejects(t *testing.T) {
+	tests := []struct {
+		name    string
+		enabled bool
+		token   string
+		query   string
+		want    int
+	}{
+		{"disabled", false, "mod", "", http.StatusNotFound},
+		{"no token", true, "", "", http.StatusUnauthorized},
+		{"wrong token", true, "nope", "", http.StatusUnauthorized},
+		{"unknown type", true, "mod", "?types=player_joined,bogus", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := DefaultConfig()
+			cfg.AdminToken = "mod"
+			cfg.Features.Events = tt.enabled
+			s, _ := testServer(t, cfg)
+			r := httptest.NewRequest(http.MethodGet, "/events"+tt.query, nil)
+			if tt.token != "" {
+				r.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			w := httptest.NewRecorder()
+			s.EventsHandler().ServeHTTP(w, r)
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
+
+// TestEventsStream follows /events while a player connects and joins a
+// room, then reconnects with Last-Event-ID to get what it missed.
+func TestEventsStream(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.ViewerToken = "view"
+	cfg.Features.Events = true
+	s, url := testServer(t, cfg)
+	events := httptest.NewServer(s.EventsHandler())
+	t.Cleanup(events.Close)
+	t.Cleanup(s.CloseEventStreams)
+
+	open := func(lastID string) *bufio.Reader {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodGet, events.URL+"?types=player_joined", nil)
+		req.Header.Set("X-Admin-Token", "view")
+		if lastID != "" {
+			req.Header.Set("Last-Event-ID", lastID)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { resp.Body.Close() })
+		if ct := resp.Header.Get("Content-Type"); resp.StatusCode != http.StatusOK || ct != "text/event-stream" {
+			t.Fatalf("status %d, content type %s", resp.StatusCode, ct)
+		}
+		return bufio.NewReader(resp.Body)
+	}
+	// next reads the id and event lines of the next event.
+	next := func(r *bufio.Reader) string {
+		t.Helper()
+		var lines []string
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				t.Fatalf("reading the stream: %v", err)
+			}
+			line = strings.TrimSuffix(line, "\n")
+			if line == "" {
+				return strings.Join(lines, " ")
+			}
+			if !strings.HasPrefix(line, "data: ") {
+				lines = append(lines, line)
+			}
+		}
+	}
+
+	stream := open("")
+	joinServer(t, url, 1, "a")
+	// player_connected is 1 and filtered out.
+	if got, want := next(stream), "id: 2 event: player_joined"; got != want {
+		t.Fatalf("event = %q, want %q", got, want)
+	}
+
+	joinServer(t, url, 1, "b")
+	if got, want := next(open("2")), "id: 4 event: player_joined"; got != want {
+		t.Errorf("event after Last-Event-ID = %q, want %q", got, want)
+	}
+}
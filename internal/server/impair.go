@@ -0,0 +1,176 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/zjx20/littlefighterhub/internal/impair"
+	"github.com/zjx20/littlefighterhub/internal/room"
+)
+
+// errImpairmentDisabled is returned while the impairment feature is off.
+var errImpairmentDisabled = errors.New("the impairment feature is disabled")
+
+// impairments are the network impairments set through the admin API. A
+// player's own settings take precedence over those of the room they are in.
+// Impaired players get a Link that holds back the messages in both
+// directions, so a delay of 100ms on two players adds 400ms to the round
+// trip between them.
+type impairments struct {
+	mu      sync.Mutex
+	players map[int]impair.Settings
+	rooms   map[int]impair.Settings
+	// conns are the connected players; links are only made for them.
+	conns map[int]*room.Player
+}
+
+func newImpairments() *impairments {
+	return &impairments{
+		players: make(map[int]impair.Settings),
+		rooms:   make(map[int]impair.Settings),
+		conns:   make(map[int]*room.Player),
+	}
+}
+
+// Impairments lists the impairments set per player and per room.
+type Impairments struct {
+	Players map[int]impair.Settings `json:"players"`
+	Rooms   map[int]impair.Settings `json:"rooms"`
+}
+
+// Impairments returns the impairments currently set.
+func (s *Server) Impairments() Impairments {
+	im := s.impairments
+	im.mu.Lock()
+	defer im.mu.Unlock()
+	out := Impairments{Players: make(map[int]impair.Settings), Rooms: make(map[int]impair.Settings)}
+	for id, set := range im.players {
+		out.Players[id] = set
+	}
+	for id, set := range im.rooms {
+		out.Rooms[id] = set
+	}
+	return out
+}
+
+// SetPlayerImpairment impairs the connection of a player, wherever they are.
+// Zero settings remove the player's own impairment; the room's then applies.
+func (s *Server) SetPlayerImpairment(playerID int, set impair.Settings) error {
+	if err := s.checkImpairment(set); err != nil {
+		return err
+	}
+	im := s.impairments
+	im.mu.Lock()
+	p, ok := im.conns[playerID]
+	if !ok {
+		im.mu.Unlock()
+		return fmt.Errorf("player %d is not connected", playerID)
+	}
+	if set.IsZero() {
+		delete(im.players, playerID)
+	} else {
+		im.players[playerID] = set
+	}
+	im.mu.Unlock()
+	s.log.Info("Player impairment changed", "player_id", playerID, "impairment", set)
+	s.applyImpairment(p)
+	return nil
+}
+
+// SetRoomImpairment impairs the connections of everyone in a room, including
+// players joining later. Zero settings remove it.
+func (s *Server) SetRoomImpairment(roomID int, set impair.Settings) error {
+	if err := s.checkImpairment(set); err != nil {
+		return err
+	}
+	r, ok := s.Rooms[roomID]
+	if !ok {
+		return fmt.Errorf("room %d does not exist", roomID)
+	}
+	im := s.impairments
+	im.mu.Lock()
+	if set.IsZero() {
+		delete(im.rooms, roomID)
+	} else {
+		im.rooms[roomID] = set
+	}
+	im.mu.Unlock()
+	s.log.Info("Room impairment changed", "room_id", roomID, "impairment", set)
+
+	r.Mu.Lock()
+	players := r.SeatedPlayers()
+	r.Mu.Unlock()
+	for _, p := range players {
+		s.applyImpairment(p)
+	}
+	return nil
+}
+
+func (s *Server) checkImpairment(set impair.Settings) error {
+	if !s.config().Features.Impairment {
+		return errImpairmentDisabled
+	}
+	return set.Validate()
+}
+
+// applyImpairment brings the link of a player in line with the settings
+// that apply to them now. It is called when they change and whenever the
+// player enters or leaves a room.
+func (s *Server) applyImpairment(p *room.Player) {
+	im := s.impairments
+	im.mu.Lock()
+	defer im.mu.Unlock()
+	if _, ok := im.conns[p.ID]; !ok {
+		return
+	}
+	set, ok := im.players[p.ID]
+	if !ok {
+		set = im.rooms[p.RoomID()]
+	}
+	if link := p.Link.Load(); link != nil {
+		link.SetSettings(set)
+		return
+	}
+	if set.IsZero() {
+		return
+	}
+	p.Link.Store(&impair.Link{
+		In:  impair.NewQueue(set, func(msg []byte) { s.handleMessage(p, msg) }),
+		Out: impair.NewQueue(set, func(msg []byte) { s.write(p, msg) }),
+	})
+}
+
+// impairmentOf returns the settings of a player's link, or nil.
+func impairmentOf(p *room.Player) *impair.Settings {
+	link := p.Link.Load()
+	if link == nil {
+		return nil
+	}
+	set := link.Out.Settings()
+	if set.IsZero() {
+		return nil
+	}
+	return &set
+}
+
+// trackImpairable registers a new connection. The returned function drops
+// the messages still held back for the player once they disconnect.
+func (s *Server) trackImpairable(p *room.Player) (untrack func()) {
+	im := s.impairments
+	im.mu.Lock()
+	im.conns[p.ID] = p
+	im.mu.Unlock()
+	return func() {
+		im.mu.Lock()
+		delete(im.conns, p.ID)
+		delete(im.players, p.ID)
+		link := p.Link.Load()
+		im.mu.Unlock()
+		// Closing waits for a message being handled, which may need
+		// im.mu itself.
+		if link != nil {
+			link.Close()
+		}
+	}
+}
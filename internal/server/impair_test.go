@@ -0,0 +1,100 @@
+package server
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/zjx20/littlefighterhub/internal/impair"
+	"github.com/zjx20/littlefighterhub/pkg/protocol"
+)
+
+func TestImpairmentDisabled(t *testing.T) {
+	s, url := testServer(t, DefaultConfig())
+	_, id := dialServer(t, url, nil)
+	if err := s.SetPlayerImpairment(id, impair.Settings{DelayMS: 10}); !errors.Is(err, errImpairmentDisabled) {
+		t.Errorf("SetPlayerImpairment = %v, want %v", err, errImpairmentDisabled)
+	}
+	if err := s.SetRoomImpairment(1, impair.Settings{DelayMS: 10}); !errors.Is(err, errImpairmentDisabled) {
+		t.Errorf("SetRoomImpairment = %v, want %v", err, errImpairmentDisabled)
+	}
+}
+
+func TestImpairmentErrors(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Features.Impairment = true
+	s, url := testServer(t, cfg)
+	_, id := dialServer(t, url, nil)
+	if err := s.SetPlayerImpairment(id+1, impair.Settings{DelayMS: 10}); err == nil {
+		t.Error("SetPlayerImpairment of a player that is not connected succeeded")
+	}
+	if err := s.SetPlayerImpairment(id, impair.Settings{DelayMS: -1}); err == nil {
+		t.Error("SetPlayerImpairment with a negative delay succeeded")
+	}
+	if err := s.SetRoomImpairment(cfg.Rooms+1, impair.Settings{DelayMS: 10}); err == nil {
+		t.Error("SetRoomImpairment of a room that does not exist succeeded")
+	}
+}
+
+// TestImpairmentPrecedence checks that a player's own impairment wins over
+// the room's, and that the room's applies again once it is removed.
+func TestImpairmentPrecedence(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Features.Impairment = true
+	s, url := testServer(t, cfg)
+	_, id := joinServer(t, url, 1, "a")
+	p := clientByID(t, s, id)
+
+	roomSet := impair.Settings{DelayMS: 1}
+	playerSet := impair.Settings{DelayMS: 2}
+	steps := []struct {
+		name string
+		set  func() error
+		want *impair.Settings
+	}{
+		{"room", func() error { return s.SetRoomImpairment(1, roomSet) }, &roomSet},
+		{"player", func() error { return s.SetPlayerImpairment(id, playerSet) }, &playerSet},
+		{"player removed", func() error { return s.SetPlayerImpairment(id, impair.Settings{}) }, &roomSet},
+		{"room removed", func() error { return s.SetRoomImpairment(1, impair.Settings{}) }, nil},
+	}
+	for _, step := range steps {
+		if err := step.set(); err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+		got := impairmentOf(p)
+		if (got == nil) != (step.want == nil) || got != nil && *got != *step.want {
+			t.Fatalf("%s: impairment = %v, want %v", step.name, got, step.want)
+		}
+	}
+	if im := s.Impairments(); len(im.Players) != 0 || len(im.Rooms) != 0 {
+		t.Errorf("Impairments = %+v, want none", im)
+	}
+}
+
+// TestImpairmentDelaysRelay checks that a room impairment holds back the
+// messages between its players, and applies to players joining later.
+func TestImpairmentDelaysRelay(t *testing.T) {
+	const delay = 100 * time.Millisecond
+	cfg := DefaultConfig()
+	cfg.Features.Impairment = true
+	s, url := testServer(t, cfg)
+	if err := s.SetRoomImpairment(1, impair.Settings{DelayMS: int(delay / time.Millisecond)}); err != nil {
+		t.Fatal(err)
+	}
+	a, aID := joinServer(t, url, 1, "a")
+	b, bID := joinServer(t, url, 1, "b")
+	readUntil(t, a, protocol.CmdPlayerList)
+	for _, id := range []int{aID, bID} {
+		if impairmentOf(clientByID(t, s, id)) == nil {
+			t.Fatalf("player %d is not impaired", id)
+		}
+	}
+
+	sent := time.Now()
+	writeServer(t, a, frameWire(aID, 1))
+	readUntil(t, b, protocol.CmdFrame)
+	// Held back on the way in and on the way out.
+	if got := time.Since(sent); got < 2*delay {
+		t.Errorf("FRAME relayed after %v, want at least %v", got, 2*delay)
+	}
+}
@@ -0,0 +1,91 @@
+package server
+
+import (
+	"time"
+
+	"github.com/zjx20/littlefighterhub/internal/room"
+	"github.com/zjx20/littlefighterhub/pkg/protocol"
+)
+
+// In a lockstep match the server holds each player's FRAMEs back until all
+// players have sent the same seq, then relays the frames of the seq back to
+// back in seat order. Every client thus receives the inputs in the same
+// order, and jitter in their arrival is absorbed by the server instead of
+// each client. The synchronization at the start of a match is not needed
+// then: seq 0 is only released once everyone has sent it.
+//
+// A player who holds up a seq for longer than lockstepStallTimeout while the
+// others keep sending is dropped from the match and disconnected, so that
+// the others can go on and the frames held back do not pile up.
+
+// lockstepStallTimeout is how long a seq may wait for its last players.
+const lockstepStallTimeout = 10 * time.Second
+
+// startLockstep is called when a match starts in r, which must be locked,
+// and relays it in lockstep if enabled. enabled is the Lockstep feature,
+// read before r was locked.
+func startLockstep(r *room.Room, enabled bool) {
+	r.Lockstep = nil
+	if !enabled {
+		return
+	}
+	r.Lockstep = room.NewLockstep(r.Seats)
+	r.IsSynchronizing = false
+	r.SyncFrameBuffer = nil
+}
+
+// relayLockstep takes a FRAME of a lockstep match and relays the seqs it
+// completes. The room must be locked.
+func (s *Server) relayLockstep(r *room.Room, player *room.Player, frame *protocol.Frame, msg []byte) {
+	now := time.Now()
+	switch r.Lockstep.Add(player.ID, frame.Seq, msg, now) {
+	case room.LockstepLate:
+		s.metrics.lockstepLate.Inc()
+		s.metrics.framesRelayed.Inc()
+		s.broadcastFrame(r, player.ID, msg)
+	case room.LockstepAhead:
+		s.dropViolation(player, protocol.CmdFrame, violationFrameSeq)
+	}
+	s.releaseLockstep(r)
+
+	stalled := r.Lockstep.Stalled(now, lockstepStallTimeout)
+	for _, id := range stalled {
+		r.Lockstep.Remove(id)
+		p := r.Players[id]
+		if p == nil {
+			continue
+		}
+		s.log.Warn("Disconnecting stalled lockstep player", "player_id", id, "room_id", r.ID,
+			"timeout", lockstepStallTimeout)
+		s.emit(PlayerKicked{PlayerID: id, Name: p.Name, RoomID: r.ID, Reason: "stalled"})
+		// The close frame may take a while to a stalled connection; the
+		// room stays locked meanwhile otherwise.
+		go disconnect(p.Conn, "stalled")
+	}
+	if len(stalled) > 0 {
+		s.releaseLockstep(r)
+	}
+}
+
+// leaveLockstep stops waiting for a player who left r, which must be locked,
+// and relays the seqs only they were holding up.
+func (s *Server) leaveLockstep(r *room.Room, playerID int) {
+	if r.Lockstep == nil {
+		return
+	}
+	r.Lockstep.Remove(playerID)
+	s.releaseLockstep(r)
+	if r.State == "VACANT" {
+		r.Lockstep = nil
+	}
+}
+
+func (s *Server) releaseLockstep(r *room.Room) {
+	for _, round := range r.Lockstep.Ready(time.Now()) {
+		s.metrics.lockstepWait.Observe(round.Wait.Seconds())
+		for _, f := range round.Frames {
+			s.metrics.framesRelayed.Inc()
+			s.broadcastFrame(r, f.PlayerID, f.Msg)
+		}
+	}
+}
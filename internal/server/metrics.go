@@ -0,0 +1,176 @@
+package server
+
+import (
+	"encoding/binary"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/zjx20/littlefighterhub/internal/metrics"
+	"github.com/zjx20/littlefighterhub/internal/room"
+)
+
+const (
+	// How often each player is pinged to measure the round trip time.
+	rttPingPeriod = 5 * time.Second
+	// Time allowed to write a ping control frame.
+	pingWriteWait = time.Second
+)
+
+var (
+	rttBuckets   = []float64{.005, .01, .02, .04, .06, .08, .1, .15, .2, .3, .5, 1, 2}
+	matchBuckets = []float64{30, 60, 120, 300, 600, 900, 1800, 3600}
+	waitBuckets  = []float64{.001, .0025, .005, .01, .02, .04, .06, .1, .2, .5, 1}
+)
+
+// serverMetrics are the metrics published by a Server.
+type serverMetrics struct {
+	clients       *metrics.Gauge
+	framesRelayed *metrics.Counter
+	bytesIn       *metrics.Counter
+	bytesOut      *metrics.Counter
+	writeErrors   *metrics.Counter
+	dropped       *metrics.CounterVec
+	rtt           *metrics.HistogramVec
+	matchDuration *metrics.Histogram
+	lockstepWait  *metrics.Histogram
+	lockstepLate  *metrics.Counter
+
+	events            *metrics.CounterVec
+	eventsDropped     *metrics.Counter
+	webhookDeliveries *metrics.CounterVec
+}
+
+func newServerMetrics(reg *metrics.Registry, s *Server) *serverMetrics {
+	m := &serverMetrics{
+		clients:       reg.NewGauge("lf2hub_connected_clients", "Number of connected WebSocket clients."),
+		framesRelayed: reg.NewCounter("lf2hub_frames_relayed_total", "FRAME messages relayed to the other players of a room."),
+		bytesIn:       reg.NewCounter("lf2hub_received_bytes_total", "Bytes of WebSocket messages received from clients."),
+		bytesOut:      reg.NewCounter("lf2hub_sent_bytes_total", "Bytes of WebSocket messages sent to clients."),
+		writeErrors:   reg.NewCounter("lf2hub_write_errors_total", "Failed writes to clients."),
+		dropped:       reg.NewCounterVec("lf2hub_dropped_messages_total", "Relayed messages dropped for failing validation.", "command", "reason"),
+		rtt:           reg.NewHistogramVec("lf2hub_player_rtt_seconds", "WebSocket ping round trip time per player.", rttBuckets, "player_id"),
+		matchDuration: reg.NewHistogram("lf2hub_match_duration_seconds", "Time from START until the room is vacant again.", matchBuckets),
+		lockstepWait:  reg.NewHistogram("lf2hub_lockstep_wait_seconds", "Time each FRAME seq of a lockstep match waited for its last player.", waitBuckets),
+		lockstepLate:  reg.NewCounter("lf2hub_lockstep_late_frames_total", "FRAMEs of lockstep matches relayed on their own, after their seq was released."),
+
+		events:            reg.NewCounterVec("lf2hub_events_total", "Events published to webhooks and /events clients.", "type"),
+		eventsDropped:     reg.NewCounter("lf2hub_events_dropped_total", "Events not delivered to a webhook or /events client that fell behind."),
+		webhookDeliveries: reg.NewCounterVec("lf2hub_webhook_deliveries_total", "Webhook delivery attempts by result: ok, retry or failed.", "result"),
+	}
+	reg.NewGaugeVecFunc("lf2hub_rooms", "Number of rooms by state.", "state", s.roomsByState)
+	reg.NewGaugeFunc("lf2hub_bots", "Number of bot players.", s.bots.count)
+	return m
+}
+
+// Metrics returns the registry the server publishes its metrics to.
+func (s *Server) Metrics() *metrics.Registry {
+	return s.registry
+}
+
+// MetricsHandler serves the metrics in the Prometheus text format while the
+// metrics feature is enabled.
+func (s *Server) MetricsHandler() http.Handler {
+	h := s.registry.Handler()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.config().Features.Metrics {
+			http.NotFound(w, r)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) roomsByState() map[string]float64 {
+	counts := map[string]float64{"VACANT": 0, "LOBBY": 0, "STARTED": 0}
+	for _, r := range s.Rooms {
+		r.Mu.Lock()
+		counts[r.State]++
+		r.Mu.Unlock()
+	}
+	return counts
+}
+
+// send writes a text message to a player. All writes of data messages must go
+// through send, which serializes them and keeps the traffic metrics. While
+// the player is impaired the message is queued on their link instead, and a
+// failed write is only counted.
+func (s *Server) send(p *room.Player, msg []byte) error {
+	if link := p.Link.Load(); link != nil {
+		link.Out.Push(msg)
+		return nil
+	}
+	return s.write(p, msg)
+}
+
+// sendPrepared is send for a message framed once for all recipients of a
+// broadcast; msg is its payload.
+func (s *Server) sendPrepared(p *room.Player, pm *websocket.PreparedMessage, msg []byte) error {
+	if link := p.Link.Load(); link != nil {
+		link.Out.Push(msg)
+		return nil
+	}
+	p.WriteMu.Lock()
+	err := p.Conn.WritePreparedMessage(pm)
+	p.WriteMu.Unlock()
+	return s.wrote(p, msg, err)
+}
+
+// write sends msg to the player right away.
+func (s *Server) write(p *room.Player, msg []byte) error {
+	p.WriteMu.Lock()
+	err := p.Conn.WriteMessage(websocket.TextMessage, msg)
+	p.WriteMu.Unlock()
+	return s.wrote(p, msg, err)
+}
+
+// wrote accounts for a write of msg that ended with err.
+func (s *Server) wrote(p *room.Player, msg []byte, err error) error {
+	if err != nil {
+		s.metrics.writeErrors.Inc()
+		return err
+	}
+	s.metrics.bytesOut.Add(float64(len(msg)))
+	s.transcripts.record(p.RoomID(), "out", p.ID, msg)
+	return nil
+}
+
+// measureRTT pings the player periodically and records the round trip time
+// of each pong. It must be called before the read loop starts, since pong
+// handlers run there. The returned function stops the pinging.
+func (s *Server) measureRTT(player *room.Player) (stop func()) {
+	id := strconv.Itoa(player.ID)
+	hist := s.metrics.rtt.WithLabelValues(id)
+	player.Conn.SetPongHandler(func(data string) error {
+		if len(data) != 8 {
+			return nil
+		}
+		sent := time.Unix(0, int64(binary.BigEndian.Uint64([]byte(data))))
+		hist.Observe(time.Since(sent).Seconds())
+		return nil
+	})
+
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(rttPingPeriod)
+		defer ticker.Stop()
+		payload := make([]byte, 8)
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+			binary.BigEndian.PutUint64(payload, uint64(time.Now().UnixNano()))
+			if err := player.Conn.WriteControl(websocket.PingMessage, payload, time.Now().Add(pingWriteWait)); err != nil {
+				return
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		s.metrics.rtt.Delete(id)
+	}
+}
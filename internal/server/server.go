@@ -1,18 +1,20 @@
 package server
 
 import (
-	"bytes"
-	"fmt"
-	"log"
+	"errors"
+	"log/slog"
+	"net"
 	"net/http"
-	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
 
+	"github.com/zjx20/littlefighterhub/internal/metrics"
 	"github.com/zjx20/littlefighterhub/internal/room"
+	"github.com/zjx20/littlefighterhub/pkg/protocol"
 )
 
 type Server struct {
@@ -21,23 +23,83 @@ type Server struct {
 	nextUserID int
 	mu         sync.Mutex
 	upgrader   websocket.Upgrader
+	// cfg is replaced as a whole by Reload, so that config takes no lock:
+	// handlers read it with a room locked, while s.mu is taken before room
+	// locks.
+	cfg atomic.Pointer[Config]
+
+	// adminTokens maps each configured admin secret to the role it grants.
+	adminTokens map[string]AdminRole
+	// bans come from the configuration, runtimeBans from Ban.
+	bans        []*net.IPNet
+	runtimeBans []*net.IPNet
+
+	// draining is set once Shutdown begins; new connections and JOINs are
+	// refused from then on.
+	draining bool
+	// handlers tracks the running HandleConnections calls.
+	handlers sync.WaitGroup
+
+	registry    *metrics.Registry
+	metrics     *serverMetrics
+	log         *slog.Logger
+	transcripts *transcripts
+	bots        bots
+	impairments *impairments
+	events      *eventBus
+	webhooks    *webhooks
 }
 
+// NewServer creates a server with the default configuration.
 func NewServer() *Server {
+	s, err := NewServerWithConfig(DefaultConfig())
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+// NewServerWithConfig creates a server with the given configuration.
+func NewServerWithConfig(cfg Config) (*Server, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	bans, err := parseBans(cfg.Bans)
+	if err != nil {
+		return nil, err
+	}
 	s := &Server{
-		Rooms:      make(map[int]*room.Room),
-		Clients:    make(map[*websocket.Conn]*room.Player),
-		nextUserID: 1,
+		Rooms:       make(map[int]*room.Room),
+		Clients:     make(map[*websocket.Conn]*room.Player),
+		nextUserID:  1,
+		adminTokens: cfg.adminTokens(),
+		bans:        bans,
 		upgrader: websocket.Upgrader{
 			CheckOrigin: func(r *http.Request) bool {
 				return true // Allow all connections
 			},
 		},
 	}
-	for i := 1; i <= 8; i++ {
+	for i := 1; i <= cfg.Rooms; i++ {
 		s.Rooms[i] = room.NewRoom(i)
-	}
-	return s
+		s.Rooms[i].Latency = cfg.DefaultLatency
+	}
+	s.log = cfg.Logger
+	if s.log == nil {
+		s.log = slog.Default()
+	}
+	s.impairments = newImpairments()
+	s.cfg.Store(&cfg)
+	s.transcripts = newTranscripts(cfg.RoomLogDir, cfg.Features.RoomLogs, s.log)
+	s.registry = cfg.Registry
+	if s.registry == nil {
+		s.registry = metrics.NewRegistry()
+	}
+	s.metrics = newServerMetrics(s.registry, s)
+	s.events = newEventBus(s.metrics.eventsDropped.Inc)
+	s.webhooks = newWebhooks(s)
+	s.webhooks.configure(cfg.Webhooks)
+	return s, nil
 }
 
 func (s *Server) NextUserID() int {
@@ -49,37 +111,66 @@ func (s *Server) NextUserID() int {
 }
 
 func (s *Server) HandleConnections(w http.ResponseWriter, r *http.Request) {
-	log.Printf("Handle new connection from %s, requested host: %s\n", r.RemoteAddr, r.Host)
-	ws, err := s.upgrader.Upgrade(w, r, nil)
+	s.log.Info("New connection", "remote_addr", r.RemoteAddr, "host", r.Host)
+	if s.isDraining() {
+		s.log.Info("Rejecting connection, server is shutting down", "remote_addr", r.RemoteAddr)
+		http.Error(w, "Server is shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	if s.isBanned(r.RemoteAddr) {
+		s.log.Info("Rejecting banned client", "remote_addr", r.RemoteAddr)
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	s.handlers.Add(1)
+	defer s.handlers.Done()
+
+	upgrader := s.upgrader
+	upgrader.EnableCompression = s.config().Features.Compression
+	ws, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
-		log.Println("Upgrade error:", err)
+		s.log.Warn("Upgrade failed", "remote_addr", r.RemoteAddr, "err", err)
 		return
 	}
 	defer ws.Close()
 
 	player := &room.Player{
-		ID:   s.NextUserID(),
-		Conn: ws,
-		IP:   ws.RemoteAddr(),
+		ID:         s.NextUserID(),
+		Conn:       ws,
+		IP:         ws.RemoteAddr(),
+		Credential: adminCredential(r),
 	}
 	s.addClient(player)
 	defer s.removeClient(player)
+	stopRTT := s.measureRTT(player)
+	defer stopRTT()
+	untrack := s.trackImpairable(player)
+	defer untrack()
 
-	log.Printf("Client connected: ID %d, IP %s\n", player.ID, player.IP)
+	s.log.Info("Client connected", "player_id", player.ID, "ip", player.IP.String())
+	s.emit(PlayerConnected{PlayerID: player.ID})
 
 	// Send YOUR_ID message
-	yourIDMsg := []byte(fmt.Sprintf("YOUR_ID\n%d\n200\n-999\n-999\n-999", player.ID))
-	if err := ws.WriteMessage(websocket.TextMessage, yourIDMsg); err != nil {
-		log.Println("write:", err)
+	yourID := &protocol.YourID{PlayerID: player.ID, Params: protocol.DefaultYourIDParams}
+	if err := s.sendMessage(player, yourID); err != nil {
+		s.log.Warn("Failed to send YOUR_ID", "player_id", player.ID, "err", err)
 		return
 	}
 
 	for {
 		_, msg, err := ws.ReadMessage()
 		if err != nil {
-			log.Printf("Client %d disconnected: %v\n", player.ID, err)
+			s.log.Info("Client disconnected", "player_id", player.ID, "err", err)
 			break
 		}
+		s.metrics.bytesIn.Add(float64(len(msg)))
+		s.transcripts.record(player.RoomID(), "in", player.ID, msg)
+		// ADMIN takes over reading the connection, which only this
+		// goroutine may do, so it never goes through the impairment link.
+		if link := player.Link.Load(); link != nil && protocol.Command(msg) != protocol.CmdAdmin {
+			link.In.Push(msg)
+			continue
+		}
 		s.handleMessage(player, msg)
 	}
 }
@@ -88,178 +179,239 @@ func (s *Server) addClient(player *room.Player) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	s.Clients[player.Conn] = player
+	s.metrics.clients.Inc()
 }
 
 func (s *Server) removeClient(player *room.Player) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	var playerRoom *room.Room
-	for _, r := range s.Rooms {
-		if _, ok := r.Players[player.ID]; ok {
-			playerRoom = r
-			break
-		}
-	}
-
-	if playerRoom != nil {
-		playerRoom.Mu.Lock()
-		playerRoom.RemovePlayer(player.ID)
-		log.Printf("Player %d removed from room %d", player.ID, playerRoom.ID)
+	// s.mu is not held while the room is locked, so that the two locks are
+	// never taken in opposite orders.
+	if playerRoom := s.lockPlayerRoom(player); playerRoom != nil {
+		s.removeFromRoom(playerRoom, player, true)
+		s.log.Info("Player removed from room", "player_id", player.ID, "room_id", playerRoom.ID)
 
 		// Broadcast "left the Room" message
-		chatMsg := []byte(fmt.Sprintf("CHAT\n%d\n%s\nleft the Room.", player.ID, player.Name))
-		for _, p := range playerRoom.Players {
-			if err := p.Conn.WriteMessage(websocket.TextMessage, chatMsg); err != nil {
-				log.Printf("Error broadcasting to player %d: %v", p.ID, err)
-			}
-		}
+		s.broadcast(playerRoom, &protocol.Chat{PlayerID: player.ID, Name: player.Name, Text: "left the Room."})
 		s.broadcastPlayerList(playerRoom)
 		playerRoom.Mu.Unlock()
 	}
 
+	s.mu.Lock()
 	delete(s.Clients, player.Conn)
-	log.Printf("Client %d removed.\n", player.ID)
+	s.mu.Unlock()
+	s.metrics.clients.Dec()
+	s.log.Info("Client removed", "player_id", player.ID)
 }
 
 func (s *Server) handleMessage(player *room.Player, msg []byte) {
 	parts := strings.Split(string(msg), "\n")
 	command := parts[0]
 
-	if command != "FRAME" {
-		log.Printf("Received from %d: %s\n", player.ID, string(msg))
+	s.logReceived(player, command, parts)
+
+	m, err := protocol.ParseClient(msg)
+	if err != nil {
+		s.handleMalformed(player, command, msg, err)
+		return
 	}
 
-	switch command {
-	case "LIST":
+	switch m := m.(type) {
+	case *protocol.ListRequest:
 		s.handleList(player)
-	case "JOIN":
-		s.handleJoin(player, parts)
-	case "LEAVE":
-		s.handleLeave(player, parts)
-	case "START":
+	case *protocol.Join:
+		s.handleJoin(player, m, msg)
+	case *protocol.Leave:
+		s.handleLeave(player, m)
+	case *protocol.Start:
 		s.handleStart(player)
-	case "CHAT":
-		s.handleChat(player, parts)
-	case "FRAME":
-		s.handleFrame(player, msg)
-	case "ADMIN":
-		s.handleAdmin(player)
-	case "CHANGE_LATENCY":
-		s.handleChangeLatency(player, parts)
-	case "AWAY":
-		s.handleAway(player, msg)
-	case "UPDATE_CONTROL_NAMES":
-		s.handleUpdateControlNames(player, msg)
+	case *protocol.ChatRequest:
+		s.handleChat(player, m)
+	case *protocol.Frame:
+		s.handleFrame(player, m, msg)
+	case *protocol.Admin:
+		s.handleAdmin(player, m)
+	case *protocol.ChangeLatency:
+		s.handleChangeLatency(player, m)
+	case *protocol.Away:
+		s.handleAway(player, m, msg)
+	case *protocol.UpdateControlNames:
+		s.handleUpdateControlNames(player, m, msg)
+	case *protocol.UpdateAchievements:
+		s.handleUpdateAchievements(player, m)
+	default:
+		s.log.Warn("Unexpected command", "player_id", player.ID, "command", command)
+	}
+}
+
+// handleMalformed deals with a message that failed to parse. With strict
+// relaying off, FRAME and AWAY are forwarded as they are, like the original
+// server does; everything else is dropped.
+func (s *Server) handleMalformed(player *room.Player, command string, msg []byte, err error) {
+	switch {
+	case errors.Is(err, protocol.ErrUnknownCommand):
+		s.log.Warn("Unknown command", "player_id", player.ID, "command", command)
+	case command == protocol.CmdFrame && !s.config().Features.StrictRelay:
+		s.handleFrame(player, nil, msg)
+	case command == protocol.CmdAway && !s.config().Features.StrictRelay:
+		s.broadcastToOthers(player, msg)
+	case isRelayed(command):
+		// Control names are stored, so they are never taken unparsed.
+		s.dropViolation(player, command, violationMalformed)
 	default:
-		log.Printf("Unknown command from player %d: %s\n", player.ID, command)
+		s.log.Warn("Invalid command", "player_id", player.ID, "command", command, "err", err)
 	}
 }
 
 func (s *Server) handleList(player *room.Player) {
-	var b bytes.Buffer
-	b.WriteString("LIST\n\n")
+	list := &protocol.List{}
 
-	for i := 1; i <= 8; i++ {
+	for i := 1; i <= len(s.Rooms); i++ {
 		r := s.Rooms[i]
 		r.Mu.Lock()
 
-		playerNames := []string{}
-		for _, p := range r.Players {
-			playerNames = append(playerNames, p.Name)
+		summary := protocol.RoomSummary{
+			ID:      r.ID,
+			State:   r.State,
+			Latency: r.Latency,
+			Elapsed: time.Since(r.Time).Milliseconds(),
+			Players: len(r.Players),
 		}
-
-		b.WriteString("¶\n")
-		b.WriteString(fmt.Sprintf("Room\n%d\n%s\n%d\n%d\n%d\n%s\n",
-			r.ID,
-			r.State,
-			r.Latency,
-			time.Since(r.Time).Milliseconds(),
-			len(r.Players),
-			strings.Join(playerNames, ", "),
-		))
+		for _, p := range r.SeatedPlayers() {
+			summary.Names = append(summary.Names, p.Name)
+		}
+		list.Rooms = append(list.Rooms, summary)
 		r.Mu.Unlock()
 	}
 
-	if err := player.Conn.WriteMessage(websocket.TextMessage, b.Bytes()); err != nil {
-		log.Println("write:", err)
+	if err := s.sendMessage(player, list); err != nil {
+		s.log.Warn("Failed to send LIST", "player_id", player.ID, "err", err)
 	}
 }
 
-func (s *Server) handleJoin(player *room.Player, parts []string) {
-	if len(parts) < 8 {
-		log.Printf("Invalid JOIN command from player %d", player.ID)
+func (s *Server) handleJoin(player *room.Player, join *protocol.Join, msg []byte) {
+	roomID := join.RoomID
+	if roomID < 1 || roomID > len(s.Rooms) {
+		s.log.Warn("Invalid room ID", "player_id", player.ID, "room_id", roomID)
 		return
 	}
 
-	roomID, err := strconv.Atoi(parts[1])
-	if err != nil || roomID < 1 || roomID > 8 {
-		log.Printf("Invalid room ID from player %d: %s", player.ID, parts[1])
+	if s.isDraining() {
+		s.log.Info("Refusing JOIN while shutting down", "player_id", player.ID, "room_id", roomID)
 		return
 	}
 
-	log.Printf("Player %d is trying to join room %d", player.ID, roomID)
+	s.log.Debug("Player is trying to join", "player_id", player.ID, "room_id", roomID)
 	roomToJoin := s.Rooms[roomID]
 	roomToJoin.Mu.Lock()
 	defer roomToJoin.Mu.Unlock()
 
 	if roomToJoin.State == "STARTED" {
 		// TODO: Handle joining a started room
-		log.Printf("Player %d tried to join a started room %d", player.ID, roomID)
+		s.log.Info("Player tried to join a started room", "player_id", player.ID, "room_id", roomID)
 		return
 	}
 
-	if len(roomToJoin.Players) >= 8 {
+	if len(roomToJoin.Players) >= s.config().RoomCapacity {
 		// TODO: Handle full room
-		log.Printf("Room %d is full", roomID)
+		s.log.Info("Room is full", "player_id", player.ID, "room_id", roomID)
 		return
 	}
 
-	player.Name = parts[2]
-	player.P1 = parts[3]
-	player.P2 = parts[4]
-	player.P3 = parts[5]
-	player.P4 = parts[6]
-	player.Achievements = parts[7]
+	player.Name = join.Name
+	player.P1, player.P2, player.P3, player.P4 = join.Controls[0], join.Controls[1], join.Controls[2], join.Controls[3]
+	player.SetAchievements(join.Achievements)
 
 	roomToJoin.AddPlayer(player)
-	log.Printf("Player %d (%s) joined room %d", player.ID, player.Name, roomID)
+	// The JOIN arrived before the player was in the room; record it here so
+	// the transcript starts with it.
+	s.transcripts.record(roomID, "in", player.ID, msg)
+	s.log.Info("Player joined room", "player_id", player.ID, "name", player.Name, "room_id", roomID)
+	s.emit(PlayerJoined{PlayerID: player.ID, Name: player.Name, RoomID: roomID, Players: len(roomToJoin.Players)})
+	s.applyImpairment(player)
 
 	s.broadcastPlayerList(roomToJoin)
 }
 
 func (s *Server) broadcastPlayerList(r *room.Room) {
-	var b bytes.Buffer
-	b.WriteString(fmt.Sprintf("PLAYER_LIST\n%d\n%d\n", r.ID, r.Latency))
-
-	for _, p := range r.Players {
-		b.WriteString(fmt.Sprintf("¶\n%d\n%s\n%s\n%s\n%s\n%s\n%s\n",
-			p.ID,
-			p.Name,
-			p.P1,
-			p.P2,
-			p.P3,
-			p.P4,
-			p.Achievements,
-		))
-	}
-
-	for _, p := range r.Players {
-		if err := p.Conn.WriteMessage(websocket.TextMessage, b.Bytes()); err != nil {
-			log.Printf("Error broadcasting to player %d: %v", p.ID, err)
+	list := &protocol.PlayerList{RoomID: r.ID, Latency: r.Latency}
+	for _, p := range r.SeatedPlayers() {
+		list.Players = append(list.Players, protocol.PlayerEntry{
+			ID:           p.ID,
+			Name:         p.Name,
+			Controls:     [4]string{p.P1, p.P2, p.P3, p.P4},
+			Achievements: p.Achievements,
+		})
+	}
+	s.broadcast(r, list)
+}
+
+// broadcast sends m to every player of the room, which must be locked.
+func (s *Server) broadcast(r *room.Room, m protocol.Message) {
+	msg, err := protocol.Encode(m)
+	if err != nil {
+		s.log.Error("Failed to encode message", "room_id", r.ID, "err", err)
+		return
+	}
+	players := r.SeatedPlayers()
+	pm := s.prepare(msg, len(players))
+	for _, p := range players {
+		if err := s.sendTo(p, pm, msg); err != nil {
+			s.log.Warn("Failed to broadcast", "player_id", p.ID, "room_id", r.ID, "err", err)
 		}
 	}
 }
 
-func (s *Server) handleLeave(player *room.Player, parts []string) {
-	if len(parts) < 2 {
-		log.Printf("Invalid LEAVE command from player %d", player.ID)
-		return
+// prepare frames msg once for a broadcast to several recipients, so that it
+// is compressed once for all of the clients that negotiated compression.
+// It returns nil when that is not worth it. Without compression, a
+// PreparedMessage allocates about 11KB and makes a broadcast to a full room
+// slower than writing to each connection, so it is only used while the
+// compression feature is on; see BenchmarkBroadcast.
+func (s *Server) prepare(msg []byte, recipients int) *websocket.PreparedMessage {
+	if recipients < 2 || !s.config().Features.Compression {
+		return nil
+	}
+	pm, err := websocket.NewPreparedMessage(websocket.TextMessage, msg)
+	if err != nil {
+		s.log.Warn("Failed to prepare message", "err", err)
+		return nil
+	}
+	return pm
+}
+
+// sendTo sends a message returned by prepare, or msg alone if pm is nil.
+func (s *Server) sendTo(p *room.Player, pm *websocket.PreparedMessage, msg []byte) error {
+	if pm == nil {
+		return s.send(p, msg)
 	}
+	return s.sendPrepared(p, pm, msg)
+}
+
+// broadcastSystemChat sends a CHAT from the server to everyone in r, which
+// must be locked.
+func (s *Server) broadcastSystemChat(r *room.Room, text string) {
+	s.broadcast(r, &protocol.Chat{PlayerID: SystemPlayerID, Name: systemChatName, Text: text})
+}
 
-	roomID, err := strconv.Atoi(parts[1])
-	if err != nil || roomID < 1 || roomID > 8 {
-		log.Printf("Invalid room ID from player %d: %s", player.ID, parts[1])
+// replySystemChat sends a CHAT from the server to a single player.
+func (s *Server) replySystemChat(p *room.Player, text string) {
+	if err := s.sendMessage(p, &protocol.Chat{PlayerID: SystemPlayerID, Name: systemChatName, Text: text}); err != nil {
+		s.log.Warn("Failed to send CHAT", "player_id", p.ID, "err", err)
+	}
+}
+
+// sendMessage encodes m and sends it to the player.
+func (s *Server) sendMessage(p *room.Player, m protocol.Message) error {
+	msg, err := protocol.Encode(m)
+	if err != nil {
+		return err
+	}
+	return s.send(p, msg)
+}
+
+func (s *Server) handleLeave(player *room.Player, leave *protocol.Leave) {
+	roomID := leave.RoomID
+	if roomID < 1 || roomID > len(s.Rooms) {
+		s.log.Warn("Invalid room ID", "player_id", player.ID, "room_id", roomID)
 		return
 	}
 
@@ -268,21 +420,37 @@ func (s *Server) handleLeave(player *room.Player, parts []string) {
 	defer roomToLeave.Mu.Unlock()
 
 	if _, ok := roomToLeave.Players[player.ID]; !ok {
-		log.Printf("Player %d is not in room %d", player.ID, roomID)
+		s.log.Info("Player is not in room", "player_id", player.ID, "room_id", roomID)
 		return
 	}
 
-	roomToLeave.RemovePlayer(player.ID)
-	log.Printf("Player %d left room %d", player.ID, roomID)
+	s.removeFromRoom(roomToLeave, player, false)
+	s.log.Info("Player left room", "player_id", player.ID, "room_id", roomID)
 
-	leftRoomMsg := []byte(fmt.Sprintf("LEFT_ROOM\n%d", roomID))
-	if err := player.Conn.WriteMessage(websocket.TextMessage, leftRoomMsg); err != nil {
-		log.Printf("Error send LEFT_ROOM message to player %d: %v", player.ID, err)
+	if err := s.sendMessage(player, &protocol.LeftRoom{RoomID: roomID}); err != nil {
+		s.log.Warn("Failed to send LEFT_ROOM", "player_id", player.ID, "err", err)
 	}
 
 	s.broadcastPlayerList(roomToLeave)
 }
 
+// removeFromRoom takes a player out of r, which must be locked, and updates
+// what depends on the room's players: the player's impairment, lockstep,
+// bots and, when this ends a running match, the match duration.
+func (s *Server) removeFromRoom(r *room.Room, p *room.Player, disconnected bool) {
+	wasStarted := r.State == "STARTED"
+	r.RemovePlayer(p.ID)
+	s.applyImpairment(p)
+	s.emit(PlayerLeft{PlayerID: p.ID, Name: p.Name, RoomID: r.ID, Disconnected: disconnected})
+	s.leaveLockstep(r, p.ID)
+	s.stopLonelyBots(r)
+	if wasStarted && r.State == "VACANT" {
+		duration := time.Since(r.StartedAt).Seconds()
+		s.metrics.matchDuration.Observe(duration)
+		s.emit(MatchEnded{RoomID: r.ID, DurationSeconds: duration})
+	}
+}
+
 func (s *Server) handleStart(player *room.Player) {
 	var playerRoom *room.Room
 	for _, r := range s.Rooms {
@@ -297,36 +465,42 @@ func (s *Server) handleStart(player *room.Player) {
 	}
 
 	if playerRoom == nil {
-		log.Printf("Player %d is not in any room", player.ID)
+		s.log.Info("Player is not in any room", "player_id", player.ID)
 		return
 	}
 
+	lockstep := s.config().Features.Lockstep
 	playerRoom.Mu.Lock()
 	defer playerRoom.Mu.Unlock()
 
+	if playerRoom.State != "STARTED" {
+		playerRoom.StartedAt = time.Now()
+	}
 	playerRoom.State = "STARTED"
 	playerRoom.IsSynchronizing = true
 	playerRoom.SyncFrameBuffer = make(map[int][][]byte)
-	for _, p := range playerRoom.Players {
+	for _, p := range playerRoom.SeatedPlayers() {
 		playerRoom.SyncFrameBuffer[p.ID] = make([][]byte, 0)
+		p.LastFrameSeq = -1
+	}
+	startLockstep(playerRoom, lockstep)
+	playerRoom.Checksums = room.NewChecksums()
+	s.log.Info("Room started, synchronizing", "room_id", playerRoom.ID, "player_id", player.ID,
+		"lockstep", playerRoom.Lockstep != nil)
+	started := RoomStarted{RoomID: playerRoom.ID, Players: []string{}, Lockstep: playerRoom.Lockstep != nil}
+	for _, p := range playerRoom.SeatedPlayers() {
+		started.Players = append(started.Players, p.Name)
 	}
-	log.Printf("Room %d started by player %d, synchronizing...", playerRoom.ID, player.ID)
+	s.emit(started)
 
 	// Broadcast ROOM_NOW_STARTED message
-	startMsg := []byte(fmt.Sprintf("ROOM_NOW_STARTED\n%d\n%d", playerRoom.ID, time.Since(playerRoom.Time).Milliseconds()))
-	for _, p := range playerRoom.Players {
-		if err := p.Conn.WriteMessage(websocket.TextMessage, startMsg); err != nil {
-			log.Printf("Error broadcasting to player %d: %v", p.ID, err)
-		}
-	}
+	s.broadcast(playerRoom, &protocol.RoomNowStarted{
+		RoomID:  playerRoom.ID,
+		Elapsed: time.Since(playerRoom.Time).Milliseconds(),
+	})
 }
 
-func (s *Server) handleChat(player *room.Player, parts []string) {
-	if len(parts) < 2 {
-		log.Printf("Invalid CHAT command from player %d", player.ID)
-		return
-	}
-
+func (s *Server) handleChat(player *room.Player, chat *protocol.ChatRequest) {
 	var playerRoom *room.Room
 	for _, r := range s.Rooms {
 		r.Mu.Lock()
@@ -340,22 +514,30 @@ func (s *Server) handleChat(player *room.Player, parts []string) {
 	}
 
 	if playerRoom == nil {
-		log.Printf("Player %d is not in any room", player.ID)
+		s.log.Info("Player is not in any room", "player_id", player.ID)
+		return
+	}
+
+	if s.handleBotCommand(player, playerRoom, chat.Text) {
 		return
 	}
 
 	playerRoom.Mu.Lock()
 	defer playerRoom.Mu.Unlock()
 
-	chatMsg := []byte(fmt.Sprintf("CHAT\n%d\n%s\n%s", player.ID, player.Name, parts[1]))
-	for _, p := range playerRoom.Players {
-		if err := p.Conn.WriteMessage(websocket.TextMessage, chatMsg); err != nil {
-			log.Printf("Error broadcasting to player %d: %v", p.ID, err)
+	s.broadcast(playerRoom, &protocol.Chat{PlayerID: player.ID, Name: player.Name, Text: chat.Text})
+}
+
+// handleFrame relays a FRAME. frame is nil when the message could not be
+// parsed and strict relaying is off, in which case it is relayed unchecked.
+func (s *Server) handleFrame(player *room.Player, frame *protocol.Frame, msg []byte) {
+	if frame != nil {
+		if reason := s.checkSender(player, protocol.CmdFrame, frame.PlayerID); reason != "" {
+			s.dropViolation(player, protocol.CmdFrame, reason)
+			return
 		}
 	}
-}
 
-func (s *Server) handleFrame(player *room.Player, msg []byte) {
 	var playerRoom *room.Room
 	for _, r := range s.Rooms {
 		// A quick check without lock
@@ -370,11 +552,34 @@ func (s *Server) handleFrame(player *room.Player, msg []byte) {
 		return
 	}
 
+	strict := s.config().Features.StrictRelay
 	playerRoom.Mu.Lock()
 	defer playerRoom.Mu.Unlock()
 
+	if frame != nil {
+		if strict {
+			if reason := checkFrameSeq(player, frame); reason != "" {
+				s.dropViolation(player, protocol.CmdFrame, reason)
+				return
+			}
+		}
+		s.checkDesync(playerRoom, player, frame)
+	}
+
+	if playerRoom.Lockstep != nil {
+		// Lockstep orders frames by their sequence number, so a FRAME that
+		// could not be parsed cannot take part and is dropped.
+		if frame == nil {
+			s.dropViolation(player, protocol.CmdFrame, violationMalformed)
+			return
+		}
+		s.relayLockstep(playerRoom, player, frame, msg)
+		return
+	}
+
 	if !playerRoom.IsSynchronizing {
 		// Regular frame forwarding
+		s.metrics.framesRelayed.Inc()
 		s.broadcastFrame(playerRoom, player.ID, msg)
 		return
 	}
@@ -389,7 +594,7 @@ func (s *Server) handleFrame(player *room.Player, msg []byte) {
 	if len(playerRoom.Players) < 2 { // No need to sync for single player
 		allReady = true
 	} else {
-		for _, p := range playerRoom.Players {
+		for _, p := range playerRoom.SeatedPlayers() {
 			if len(playerRoom.SyncFrameBuffer[p.ID]) < playerRoom.Latency {
 				allReady = false
 				break
@@ -398,15 +603,15 @@ func (s *Server) handleFrame(player *room.Player, msg []byte) {
 	}
 
 	if allReady {
-		log.Printf("Room %d synchronized. Releasing frame buffer.", playerRoom.ID)
+		s.log.Info("Room synchronized, releasing frame buffer", "room_id", playerRoom.ID)
 		playerRoom.IsSynchronizing = false
 
 		// Release all buffered frames in a round-robin fashion to ensure fairness
-		log.Printf("Broadcasting buffered frames for room %d in round-robin order.", playerRoom.ID)
 		for i := 0; i < playerRoom.Latency; i++ {
-			for _, p := range playerRoom.Players {
+			for _, p := range playerRoom.SeatedPlayers() {
 				// Ensure the player and their frame buffer for this index exist
 				if frames, ok := playerRoom.SyncFrameBuffer[p.ID]; ok && i < len(frames) {
+					s.metrics.framesRelayed.Inc()
 					s.broadcastFrame(playerRoom, p.ID, frames[i])
 				}
 			}
@@ -417,63 +622,26 @@ func (s *Server) handleFrame(player *room.Player, msg []byte) {
 }
 
 func (s *Server) broadcastFrame(r *room.Room, senderID int, msg []byte) {
-	for _, p := range r.Players {
-		if p.ID != senderID {
-			if err := p.Conn.WriteMessage(websocket.TextMessage, msg); err != nil {
-				log.Printf("Error broadcasting frame to player %d: %v", p.ID, err)
-			}
-		}
+	players := r.SeatedPlayers()
+	recipients := len(players)
+	if r.Players[senderID] != nil {
+		recipients--
 	}
-}
-
-func (s *Server) handleAdmin(player *room.Player) {
-	log.Printf("Admin connected: %d", player.ID)
-	ticker := time.NewTicker(5 * time.Second)
-	defer ticker.Stop()
-
-	for range ticker.C {
-		// Send STATS
-		statsMsg := []byte("STATS 0 0")
-		if err := player.Conn.WriteMessage(websocket.TextMessage, statsMsg); err != nil {
-			log.Printf("Error sending STATS to admin %d: %v", player.ID, err)
-			return
-		}
-
-		// Send ROOM_LIST
-		var b bytes.Buffer
-		b.WriteString("ROOM_LIST\n")
-		for i := 1; i <= 8; i++ {
-			r := s.Rooms[i]
-			r.Mu.Lock()
-			var playersInfo []string
-			for _, p := range r.Players {
-				playersInfo = append(playersInfo, fmt.Sprintf("{Name: %s, ID: %d, IP: %s}", p.Name, p.ID, p.IP.String()))
+	pm := s.prepare(msg, recipients)
+	for _, p := range players {
+		if p.ID != senderID {
+			if err := s.sendTo(p, pm, msg); err != nil {
+				s.log.Warn("Failed to relay message", "player_id", p.ID, "sender_id", senderID, "err", err)
 			}
-			b.WriteString(fmt.Sprintf("Room %d [%s] %d %d %s\n",
-				r.ID,
-				r.State,
-				r.Latency,
-				time.Since(r.Time).Milliseconds(),
-				strings.Join(playersInfo, ", "),
-			))
-			r.Mu.Unlock()
-		}
-		if err := player.Conn.WriteMessage(websocket.TextMessage, b.Bytes()); err != nil {
-			log.Printf("Error sending ROOM_LIST to admin %d: %v", player.ID, err)
-			return
 		}
 	}
 }
 
-func (s *Server) handleChangeLatency(player *room.Player, parts []string) {
-	if len(parts) < 2 {
-		log.Printf("Invalid CHANGE_LATENCY command from player %d", player.ID)
-		return
-	}
-
-	latency, err := strconv.Atoi(parts[1])
-	if err != nil {
-		log.Printf("Invalid latency from player %d: %s", player.ID, parts[1])
+func (s *Server) handleChangeLatency(player *room.Player, change *protocol.ChangeLatency) {
+	latency := change.Latency
+	// The same bound as SetLatency and Config.Validate put on the latency.
+	if latency < 1 {
+		s.log.Warn("Ignoring invalid latency", "player_id", player.ID, "latency", latency)
 		return
 	}
 
@@ -490,7 +658,7 @@ func (s *Server) handleChangeLatency(player *room.Player, parts []string) {
 	}
 
 	if playerRoom == nil {
-		log.Printf("Player %d is not in any room", player.ID)
+		s.log.Info("Player is not in any room", "player_id", player.ID)
 		return
 	}
 
@@ -498,17 +666,76 @@ func (s *Server) handleChangeLatency(player *room.Player, parts []string) {
 	defer playerRoom.Mu.Unlock()
 
 	playerRoom.Latency = latency
-	log.Printf("Room %d latency changed to %d by player %d", playerRoom.ID, latency, player.ID)
+	s.log.Info("Room latency changed", "room_id", playerRoom.ID, "latency", latency, "player_id", player.ID)
 
 	s.broadcastPlayerList(playerRoom)
 }
 
-func (s *Server) handleAway(player *room.Player, msg []byte) {
+func (s *Server) handleAway(player *room.Player, away *protocol.Away, msg []byte) {
+	if reason := s.checkSender(player, protocol.CmdAway, away.PlayerID); reason != "" {
+		s.dropViolation(player, protocol.CmdAway, reason)
+		return
+	}
 	s.broadcastToOthers(player, msg)
 }
 
-func (s *Server) handleUpdateControlNames(player *room.Player, msg []byte) {
-	s.broadcastToOthers(player, msg)
+// handleUpdateControlNames stores the new control names, so that later
+// PLAYER_LISTs carry them, and forwards the message to the rest of the room.
+func (s *Server) handleUpdateControlNames(player *room.Player, update *protocol.UpdateControlNames, msg []byte) {
+	if reason := s.checkSender(player, protocol.CmdUpdateControlNames, update.PlayerID); reason != "" {
+		s.dropViolation(player, protocol.CmdUpdateControlNames, reason)
+		return
+	}
+
+	c := update.Controls
+	r := s.lockPlayerRoom(player)
+	if r == nil {
+		player.P1, player.P2, player.P3, player.P4 = c[0], c[1], c[2], c[3]
+		return
+	}
+	defer r.Mu.Unlock()
+	player.P1, player.P2, player.P3, player.P4 = c[0], c[1], c[2], c[3]
+	s.log.Debug("Player control names updated", "player_id", player.ID, "room_id", r.ID)
+	s.broadcastFrame(r, player.ID, msg)
+}
+
+// handleUpdateAchievements stores the new achievement list of the player and
+// shares it with the room through PLAYER_LIST. The player id, if given, must
+// be the sender's.
+func (s *Server) handleUpdateAchievements(player *room.Player, update *protocol.UpdateAchievements) {
+	if update.PlayerID != 0 && update.PlayerID != player.ID {
+		s.log.Warn("UPDATE_ACHIEVEMENTS for another player", "player_id", player.ID, "target", update.PlayerID)
+		return
+	}
+	list := update.Achievements
+
+	r := s.lockPlayerRoom(player)
+	if r == nil {
+		player.SetAchievements(list)
+		return
+	}
+	defer r.Mu.Unlock()
+	player.SetAchievements(list)
+	s.log.Info("Player achievements updated", "player_id", player.ID, "room_id", r.ID,
+		"achievements", player.AchievementSet.Count())
+	s.broadcastPlayerList(r)
+}
+
+// lockPlayerRoom returns the room the player is in, locked, or nil when the
+// player is not in a room. The player fields shown in PLAYER_LIST are guarded
+// by that lock.
+func (s *Server) lockPlayerRoom(player *room.Player) *room.Room {
+	roomID := player.RoomID()
+	if roomID == 0 {
+		return nil
+	}
+	r := s.Rooms[roomID]
+	r.Mu.Lock()
+	if _, ok := r.Players[player.ID]; !ok {
+		r.Mu.Unlock()
+		return nil
+	}
+	return r
 }
 
 func (s *Server) broadcastToOthers(player *room.Player, msg []byte) {
@@ -525,7 +752,7 @@ func (s *Server) broadcastToOthers(player *room.Player, msg []byte) {
 	}
 
 	if playerRoom == nil {
-		log.Printf("Player %d is not in any room", player.ID)
+		s.log.Info("Player is not in any room", "player_id", player.ID)
 		return
 	}
 
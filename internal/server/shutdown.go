@@ -0,0 +1,129 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// How often the shutdown countdown is announced to every room.
+	shutdownAnnounceInterval = 10 * time.Second
+	// How often Shutdown checks whether the running matches have finished.
+	shutdownPollInterval = time.Second
+	// How long clients get to answer our close frame before the socket is
+	// closed from our side.
+	closeGracePeriod = 2 * time.Second
+)
+
+// SystemPlayerID is the player ID used for CHAT messages sent by the server
+// itself. Real players are numbered from 1.
+const SystemPlayerID = 0
+
+// systemChatName is the sender name of the server's CHAT messages.
+const systemChatName = "SERVER"
+
+func (s *Server) isDraining() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.draining
+}
+
+// Shutdown drains the server. It stops accepting new connections and JOINs,
+// announces a countdown to every room, and waits for the matches in progress
+// to finish or for ctx to expire, whichever comes first. All remaining
+// connections are then closed with a close frame.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	s.draining = true
+	s.mu.Unlock()
+	s.log.Info("Server is draining")
+
+	announce := time.NewTicker(shutdownAnnounceInterval)
+	defer announce.Stop()
+	poll := time.NewTicker(shutdownPollInterval)
+	defer poll.Stop()
+
+	s.announceShutdown(ctx)
+wait:
+	for s.startedRooms() > 0 {
+		select {
+		case <-ctx.Done():
+			s.log.Warn("Drain deadline reached", "matches_in_progress", s.startedRooms())
+			break wait
+		case <-announce.C:
+			s.announceShutdown(ctx)
+		case <-poll.C:
+		}
+	}
+
+	s.closeBots()
+	s.closeAll()
+	s.events.closeStreams()
+	s.webhooks.close()
+	s.transcripts.close()
+	return ctx.Err()
+}
+
+// announceShutdown sends a system CHAT with the time left to every room.
+func (s *Server) announceShutdown(ctx context.Context) {
+	text := "Server is shutting down."
+	if deadline, ok := ctx.Deadline(); ok {
+		left := time.Until(deadline).Round(time.Second)
+		if left < 0 {
+			left = 0
+		}
+		text = fmt.Sprintf("Server is shutting down in %s.", left)
+	}
+	for _, r := range s.Rooms {
+		r.Mu.Lock()
+		s.broadcastSystemChat(r, text)
+		r.Mu.Unlock()
+	}
+}
+
+// startedRooms counts the rooms with a match in progress.
+func (s *Server) startedRooms() int {
+	n := 0
+	for _, r := range s.Rooms {
+		r.Mu.Lock()
+		if r.State == "STARTED" {
+			n++
+		}
+		r.Mu.Unlock()
+	}
+	return n
+}
+
+// closeAll sends a close frame to every client and waits briefly for the
+// connection handlers to finish before closing the sockets outright.
+func (s *Server) closeAll() {
+	s.mu.Lock()
+	conns := make([]*websocket.Conn, 0, len(s.Clients))
+	for conn := range s.Clients {
+		conns = append(conns, conn)
+	}
+	s.mu.Unlock()
+
+	closeMsg := websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down")
+	for _, conn := range conns {
+		conn.WriteControl(websocket.CloseMessage, closeMsg, time.Now().Add(time.Second))
+	}
+
+	done := make(chan struct{})
+	go func() {
+		s.handlers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(closeGracePeriod):
+		for _, conn := range conns {
+			conn.Close()
+		}
+		<-done
+	}
+	s.log.Info("Closed connections", "count", len(conns))
+}
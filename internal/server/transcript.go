@@ -0,0 +1,144 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+
+	"github.com/zjx20/littlefighterhub/internal/logging"
+	"github.com/zjx20/littlefighterhub/internal/room"
+	"github.com/zjx20/littlefighterhub/pkg/protocol"
+)
+
+// transcripts writes the protocol messages exchanged with the players of each
+// room to a per-room JSON lines file, so disputes can be replayed later.
+type transcripts struct {
+	dir     string
+	enabled atomic.Bool
+	log     *slog.Logger
+
+	mu      sync.Mutex
+	files   map[int]*os.File
+	loggers map[int]*slog.Logger
+}
+
+func newTranscripts(dir string, enabled bool, log *slog.Logger) *transcripts {
+	t := &transcripts{
+		dir:     dir,
+		log:     log,
+		files:   make(map[int]*os.File),
+		loggers: make(map[int]*slog.Logger),
+	}
+	t.enabled.Store(enabled && dir != "")
+	return t
+}
+
+// setEnabled switches recording on or off. Open files are kept until close.
+func (t *transcripts) setEnabled(enabled bool) {
+	t.enabled.Store(enabled && t.dir != "")
+}
+
+// record appends a message to the transcript of roomID. Messages of players
+// outside any room are not recorded.
+func (t *transcripts) record(roomID int, direction string, playerID int, msg []byte) {
+	if roomID == 0 || !t.enabled.Load() {
+		return
+	}
+	logger := t.logger(roomID)
+	if logger == nil {
+		return
+	}
+	data := string(msg)
+	// A player in a room may still try the admin channel; keep the secret
+	// out of the transcript.
+	if command := protocol.Command(msg); command == protocol.CmdAdmin || command == protocol.CmdAuth {
+		data = command + "\n<redacted>"
+	}
+	logger.LogAttrs(context.Background(), slog.LevelInfo, "message",
+		slog.String("direction", direction),
+		slog.Int("player_id", playerID),
+		slog.String("data", data),
+	)
+}
+
+func (t *transcripts) logger(roomID int) *slog.Logger {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if l, ok := t.loggers[roomID]; ok {
+		return l
+	}
+	if err := os.MkdirAll(t.dir, 0o755); err != nil {
+		t.log.Error("Failed to create room log directory", "dir", t.dir, "err", err)
+		return nil
+	}
+	name := filepath.Join(t.dir, fmt.Sprintf("room-%d.log", roomID))
+	f, err := os.OpenFile(name, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		t.log.Error("Failed to open room log", "file", name, "err", err)
+		return nil
+	}
+	var level slog.LevelVar
+	level.Set(logging.LevelTrace)
+	h, _ := logging.NewHandler(f, "json", &level)
+	l := slog.New(h).With("room_id", roomID)
+	t.files[roomID] = f
+	t.loggers[roomID] = l
+	return l
+}
+
+// close closes all open transcript files.
+func (t *transcripts) close() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for id, f := range t.files {
+		f.Close()
+		delete(t.files, id)
+		delete(t.loggers, id)
+	}
+}
+
+// messageLogLevels lists the commands that are not logged at debug level.
+// FRAME arrives many times per second during a match and is only logged at
+// trace level.
+var messageLogLevels = map[string]slog.Level{
+	protocol.CmdFrame: logging.LevelTrace,
+}
+
+// logReceived logs an incoming message. Admin secrets, from ADMIN or AUTH,
+// and achievement lists are left out.
+func (s *Server) logReceived(player *room.Player, command string, parts []string) {
+	level, ok := messageLogLevels[command]
+	if !ok {
+		level = slog.LevelDebug
+	}
+	ctx := context.Background()
+	if !s.log.Enabled(ctx, level) {
+		return
+	}
+	args := append([]string(nil), parts[1:]...)
+	switch command {
+	case protocol.CmdAdmin, protocol.CmdAuth:
+		for i := range args {
+			args[i] = "<redacted>"
+		}
+	case protocol.CmdJoin:
+		if len(args) > 6 {
+			args[6] = fmt.Sprintf("<%d bytes of achievements>", len(args[6]))
+		}
+	case protocol.CmdUpdateAchievements:
+		if n := len(args); n > 0 {
+			args[n-1] = fmt.Sprintf("<%d bytes of achievements>", len(args[n-1]))
+		}
+	}
+	s.log.Log(ctx, level, "Received message",
+		"player_id", player.ID,
+		"room_id", player.RoomID(),
+		"direction", "in",
+		"command", command,
+		"args", args,
+	)
+}
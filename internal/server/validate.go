@@ -0,0 +1,63 @@
+package server
+
+import (
+	"github.com/zjx20/littlefighterhub/internal/room"
+	"github.com/zjx20/littlefighterhub/pkg/protocol"
+)
+
+// Reasons a relayed message is dropped, as used in logs and metrics.
+const (
+	violationMalformed = "malformed"
+	violationPlayerID  = "player_id"
+	violationFrameSeq  = "frame_seq"
+)
+
+// isRelayed reports whether the server forwards messages of the command to
+// the other players of the room.
+func isRelayed(command string) bool {
+	switch command {
+	case protocol.CmdFrame, protocol.CmdAway, protocol.CmdUpdateControlNames:
+		return true
+	}
+	return false
+}
+
+// checkSender verifies that the player id embedded in a relayed message is
+// the sender's, so a modified client cannot speak for someone else. It
+// returns the violation, or "" when the message is fine. UPDATE_CONTROL_NAMES
+// is always checked, since the server stores the names it carries; FRAME and
+// AWAY only with strict relaying, as the original server relays them as is.
+func (s *Server) checkSender(player *room.Player, command string, playerID int) string {
+	if command != protocol.CmdUpdateControlNames && !s.config().Features.StrictRelay {
+		return ""
+	}
+	if playerID != player.ID {
+		return violationPlayerID
+	}
+	return ""
+}
+
+// checkFrameSeq verifies that the FRAME seq is greater than the last one
+// relayed for the player and records it. The player's room must be locked.
+func checkFrameSeq(player *room.Player, frame *protocol.Frame) string {
+	if frame.Seq <= player.LastFrameSeq {
+		return violationFrameSeq
+	}
+	player.LastFrameSeq = frame.Seq
+	return ""
+}
+
+// dropViolation counts a message dropped for failing validation. Only the
+// first violation of a player is logged as a warning, since a misbehaving
+// client tends to repeat it many times per second.
+func (s *Server) dropViolation(player *room.Player, command, reason string) {
+	s.metrics.dropped.WithLabelValues(command, reason).Inc()
+	n := player.AddViolation()
+	if n == 1 {
+		s.log.Warn("Dropped invalid message", "player_id", player.ID, "room_id", player.RoomID(),
+			"command", command, "reason", reason)
+		return
+	}
+	s.log.Debug("Dropped invalid message", "player_id", player.ID, "room_id", player.RoomID(),
+		"command", command, "reason", reason, "violations", n)
+}
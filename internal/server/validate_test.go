@@ -0,0 +1,181 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/zjx20/littlefighterhub/internal/room"
+	"github.com/zjx20/littlefighterhub/pkg/protocol"
+)
+
+// joinServer connects a player to url and joins the room, returning the
+// connection and player id once the player is listed in the room.
+func joinServer(t *testing.T, url string, roomID int, name string) (*websocket.Conn, int) {
+	t.Helper()
+	conn, id := dialServer(t, url, nil)
+	writeServer(t, conn, fmt.Sprintf("JOIN\n%d\n%s\n%s\n\n\n\n", roomID, name, name))
+	readUntil(t, conn, protocol.CmdPlayerList)
+	return conn, id
+}
+
+// readUntil skips messages until one of the command arrives and returns it.
+func readUntil(t *testing.T, conn *websocket.Conn, command string) string {
+	t.Helper()
+	for {
+		msg := readServer(t, conn)
+		if protocol.Command([]byte(msg)) == command {
+			return msg
+		}
+	}
+}
+
+// frameWire is a FRAME from playerID with the given seq.
+func frameWire(playerID, seq int) string {
+	return fmt.Sprintf("FRAME\n%d\n%d\n0\n0\n0\n0\n0\n0", playerID, seq)
+}
+
+// clientByID returns the connected player with the id.
+func clientByID(t *testing.T, s *Server, id int) *room.Player {
+	t.Helper()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, p := range s.Clients {
+		if p.ID == id {
+			return p
+		}
+	}
+	t.Fatalf("player %d is not connected", id)
+	return nil
+}
+
+func TestIsRelayed(t *testing.T) {
+	for command, want := range map[string]bool{
+		protocol.CmdFrame:              true,
+		protocol.CmdAway:               true,
+		protocol.CmdUpdateControlNames: true,
+		protocol.CmdChat:               false,
+		protocol.CmdJoin:               false,
+		protocol.CmdUpdateAchievements: false,
+	} {
+		if got := isRelayed(command); got != want {
+			t.Errorf("isRelayed(%s) = %v, want %v", command, got, want)
+		}
+	}
+}
+
+func TestCheckSender(t *testing.T) {
+	tests := []struct {
+		strict   bool
+		command  string
+		playerID int
+		want     string
+	}{
+		{true, protocol.CmdFrame, 1, ""},
+		{true, protocol.CmdFrame, 2, violationPlayerID},
+		{true, protocol.CmdAway, 2, violationPlayerID},
+		{true, protocol.CmdUpdateControlNames, 2, violationPlayerID},
+		{false, protocol.CmdFrame, 2, ""},
+		{false, protocol.CmdAway, 2, ""},
+		// The server stores control names, so they are checked regardless.
+		{false, protocol.CmdUpdateControlNames, 2, violationPlayerID},
+		{false, protocol.CmdUpdateControlNames, 1, ""},
+	}
+	for _, tt := range tests {
+		cfg := DefaultConfig()
+		cfg.Features.StrictRelay = tt.strict
+		s, _ := testServer(t, cfg)
+		player := &room.Player{ID: 1}
+		if got := s.checkSender(player, tt.command, tt.playerID); got != tt.want {
+			t.Errorf("strict=%v checkSender(%s, %d) = %q, want %q", tt.strict, tt.command, tt.playerID, got, tt.want)
+		}
+	}
+}
+
+func TestCheckFrameSeq(t *testing.T) {
+	player := &room.Player{ID: 1}
+	for _, tt := range []struct {
+		seq  int
+		want string
+	}{
+		{1, ""},
+		{2, ""},
+		{2, violationFrameSeq},
+		{1, violationFrameSeq},
+		{5, ""},
+	} {
+		if got := checkFrameSeq(player, &protocol.Frame{PlayerID: 1, Seq: tt.seq}); got != tt.want {
+			t.Errorf("checkFrameSeq(%d) = %q, want %q", tt.seq, got, tt.want)
+		}
+	}
+	if player.LastFrameSeq != 5 {
+		t.Errorf("LastFrameSeq = %d, want 5", player.LastFrameSeq)
+	}
+}
+
+// TestStrictRelay sends FRAMEs that strict relaying drops between good ones
+// and checks that the other player of the room only gets the good ones.
+func TestStrictRelay(t *testing.T) {
+	s, url := testServer(t, DefaultConfig())
+	a, aID := joinServer(t, url, 1, "a")
+	b, bID := joinServer(t, url, 1, "b")
+	readUntil(t, a, protocol.CmdPlayerList)
+
+	for _, msg := range []string{
+		frameWire(bID, 1),              // another player's id
+		frameWire(aID, 1),              // relayed
+		frameWire(aID, 1),              // seq not increasing
+		"FRAME\nx",                     // malformed
+		frameWire(aID, 2),              // relayed
+		fmt.Sprintf("AWAY\n%d\n", bID), // another player's id
+	} {
+		writeServer(t, a, msg)
+	}
+	// A message that is always relayed marks the end of the sequence.
+	writeServer(t, a, fmt.Sprintf("AWAY\n%d\n", aID))
+
+	var got []string
+	for {
+		msg := readServer(t, b)
+		if protocol.Command([]byte(msg)) == protocol.CmdPlayerList {
+			continue
+		}
+		got = append(got, msg)
+		if strings.HasPrefix(msg, protocol.CmdAway) {
+			break
+		}
+	}
+	want := []string{frameWire(aID, 1), frameWire(aID, 2), fmt.Sprintf("AWAY\n%d\n", aID)}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("relayed %q, want %q", got, want)
+	}
+	if n := clientByID(t, s, aID).Violations(); n != 4 {
+		t.Errorf("Violations = %d, want 4", n)
+	}
+}
+
+// TestLenientRelay checks that with strict relaying off FRAMEs are relayed
+// as the original server does, malformed ones included.
+func TestLenientRelay(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Features.StrictRelay = false
+	s, url := testServer(t, cfg)
+	a, aID := joinServer(t, url, 1, "a")
+	b, bID := joinServer(t, url, 1, "b")
+	readUntil(t, a, protocol.CmdPlayerList)
+
+	sent := []string{frameWire(bID, 1), frameWire(aID, 1), frameWire(aID, 1), "FRAME\nx"}
+	for _, msg := range sent {
+		writeServer(t, a, msg)
+	}
+	for _, want := range sent {
+		if got := readUntil(t, b, protocol.CmdFrame); got != want {
+			t.Errorf("relayed %q, want %q", got, want)
+		}
+	}
+	if n := clientByID(t, s, aID).Violations(); n != 0 {
+		t.Errorf("Violations = %d, want 0", n)
+	}
+}
@@ -0,0 +1,223 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// webhookBuffer is how many events a webhook may fall behind before
+	// events are dropped for it.
+	webhookBuffer = 1024
+	// webhookAttempts is how often a delivery is tried before giving up.
+	webhookAttempts = 6
+	// The wait before the first retry, doubled for each further one.
+	webhookBackoff = time.Second
+	// How long a single delivery may take.
+	webhookTimeout = 10 * time.Second
+	// How long Shutdown waits for the events still queued to be delivered.
+	webhookFlushTimeout = 5 * time.Second
+)
+
+// Webhook delivers events to a URL, one HTTP POST with the JSON encoded
+// Event per event, in order. A delivery is retried with exponential backoff
+// while the endpoint fails or answers with a 5xx or 429 status.
+type Webhook struct {
+	URL string `json:"url"`
+	// Events lists the event types delivered; all when empty.
+	Events []string `json:"events,omitempty"`
+	// Secret, if set, signs every delivery: the X-LF2Hub-Signature header
+	// holds "sha256=" and the hex HMAC-SHA256 of the body.
+	Secret string `json:"secret,omitempty"`
+}
+
+func (h Webhook) validate() error {
+	u, err := url.Parse(h.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid webhook URL %q", h.URL)
+	}
+	return checkEventTypes(h.Events)
+}
+
+// webhooks runs a sender per configured webhook.
+type webhooks struct {
+	s      *Server
+	client *http.Client
+
+	mu      sync.Mutex
+	senders []*webhookSender
+}
+
+// webhookSender delivers the events of one subscription to a webhook.
+type webhookSender struct {
+	hook   Webhook
+	sub    *subscription
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func newWebhooks(s *Server) *webhooks {
+	return &webhooks{s: s, client: &http.Client{Timeout: webhookTimeout}}
+}
+
+// configure starts senders for new webhooks and stops those of webhooks no
+// longer configured. Unchanged webhooks keep their queue.
+func (w *webhooks) configure(hooks []Webhook) {
+	w.mu.Lock()
+	var keep, stop []*webhookSender
+	for _, sender := range w.senders {
+		kept := false
+		for _, h := range hooks {
+			kept = kept || reflect.DeepEqual(sender.hook, h)
+		}
+		if kept {
+			keep = append(keep, sender)
+		} else {
+			stop = append(stop, sender)
+		}
+	}
+	for _, h := range hooks {
+		running := false
+		for _, sender := range keep {
+			running = running || reflect.DeepEqual(sender.hook, h)
+		}
+		if !running {
+			keep = append(keep, w.start(h))
+		}
+	}
+	w.senders = keep
+	w.mu.Unlock()
+
+	for _, sender := range stop {
+		w.s.events.unsubscribe(sender.sub)
+		sender.cancel()
+		<-sender.done
+	}
+}
+
+func (w *webhooks) start(h Webhook) *webhookSender {
+	ctx, cancel := context.WithCancel(context.Background())
+	sub, _ := w.s.events.subscribe(webhookBuffer, eventFilter(h.Events), false, 0)
+	sender := &webhookSender{hook: h, sub: sub, cancel: cancel, done: make(chan struct{})}
+	w.s.log.Info("Webhook added", "url", h.URL, "events", h.Events)
+	go func() {
+		defer close(sender.done)
+		for ev := range sub.ch {
+			if ctx.Err() != nil {
+				// Stopped; the rest of the queue is abandoned.
+				w.s.metrics.eventsDropped.Inc()
+				continue
+			}
+			w.deliver(ctx, h, ev)
+		}
+	}()
+	return sender
+}
+
+// close stops taking new events and waits for the queued ones to be
+// delivered, abandoning them after webhookFlushTimeout.
+func (w *webhooks) close() {
+	w.mu.Lock()
+	senders := w.senders
+	w.senders = nil
+	w.mu.Unlock()
+
+	for _, sender := range senders {
+		w.s.events.unsubscribe(sender.sub)
+	}
+	timeout := time.NewTimer(webhookFlushTimeout)
+	defer timeout.Stop()
+	for _, sender := range senders {
+		select {
+		case <-sender.done:
+		case <-timeout.C:
+			for _, other := range senders {
+				other.cancel()
+			}
+			<-sender.done
+		}
+	}
+	for _, sender := range senders {
+		sender.cancel()
+	}
+}
+
+// deliver posts an event, retrying until it is accepted, the endpoint
+// rejects it for good, the attempts run out or ctx is done.
+func (w *webhooks) deliver(ctx context.Context, h Webhook, ev Event) {
+	body, err := json.Marshal(ev)
+	if err != nil {
+		w.s.log.Error("Failed to encode event", "type", ev.Type, "err", err)
+		return
+	}
+	log := w.s.log.With("url", h.URL, "event_id", ev.ID, "type", ev.Type)
+	backoff := webhookBackoff
+	for attempt := 1; ; attempt++ {
+		retry, err := w.post(ctx, h, ev, body)
+		if err == nil {
+			w.s.metrics.webhookDeliveries.WithLabelValues("ok").Inc()
+			return
+		}
+		if !retry || attempt == webhookAttempts || ctx.Err() != nil {
+			w.s.metrics.webhookDeliveries.WithLabelValues("failed").Inc()
+			log.Warn("Webhook delivery failed", "attempts", attempt, "err", err)
+			return
+		}
+		w.s.metrics.webhookDeliveries.WithLabelValues("retry").Inc()
+		log.Debug("Retrying webhook delivery", "attempt", attempt, "backoff", backoff, "err", err)
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			w.s.metrics.webhookDeliveries.WithLabelValues("failed").Inc()
+			log.Warn("Webhook delivery abandoned", "attempts", attempt, "err", err)
+			return
+		}
+		backoff *= 2
+	}
+}
+
+// post makes one delivery attempt. retry reports whether a failure may be
+// temporary.
+func (w *webhooks) post(ctx context.Context, h Webhook, ev Event, body []byte) (retry bool, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "lf2hub-webhook")
+	req.Header.Set("X-LF2Hub-Event", ev.Type)
+	req.Header.Set("X-LF2Hub-Delivery", strconv.FormatInt(ev.ID, 10))
+	if h.Secret != "" {
+		mac := hmac.New(sha256.New, []byte(h.Secret))
+		mac.Write(body)
+		req.Header.Set("X-LF2Hub-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests:
+		return true, fmt.Errorf("status %s", resp.Status)
+	default:
+		return false, fmt.Errorf("status %s", resp.Status)
+	}
+}
@@ -0,0 +1,176 @@
+package server
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// delivery is a request received by a test webhook endpoint.
+type delivery struct {
+	header http.Header
+	body   []byte
+}
+
+// webhookEndpoint records the requests it gets on the returned channel and
+// answers each with the next status of statuses, then with 200.
+func webhookEndpoint(t *testing.T, statuses ...int) (string, <-chan delivery) {
+	t.Helper()
+	got := make(chan delivery, 16)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		got <- delivery{header: r.Header, body: body}
+		if len(statuses) > 0 {
+			w.WriteHeader(statuses[0])
+			statuses = statuses[1:]
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv.URL, got
+}
+
+func nextDelivery(t *testing.T, got <-chan delivery) delivery {
+	t.Helper()
+	select {
+	case d := <-got:
+		return d
+	case <-time.After(5 * time.Second):
+		t.Fatal("no webhook delivery")
+		return delivery{}
+	}
+}
+
+// webhookServer starts a server delivering its events to hooks and stops
+// the deliveries when the test ends.
+func webhookServer(t *testing.T, hooks ...Webhook) (*Server, string) {
+	t.Helper()
+	cfg := DefaultConfig()
+	cfg.Webhooks = hooks
+	s, url := testServer(t, cfg)
+	t.Cleanup(s.webhooks.close)
+	return s, url
+}
+
+func TestWebhookValidate(t *testing.T) {
+	tests := []struct {
+		hook Webhook
+		want bool
+	}{
+		{Webhook{URL: "https://example.com/hook"}, true},
+		{Webhook{URL: "http://example.com/hook", Events: []string{EventDesync}}, true},
+		{Webhook{URL: "ftp://example.com/hook"}, false},
+		{Webhook{URL: "https:///hook"}, false},
+		{Webhook{URL: "https://example.com/hook", Events: []string{"bogus"}}, false},
+	}
+	for _, tt := range tests {
+		if err := tt.hook.validate(); (err == nil) != tt.want {
+			t.Errorf("%+v.validate() = %v, want valid %v", tt.hook, err, tt.want)
+		}
+	}
+}
+
+func TestWebhookDelivery(t *testing.T) {
+	endpoint, got := webhookEndpoint(t)
+	_, url := webhookServer(t, Webhook{URL: endpoint, Events: []string{EventPlayerJoined}, Secret: "k"})
+	_, id := joinServer(t, url, 2, "a")
+
+	d := nextDelivery(t, got)
+	mac := hmac.New(sha256.New, []byte("k"))
+	mac.Write(d.body)
+	if sig, want := d.header.Get("X-LF2Hub-Signature"), "sha256="+hex.EncodeToString(mac.Sum(nil)); sig != want {
+		t.Errorf("signature = %s, want %s", sig, want)
+	}
+	if ev := d.header.Get("X-LF2Hub-Event"); ev != EventPlayerJoined {
+		t.Errorf("X-LF2Hub-Event = %s, want %s", ev, EventPlayerJoined)
+	}
+	var ev struct {
+		ID   int64  `json:"id"`
+		Type string `json:"type"`
+		Data struct {
+			PlayerID int `json:"player_id"`
+			RoomID   int `json:"room_id"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(d.body, &ev); err != nil {
+		t.Fatal(err)
+	}
+	// player_connected, which is 1, is not delivered to this webhook.
+	if ev.ID != 2 || ev.Type != EventPlayerJoined || ev.Data.PlayerID != id || ev.Data.RoomID != 2 {
+		t.Errorf("delivered %s, want player_joined 2 of player %d in room 2", d.body, id)
+	}
+	if id := d.header.Get("X-LF2Hub-Delivery"); id != "2" {
+		t.Errorf("X-LF2Hub-Delivery = %s, want 2", id)
+	}
+}
+
+// TestWebhookRetry checks that a 5xx is retried with the same event and that
+// a 4xx is not, in which case the next event follows.
+func TestWebhookRetry(t *testing.T) {
+	endpoint, got := webhookEndpoint(t, http.StatusServiceUnavailable, http.StatusOK, http.StatusBadRequest)
+	s, _ := webhookServer(t, Webhook{URL: endpoint})
+
+	s.emit(PlayerConnected{PlayerID: 1})
+	s.emit(PlayerConnected{PlayerID: 2})
+	s.emit(PlayerConnected{PlayerID: 3})
+	var ids []string
+	for i := 0; i < 4; i++ {
+		ids = append(ids, nextDelivery(t, got).header.Get("X-LF2Hub-Delivery"))
+	}
+	if got, want := strings.Join(ids, ","), "1,1,2,3"; got != want {
+		t.Errorf("deliveries %s, want %s", got, want)
+	}
+	select {
+	case d := <-got:
+		t.Errorf("unexpected delivery of %s", d.header.Get("X-LF2Hub-Delivery"))
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+// TestWebhookAbandoned checks that a delivery waiting to be retried ends as
+// soon as its webhook is stopped.
+func TestWebhookAbandoned(t *testing.T) {
+	endpoint, got := webhookEndpoint(t, http.StatusServiceUnavailable)
+	s, _ := webhookServer(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.webhooks.deliver(ctx, Webhook{URL: endpoint}, Event{ID: 1, Type: EventPlayerConnected, Data: PlayerConnected{}})
+	}()
+	nextDelivery(t, got)
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(webhookBackoff / 2):
+		t.Fatal("delivery not abandoned")
+	}
+}
+
+// TestWebhookConfigure checks that reconfiguring keeps the senders of the
+// webhooks that did not change.
+func TestWebhookConfigure(t *testing.T) {
+	a := Webhook{URL: "http://a.example.com/hook"}
+	b := Webhook{URL: "http://b.example.com/hook", Events: []string{EventDesync}}
+	s, _ := webhookServer(t, a, b)
+	before := s.webhooks.senders
+
+	b.Events = []string{EventMatchEnded}
+	s.webhooks.configure([]Webhook{a, b})
+	after := s.webhooks.senders
+	if len(after) != 2 || after[0] != before[0] || after[1] == before[1] {
+		t.Fatalf("senders %v after changing b, want a's kept and b's replaced from %v", after, before)
+	}
+	s.webhooks.configure(nil)
+	if len(s.webhooks.senders) != 0 {
+		t.Errorf("%d senders left after removing all webhooks", len(s.webhooks.senders))
+	}
+}
@@ -0,0 +1,23 @@
+package tlsutil
+
+import (
+	"net"
+	"net/http"
+)
+
+// RedirectHandler redirects every plain HTTP request to the same host and
+// path over HTTPS on httpsPort. The port is omitted from the URL when it is
+// the default 443.
+func RedirectHandler(httpsPort string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(r.Host); err == nil {
+			host = h
+		}
+		if httpsPort != "" && httpsPort != "443" {
+			host = net.JoinHostPort(host, httpsPort)
+		}
+		target := "https://" + host + r.URL.RequestURI()
+		http.Redirect(w, r, target, http.StatusMovedPermanently)
+	})
+}
@@ -0,0 +1,326 @@
+// Package lf2client is a client for the LF2 room server protocol, for
+// writing tools, bots and tests against a room server.
+//
+// A Client is created with Dial, which returns once the server has sent
+// YOUR_ID. Every message received after that is delivered on Events, which
+// must be drained; List, Join and Leave additionally wait for the reply to
+// their request.
+package lf2client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/zjx20/littlefighterhub/pkg/protocol"
+)
+
+// ErrClosed is returned by Err after Close was called.
+var ErrClosed = errors.New("lf2client: connection closed")
+
+// Default number of events buffered before the client stops reading.
+const defaultEventBuffer = 256
+
+// Event is a message received from the server.
+type Event struct {
+	// Message is the parsed message, nil when it could not be parsed.
+	Message protocol.Message
+	// Err is the parse error of a message the client did not understand.
+	Err error
+	// Raw is the message as received.
+	Raw      []byte
+	Received time.Time
+}
+
+// Dialer configures new connections. The zero value is usable.
+type Dialer struct {
+	// WebSocket is the dialer used to connect; websocket.DefaultDialer when
+	// nil.
+	WebSocket *websocket.Dialer
+	// Header is sent with the handshake, e.g. to pass an admin token.
+	Header http.Header
+	// EventBuffer is the capacity of the Events channel; 256 when zero.
+	EventBuffer int
+}
+
+// Dial connects to the room server at url, e.g. "ws://localhost:8080/",
+// with the default Dialer.
+func Dial(ctx context.Context, url string) (*Client, error) {
+	var d Dialer
+	return d.Dial(ctx, url)
+}
+
+// Dial connects to the room server at url and waits for YOUR_ID. ctx bounds
+// the handshake only; use Close to end the connection.
+func (d *Dialer) Dial(ctx context.Context, url string) (*Client, error) {
+	wd := d.WebSocket
+	if wd == nil {
+		wd = websocket.DefaultDialer
+	}
+	conn, _, err := wd.DialContext(ctx, url, d.Header)
+	if err != nil {
+		return nil, err
+	}
+
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	_, data, err := conn.ReadMessage()
+	if !stop() {
+		return nil, ctx.Err()
+	}
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("lf2client: waiting for YOUR_ID: %w", err)
+	}
+	m, err := protocol.ParseServer(data)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	yourID, ok := m.(*protocol.YourID)
+	if !ok {
+		conn.Close()
+		return nil, fmt.Errorf("lf2client: got %s instead of YOUR_ID", m.Command())
+	}
+
+	buffer := d.EventBuffer
+	if buffer <= 0 {
+		buffer = defaultEventBuffer
+	}
+	c := &Client{
+		conn:   conn,
+		id:     yourID.PlayerID,
+		events: make(chan Event, buffer),
+		closed: make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	go c.readLoop()
+	return c, nil
+}
+
+// Client is a connection to a room server. Its methods may be called
+// concurrently.
+type Client struct {
+	conn    *websocket.Conn
+	id      int
+	writeMu sync.Mutex
+
+	events    chan Event
+	closed    chan struct{}
+	closeOnce sync.Once
+	// done is closed, and err set, once the read loop has stopped.
+	done chan struct{}
+
+	mu      sync.Mutex
+	err     error
+	waiters []*waiter
+}
+
+// waiter is a request waiting for its reply.
+type waiter struct {
+	match func(protocol.Message) bool
+	reply chan protocol.Message
+}
+
+// ID returns the player id the server assigned in YOUR_ID.
+func (c *Client) ID() int { return c.id }
+
+// Events delivers every message received from the server, including the
+// replies to List, Join and Leave. It is closed when the connection ends.
+// Reading stops while the channel is full.
+func (c *Client) Events() <-chan Event { return c.events }
+
+// Done is closed when the connection has ended.
+func (c *Client) Done() <-chan struct{} { return c.done }
+
+// Err returns why the connection ended, or nil while it is open.
+func (c *Client) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+// Close ends the connection.
+func (c *Client) Close() error {
+	var err error
+	c.closeOnce.Do(func() {
+		close(c.closed)
+		// WriteControl may be called concurrently with other writes.
+		c.conn.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+		err = c.conn.Close()
+	})
+	<-c.done
+	return err
+}
+
+func (c *Client) readLoop() {
+	var err error
+	defer func() {
+		select {
+		case <-c.closed:
+			err = ErrClosed
+		default:
+		}
+		c.mu.Lock()
+		c.err = err
+		c.mu.Unlock()
+		close(c.done)
+		close(c.events)
+	}()
+
+	for {
+		var data []byte
+		_, data, err = c.conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		ev := Event{Raw: data, Received: time.Now()}
+		ev.Message, ev.Err = protocol.ParseServer(data)
+		if ev.Err == nil {
+			c.notify(ev.Message)
+		}
+		select {
+		case c.events <- ev:
+		case <-c.closed:
+			return
+		}
+	}
+}
+
+// notify hands m to the first waiter it answers.
+func (c *Client) notify(m protocol.Message) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, w := range c.waiters {
+		if w.match(m) {
+			w.reply <- m
+			c.waiters = append(c.waiters[:i], c.waiters[i+1:]...)
+			return
+		}
+	}
+}
+
+func (c *Client) removeWaiter(w *waiter) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, x := range c.waiters {
+		if x == w {
+			c.waiters = append(c.waiters[:i], c.waiters[i+1:]...)
+			return
+		}
+	}
+}
+
+// request sends m and waits for the first message match accepts. The waiter
+// is registered before sending, so a fast reply cannot be missed.
+func (c *Client) request(ctx context.Context, m protocol.Message, match func(protocol.Message) bool) (protocol.Message, error) {
+	w := &waiter{match: match, reply: make(chan protocol.Message, 1)}
+	c.mu.Lock()
+	c.waiters = append(c.waiters, w)
+	c.mu.Unlock()
+	defer c.removeWaiter(w)
+
+	if err := c.Send(ctx, m); err != nil {
+		return nil, err
+	}
+	select {
+	case reply := <-w.reply:
+		return reply, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-c.done:
+		return nil, c.Err()
+	}
+}
+
+// Send encodes and sends any message.
+func (c *Client) Send(ctx context.Context, m protocol.Message) error {
+	msg, err := protocol.Encode(m)
+	if err != nil {
+		return err
+	}
+	return c.SendRaw(ctx, msg)
+}
+
+// SendRaw sends an already encoded message. The deadline of ctx, if any,
+// bounds the write.
+func (c *Client) SendRaw(ctx context.Context, msg []byte) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	deadline, _ := ctx.Deadline()
+	c.conn.SetWriteDeadline(deadline)
+	return c.conn.WriteMessage(websocket.TextMessage, msg)
+}
+
+// List returns the rooms of the server.
+func (c *Client) List(ctx context.Context) ([]protocol.RoomSummary, error) {
+	reply, err := c.request(ctx, &protocol.ListRequest{}, func(m protocol.Message) bool {
+		_, ok := m.(*protocol.List)
+		return ok
+	})
+	if err != nil {
+		return nil, err
+	}
+	return reply.(*protocol.List).Rooms, nil
+}
+
+// Join enters the room join.RoomID and returns the first PLAYER_LIST that
+// includes the client. The server does not answer when it refuses the JOIN,
+// e.g. because the room is full or started, so ctx should carry a timeout.
+func (c *Client) Join(ctx context.Context, join protocol.Join) (*protocol.PlayerList, error) {
+	reply, err := c.request(ctx, &join, func(m protocol.Message) bool {
+		list, ok := m.(*protocol.PlayerList)
+		if !ok || list.RoomID != join.RoomID {
+			return false
+		}
+		for _, p := range list.Players {
+			if p.ID == c.id {
+				return true
+			}
+		}
+		return false
+	})
+	if err != nil {
+		return nil, err
+	}
+	return reply.(*protocol.PlayerList), nil
+}
+
+// Leave exits the room and waits for LEFT_ROOM.
+func (c *Client) Leave(ctx context.Context, roomID int) error {
+	_, err := c.request(ctx, &protocol.Leave{RoomID: roomID}, func(m protocol.Message) bool {
+		left, ok := m.(*protocol.LeftRoom)
+		return ok && left.RoomID == roomID
+	})
+	return err
+}
+
+// Start starts the game in the client's room. Everyone in the room receives
+// ROOM_NOW_STARTED.
+func (c *Client) Start(ctx context.Context) error {
+	return c.Send(ctx, &protocol.Start{})
+}
+
+// Chat sends a chat line to the client's room.
+func (c *Client) Chat(ctx context.Context, text string) error {
+	return c.Send(ctx, &protocol.ChatRequest{Text: text})
+}
+
+// ChangeLatency sets the latency of the client's room.
+func (c *Client) ChangeLatency(ctx context.Context, latency int) error {
+	return c.Send(ctx, &protocol.ChangeLatency{Latency: latency})
+}
+
+// SendFrame sends the input of one game frame. The player id is filled in.
+func (c *Client) SendFrame(ctx context.Context, frame protocol.Frame) error {
+	frame.PlayerID = c.id
+	return c.Send(ctx, &frame)
+}
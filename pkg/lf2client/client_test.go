@@ -0,0 +1,206 @@
+package lf2client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/zjx20/littlefighterhub/pkg/protocol"
+)
+
+// fakeServer greets every connection as player 7 and answers each message
+// with the replies reply returns for it.
+func fakeServer(t *testing.T, reply func(protocol.Message) []protocol.Message) string {
+	t.Helper()
+	var upgrader websocket.Upgrader
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		send := func(m protocol.Message) bool {
+			msg, err := protocol.Encode(m)
+			if err != nil {
+				t.Errorf("Encode: %v", err)
+				return false
+			}
+			return conn.WriteMessage(websocket.TextMessage, msg) == nil
+		}
+		if !send(&protocol.YourID{PlayerID: 7, Params: protocol.DefaultYourIDParams}) {
+			return
+		}
+		for {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			m, err := protocol.ParseClient(data)
+			if err != nil {
+				t.Errorf("ParseClient(%q): %v", data, err)
+				return
+			}
+			for _, r := range reply(m) {
+				if !send(r) {
+					return
+				}
+			}
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return "ws" + strings.TrimPrefix(srv.URL, "http")
+}
+
+func dialFake(t *testing.T, url string) *Client {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	c, err := Dial(ctx, url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	// Nothing reads the events, so keep the channel drained.
+	go func() {
+		for range c.Events() {
+		}
+	}()
+	return c
+}
+
+func playerList(roomID int, ids ...int) *protocol.PlayerList {
+	list := &protocol.PlayerList{RoomID: roomID, Latency: 3}
+	for _, id := range ids {
+		list.Players = append(list.Players, protocol.PlayerEntry{ID: id, Name: "p"})
+	}
+	return list
+}
+
+// TestJoinWaitsForOwnPlayerList checks that Join skips the PLAYER_LISTs of
+// other rooms and those that do not list the client yet.
+func TestJoinWaitsForOwnPlayerList(t *testing.T) {
+	url := fakeServer(t, func(m protocol.Message) []protocol.Message {
+		if _, ok := m.(*protocol.Join); !ok {
+			return nil
+		}
+		return []protocol.Message{
+			playerList(1, 7),
+			playerList(2, 3),
+			playerList(2, 3, 7, 9),
+		}
+	})
+	c := dialFake(t, url)
+	if c.ID() != 7 {
+		t.Fatalf("ID = %d, want 7", c.ID())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	list, err := c.Join(ctx, protocol.Join{RoomID: 2, Name: "p"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if list.RoomID != 2 || len(list.Players) != 3 {
+		t.Errorf("Join = %+v, want the list of room 2 with 3 players", list)
+	}
+}
+
+func TestLeaveWaitsForItsRoom(t *testing.T) {
+	url := fakeServer(t, func(m protocol.Message) []protocol.Message {
+		leave, ok := m.(*protocol.Leave)
+		if !ok {
+			return nil
+		}
+		return []protocol.Message{
+			&protocol.LeftRoom{RoomID: leave.RoomID + 1},
+			&protocol.LeftRoom{RoomID: leave.RoomID},
+		}
+	})
+	c := dialFake(t, url)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := c.Leave(ctx, 4); err != nil {
+		t.Fatal(err)
+	}
+	if n := waiters(c); n != 0 {
+		t.Errorf("%d waiters left, want 0", n)
+	}
+}
+
+// TestConcurrentRequests checks that each waiter gets the reply to its own
+// request when replies for several requests are pending.
+func TestConcurrentRequests(t *testing.T) {
+	url := fakeServer(t, func(m protocol.Message) []protocol.Message {
+		switch m := m.(type) {
+		case *protocol.Join:
+			return []protocol.Message{playerList(m.RoomID, 7)}
+		case *protocol.Leave:
+			return []protocol.Message{&protocol.LeftRoom{RoomID: m.RoomID}}
+		}
+		return nil
+	})
+	c := dialFake(t, url)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	errs := make(chan error, 6)
+	for room := 1; room <= 3; room++ {
+		room := room
+		go func() {
+			list, err := c.Join(ctx, protocol.Join{RoomID: room, Name: "p"})
+			if err == nil && list.RoomID != room {
+				err = errors.New("Join got the list of another room")
+			}
+			errs <- err
+		}()
+		go func() { errs <- c.Leave(ctx, room) }()
+	}
+	for i := 0; i < 6; i++ {
+		if err := <-errs; err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+// TestJoinWithoutReply checks that a refused JOIN, which the server does not
+// answer, ends with the context and leaves no waiter behind.
+func TestJoinWithoutReply(t *testing.T) {
+	url := fakeServer(t, func(protocol.Message) []protocol.Message { return nil })
+	c := dialFake(t, url)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := c.Join(ctx, protocol.Join{RoomID: 1, Name: "p"}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Join = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if n := waiters(c); n != 0 {
+		t.Errorf("%d waiters left, want 0", n)
+	}
+}
+
+func TestRequestAfterClose(t *testing.T) {
+	url := fakeServer(t, func(protocol.Message) []protocol.Message { return nil })
+	c := dialFake(t, url)
+	c.Close()
+	if err := c.Err(); !errors.Is(err, ErrClosed) {
+		t.Fatalf("Err = %v, want %v", err, ErrClosed)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := c.Leave(ctx, 1); err == nil {
+		t.Fatal("Leave after Close succeeded")
+	}
+}
+
+func waiters(c *Client) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.waiters)
+}
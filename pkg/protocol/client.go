@@ -0,0 +1,271 @@
+package protocol
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Messages sent by clients to the server.
+
+var clientMessages = map[string]parser{
+	CmdList:               parseListRequest,
+	CmdJoin:               parseJoin,
+	CmdLeave:              parseLeave,
+	CmdStart:              parseStart,
+	CmdChat:               parseChatRequest,
+	CmdChangeLatency:      parseChangeLatency,
+	CmdUpdateAchievements: parseUpdateAchievements,
+	CmdAdmin:              parseAdmin,
+	CmdAuth:               parseAuth,
+	CmdFrame:              parseFrame,
+	CmdAway:               parseAway,
+	CmdUpdateControlNames: parseUpdateControlNames,
+	CmdKick:               parseKick,
+	CmdResetRoom:          parseResetRoom,
+	CmdSetLatency:         parseSetLatency,
+	CmdBroadcast:          parseBroadcast,
+}
+
+// ListRequest asks for the room overview, answered with List.
+type ListRequest struct{}
+
+func (*ListRequest) Command() string { return CmdList }
+func (*ListRequest) encode(*encoder) {}
+
+func parseListRequest(d *decoder) Message {
+	d.expect(1)
+	return &ListRequest{}
+}
+
+// Join enters a room: JOIN\n<room id>\n<name>\n<p1>\n<p2>\n<p3>\n<p4>\n<achievements>.
+// Like the original server, the parser ignores any lines after the
+// achievements.
+type Join struct {
+	RoomID int
+	Name   string
+	// Controls holds the names of the four local controls.
+	Controls     [4]string
+	Achievements string
+}
+
+func (*Join) Command() string { return CmdJoin }
+
+func (m *Join) encode(e *encoder) {
+	e.int(m.RoomID)
+	e.line("name", m.Name)
+	for _, c := range m.Controls {
+		e.line("controls", c)
+	}
+	e.line("achievements", m.Achievements)
+}
+
+func parseJoin(d *decoder) Message {
+	if !d.atLeast(8) {
+		return nil
+	}
+	return &Join{
+		RoomID:       d.int("room id", d.lines[1]),
+		Name:         d.text("name", d.lines[2]),
+		Controls:     d.controls(d.lines[3:7]),
+		Achievements: d.text("achievements", d.lines[7]),
+	}
+}
+
+// Leave exits a room: LEAVE\n<room id>. The server answers with LeftRoom.
+type Leave struct {
+	RoomID int
+}
+
+func (*Leave) Command() string     { return CmdLeave }
+func (m *Leave) encode(e *encoder) { e.int(m.RoomID) }
+
+func parseLeave(d *decoder) Message {
+	if !d.expect(2) {
+		return nil
+	}
+	return &Leave{RoomID: d.int("room id", d.lines[1])}
+}
+
+// Start starts the game in the sender's room.
+type Start struct{}
+
+func (*Start) Command() string { return CmdStart }
+func (*Start) encode(*encoder) {}
+
+func parseStart(d *decoder) Message {
+	d.expect(1)
+	return &Start{}
+}
+
+// ChatRequest is a chat line typed by a player: CHAT\n<text>. The server
+// broadcasts it as Chat.
+type ChatRequest struct {
+	Text string
+}
+
+func (*ChatRequest) Command() string     { return CmdChat }
+func (m *ChatRequest) encode(e *encoder) { e.line("text", m.Text) }
+
+func parseChatRequest(d *decoder) Message {
+	if !d.expect(2) {
+		return nil
+	}
+	return &ChatRequest{Text: d.text("text", d.lines[1])}
+}
+
+// ChangeLatency sets the latency of the sender's room: CHANGE_LATENCY\n<n>.
+type ChangeLatency struct {
+	Latency int
+}
+
+func (*ChangeLatency) Command() string     { return CmdChangeLatency }
+func (m *ChangeLatency) encode(e *encoder) { e.int(m.Latency) }
+
+func parseChangeLatency(d *decoder) Message {
+	if !d.expect(2) {
+		return nil
+	}
+	return &ChangeLatency{Latency: d.int("latency", d.lines[1])}
+}
+
+// UpdateAchievements replaces the sender's achievement list. The player id
+// line is optional; PlayerID is 0 when it was left out.
+type UpdateAchievements struct {
+	PlayerID     int
+	Achievements string
+}
+
+func (*UpdateAchievements) Command() string { return CmdUpdateAchievements }
+
+func (m *UpdateAchievements) encode(e *encoder) {
+	if m.PlayerID != 0 {
+		e.int(m.PlayerID)
+	}
+	e.line("achievements", m.Achievements)
+}
+
+func parseUpdateAchievements(d *decoder) Message {
+	switch len(d.lines) {
+	case 2:
+		return &UpdateAchievements{Achievements: d.text("achievements", d.lines[1])}
+	case 3:
+		return &UpdateAchievements{
+			PlayerID:     d.int("player id", d.lines[1]),
+			Achievements: d.text("achievements", d.lines[2]),
+		}
+	}
+	d.expect(3)
+	return nil
+}
+
+// Admin turns the connection into an admin connection. Token is empty when
+// the secret is passed some other way.
+type Admin struct {
+	Token string
+}
+
+func (*Admin) Command() string { return CmdAdmin }
+
+func (m *Admin) encode(e *encoder) {
+	if m.Token != "" {
+		e.line("token", m.Token)
+	}
+}
+
+func parseAdmin(d *decoder) Message {
+	switch len(d.lines) {
+	case 1:
+		return &Admin{}
+	case 2:
+		return &Admin{Token: d.text("token", strings.TrimSpace(d.lines[1]))}
+	}
+	d.expect(2)
+	return nil
+}
+
+// Auth carries the admin secret when it was not sent with Admin.
+type Auth struct {
+	Token string
+}
+
+func (*Auth) Command() string     { return CmdAuth }
+func (m *Auth) encode(e *encoder) { e.line("token", m.Token) }
+
+func parseAuth(d *decoder) Message {
+	if !d.expect(2) {
+		return nil
+	}
+	return &Auth{Token: d.text("token", strings.TrimSpace(d.lines[1]))}
+}
+
+// The commands below are sent by admin connections after authenticating.
+// Like STATS, their fields are separated by spaces. Each is answered with OK
+// or ERROR.
+
+// Kick disconnects a player: KICK <player id>.
+type Kick struct {
+	PlayerID int
+}
+
+func (*Kick) Command() string     { return CmdKick }
+func (m *Kick) encode(e *encoder) { e.b = fmt.Appendf(e.b, " %d", m.PlayerID) }
+
+func parseKick(d *decoder) Message {
+	f := d.fields(2)
+	if f == nil {
+		return nil
+	}
+	return &Kick{PlayerID: d.int("player id", f[1])}
+}
+
+// ResetRoom takes every player out of a room: RESET_ROOM <room id>.
+type ResetRoom struct {
+	RoomID int
+}
+
+func (*ResetRoom) Command() string     { return CmdResetRoom }
+func (m *ResetRoom) encode(e *encoder) { e.b = fmt.Appendf(e.b, " %d", m.RoomID) }
+
+func parseResetRoom(d *decoder) Message {
+	f := d.fields(2)
+	if f == nil {
+		return nil
+	}
+	return &ResetRoom{RoomID: d.int("room id", f[1])}
+}
+
+// SetLatency sets the latency of a room: SET_LATENCY <room id> <latency>.
+type SetLatency struct {
+	RoomID  int
+	Latency int
+}
+
+func (*SetLatency) Command() string { return CmdSetLatency }
+
+func (m *SetLatency) encode(e *encoder) {
+	e.b = fmt.Appendf(e.b, " %d %d", m.RoomID, m.Latency)
+}
+
+func parseSetLatency(d *decoder) Message {
+	f := d.fields(3)
+	if f == nil {
+		return nil
+	}
+	return &SetLatency{RoomID: d.int("room id", f[1]), Latency: d.int("latency", f[2])}
+}
+
+// Broadcast sends a CHAT from the server to every room: BROADCAST <text>.
+type Broadcast struct {
+	Text string
+}
+
+func (*Broadcast) Command() string     { return CmdBroadcast }
+func (m *Broadcast) encode(e *encoder) { e.word("text", m.Text) }
+
+func parseBroadcast(d *decoder) Message {
+	f := d.fields(2)
+	if f == nil {
+		return nil
+	}
+	return &Broadcast{Text: d.text("text", f[1])}
+}
@@ -0,0 +1,278 @@
+// Package protocol implements the messages exchanged between Little Fighter 2
+// clients and the room server, as described in docs/network-protocol.md.
+//
+// Every message is a single WebSocket text frame of newline separated fields
+// whose first line is the command. Lists inside LIST and PLAYER_LIST are
+// introduced by Separator.
+//
+// The CHAT and LIST commands look different depending on who sends them, so
+// messages are parsed with ParseClient or ParseServer according to the
+// direction they travel in.
+package protocol
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Commands, in the order they usually appear in a session.
+const (
+	CmdYourID             = "YOUR_ID"
+	CmdList               = "LIST"
+	CmdJoin               = "JOIN"
+	CmdPlayerList         = "PLAYER_LIST"
+	CmdChangeLatency      = "CHANGE_LATENCY"
+	CmdLeave              = "LEAVE"
+	CmdLeftRoom           = "LEFT_ROOM"
+	CmdStart              = "START"
+	CmdRoomNowStarted     = "ROOM_NOW_STARTED"
+	CmdChat               = "CHAT"
+	CmdFrame              = "FRAME"
+	CmdAway               = "AWAY"
+	CmdUpdateControlNames = "UPDATE_CONTROL_NAMES"
+	CmdUpdateAchievements = "UPDATE_ACHIEVEMENTS"
+	CmdAdmin              = "ADMIN"
+	CmdAuth               = "AUTH"
+	CmdRoomList           = "ROOM_LIST"
+	CmdStats              = "STATS"
+	CmdKick               = "KICK"
+	CmdResetRoom          = "RESET_ROOM"
+	CmdSetLatency         = "SET_LATENCY"
+	CmdBroadcast          = "BROADCAST"
+	CmdOK                 = "OK"
+	CmdError              = "ERROR"
+)
+
+// Separator introduces every entry of the LIST and PLAYER_LIST messages.
+const Separator = "¶\n"
+
+var (
+	// ErrEmpty is returned for a message without any content.
+	ErrEmpty = errors.New("empty message")
+	// ErrUnknownCommand is returned for a command not valid in the direction
+	// the message was parsed for.
+	ErrUnknownCommand = errors.New("unknown command")
+	// ErrFieldCount is returned when a message has too few or too many lines.
+	ErrFieldCount = errors.New("wrong number of fields")
+	// ErrInvalidField is returned when a field has the wrong format, or when
+	// a field to be encoded contains a line break.
+	ErrInvalidField = errors.New("invalid field")
+)
+
+// Error describes a message that could not be parsed or encoded. Err is one
+// of the Err values of this package, possibly wrapped with more detail.
+type Error struct {
+	Command string
+	// Field names the offending field, if any.
+	Field string
+	Err   error
+}
+
+func (e *Error) Error() string {
+	msg := "protocol: "
+	if e.Command != "" {
+		msg += e.Command + ": "
+	}
+	if e.Field != "" {
+		msg += e.Field + ": "
+	}
+	return msg + e.Err.Error()
+}
+
+func (e *Error) Unwrap() error { return e.Err }
+
+// Message is implemented by every message type of this package.
+type Message interface {
+	// Command returns the first line of the message.
+	Command() string
+	encode(e *encoder)
+}
+
+// Encode returns the wire form of m. It fails when a text field contains a
+// line break, which would corrupt the message.
+func Encode(m Message) ([]byte, error) {
+	e := &encoder{command: m.Command()}
+	e.b = append(e.b, m.Command()...)
+	m.encode(e)
+	if e.err != nil {
+		return nil, e.err
+	}
+	return e.b, nil
+}
+
+// ParseClient parses a message sent by a game or admin client to the server.
+func ParseClient(data []byte) (Message, error) {
+	return parse(data, clientMessages)
+}
+
+// ParseServer parses a message sent by the server to a client.
+func ParseServer(data []byte) (Message, error) {
+	return parse(data, serverMessages)
+}
+
+// Command returns the command of a raw message without parsing the rest.
+func Command(data []byte) string {
+	s := string(data)
+	if i := strings.IndexAny(s, "\n "); i >= 0 {
+		return s[:i]
+	}
+	return s
+}
+
+type parser func(d *decoder) Message
+
+func parse(data []byte, parsers map[string]parser) (Message, error) {
+	if len(data) == 0 {
+		return nil, &Error{Err: ErrEmpty}
+	}
+	command := Command(data)
+	p, ok := parsers[command]
+	if !ok {
+		return nil, &Error{Command: command, Err: ErrUnknownCommand}
+	}
+	d := &decoder{command: command, data: string(data), lines: strings.Split(string(data), "\n")}
+	m := p(d)
+	if d.err != nil {
+		return nil, d.err
+	}
+	return m, nil
+}
+
+// decoder reads the lines of a message, remembering the first error.
+type decoder struct {
+	command string
+	data    string
+	lines   []string
+	err     error
+}
+
+func (d *decoder) fail(field string, err error) {
+	if d.err == nil {
+		d.err = &Error{Command: d.command, Field: field, Err: err}
+	}
+}
+
+// expect checks that the message has exactly n lines, including the command.
+func (d *decoder) expect(n int) bool {
+	if len(d.lines) != n {
+		d.fail("", fmt.Errorf("%w: got %d lines, want %d", ErrFieldCount, len(d.lines), n))
+		return false
+	}
+	return true
+}
+
+// atLeast checks that the message has n lines or more, including the
+// command. Lines after the first n are ignored.
+func (d *decoder) atLeast(n int) bool {
+	if len(d.lines) < n {
+		d.fail("", fmt.Errorf("%w: got %d lines, want at least %d", ErrFieldCount, len(d.lines), n))
+		return false
+	}
+	return true
+}
+
+// fields splits a single line message whose fields are separated by spaces,
+// like STATS, into exactly n fields. The last field takes the rest of the
+// line, spaces included.
+func (d *decoder) fields(n int) []string {
+	if len(d.lines) != 1 {
+		d.fail("", fmt.Errorf("%w: got %d lines, want 1", ErrFieldCount, len(d.lines)))
+		return nil
+	}
+	f := strings.SplitN(d.data, " ", n)
+	if len(f) != n {
+		d.fail("", fmt.Errorf("%w: got %d fields, want %d", ErrFieldCount, len(f), n))
+		return nil
+	}
+	return f
+}
+
+// text returns s, failing if it could not be encoded again. Anything parsed
+// can then be sent on, e.g. a player's name in PLAYER_LIST.
+func (d *decoder) text(field, s string) string {
+	if !sendable(s) {
+		d.fail(field, fmt.Errorf("%w: %q cannot be sent", ErrInvalidField, s))
+	}
+	return s
+}
+
+// controls returns the four control names starting at lines[0].
+func (d *decoder) controls(lines []string) [4]string {
+	var c [4]string
+	for i := range c {
+		c[i] = d.text("controls", lines[i])
+	}
+	return c
+}
+
+func (d *decoder) int(field, s string) int {
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		d.fail(field, fmt.Errorf("%w: %q is not a number", ErrInvalidField, s))
+	}
+	return n
+}
+
+func (d *decoder) int64(field, s string) int64 {
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		d.fail(field, fmt.Errorf("%w: %q is not a number", ErrInvalidField, s))
+	}
+	return n
+}
+
+// encoder appends fields to a message, remembering the first error.
+type encoder struct {
+	command string
+	b       []byte
+	err     error
+}
+
+// line appends "\n" followed by s.
+func (e *encoder) line(field, s string) {
+	e.check(field, s)
+	e.b = append(e.b, '\n')
+	e.b = append(e.b, s...)
+}
+
+func (e *encoder) int(n int) { e.int64(int64(n)) }
+
+func (e *encoder) int64(n int64) {
+	e.b = append(e.b, '\n')
+	e.b = strconv.AppendInt(e.b, n, 10)
+}
+
+// word appends " " followed by s, for messages whose fields are separated
+// by spaces.
+func (e *encoder) word(field, s string) {
+	e.check(field, s)
+	e.b = append(e.b, ' ')
+	e.b = append(e.b, s...)
+}
+
+// separator starts a LIST or PLAYER_LIST entry.
+func (e *encoder) separator() {
+	e.b = append(e.b, '\n')
+	e.b = append(e.b, separatorLine...)
+}
+
+// check rejects text that cannot be sent.
+func (e *encoder) check(field, s string) {
+	if e.err == nil && !sendable(s) {
+		e.err = &Error{Command: e.command, Field: field, Err: fmt.Errorf("%w: %q cannot be sent", ErrInvalidField, s)}
+	}
+}
+
+// sendable reports whether s can be sent as a text field: it must not end
+// its line early, or be read as a Separator at the start of a line.
+func sendable(s string) bool {
+	return !strings.ContainsAny(s, "\r\n") && s != separatorLine
+}
+
+func (e *encoder) fail(field string, err error) {
+	if e.err == nil {
+		e.err = &Error{Command: e.command, Field: field, Err: err}
+	}
+}
@@ -0,0 +1,231 @@
+package protocol
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// clientCases holds one or more values of every message a client sends.
+var clientCases = []struct {
+	name string
+	msg  Message
+	wire string
+}{
+	{"list", &ListRequest{}, "LIST"},
+	{"join", &Join{RoomID: 2, Name: "Davis", Controls: [4]string{"p1", "", "p3", "x y"}, Achievements: "1,2,3"},
+		"JOIN\n2\nDavis\np1\n\np3\nx y\n1,2,3"},
+	{"leave", &Leave{RoomID: 5}, "LEAVE\n5"},
+	{"start", &Start{}, "START"},
+	{"chat", &ChatRequest{Text: "clicked 'Start Game'"}, "CHAT\nclicked 'Start Game'"},
+	{"change latency", &ChangeLatency{Latency: 4}, "CHANGE_LATENCY\n4"},
+	{"achievements", &UpdateAchievements{Achievements: "a;b"}, "UPDATE_ACHIEVEMENTS\na;b"},
+	{"achievements with id", &UpdateAchievements{PlayerID: 3, Achievements: ""}, "UPDATE_ACHIEVEMENTS\n3\n"},
+	{"admin", &Admin{}, "ADMIN"},
+	{"admin with token", &Admin{Token: "secret"}, "ADMIN\nsecret"},
+	{"auth", &Auth{Token: "secret"}, "AUTH\nsecret"},
+	{"frame", &Frame{PlayerID: 2, Seq: 14037, Keys: [4]int{2, 0, 0, -1}, Unknown: 0, Checksum: 34314},
+		"FRAME\n2\n14037\n2\n0\n0\n-1\n0\n34314"},
+	{"away", &Away{PlayerID: 7, Reason: "afk"}, "AWAY\n7\nafk"},
+	{"control names", &UpdateControlNames{PlayerID: 7, Controls: [4]string{"a", "b", "", "d"}},
+		"UPDATE_CONTROL_NAMES\n7\na\nb\n\nd"},
+	{"kick", &Kick{PlayerID: 12}, "KICK 12"},
+	{"reset room", &ResetRoom{RoomID: 3}, "RESET_ROOM 3"},
+	{"set latency", &SetLatency{RoomID: 3, Latency: 6}, "SET_LATENCY 3 6"},
+	{"broadcast", &Broadcast{Text: "back in 5 minutes"}, "BROADCAST back in 5 minutes"},
+}
+
+// serverCases holds one or more values of every message the server sends.
+var serverCases = []struct {
+	name string
+	msg  Message
+	wire string
+}{
+	{"your id", &YourID{PlayerID: 1, Params: DefaultYourIDParams}, "YOUR_ID\n1\n200\n-999\n-999\n-999"},
+	{"empty list", &List{}, "LIST\n\n"},
+	{"list", &List{Rooms: []RoomSummary{
+		{ID: 1, State: "LOBBY", Latency: 3, Elapsed: 5137, Players: 2, Names: []string{"X", "Y"}},
+		{ID: 2, State: "VACANT", Latency: 3, Elapsed: 5137},
+	}}, "LIST\n\n¶\nRoom\n1\nLOBBY\n3\n5137\n2\nX, Y\n¶\nRoom\n2\nVACANT\n3\n5137\n0\n\n"},
+	{"empty player list", &PlayerList{RoomID: 1, Latency: 3}, "PLAYER_LIST\n1\n3\n"},
+	{"player list", &PlayerList{RoomID: 1, Latency: 3, Players: []PlayerEntry{
+		{ID: 1, Name: "a", Controls: [4]string{"a", "b", "c", "d"}},
+		{ID: 2, Name: "b", Controls: [4]string{"", "", "", ""}, Achievements: "1"},
+	}}, "PLAYER_LIST\n1\n3\n¶\n1\na\na\nb\nc\nd\n\n¶\n2\nb\n\n\n\n\n1\n"},
+	{"left room", &LeftRoom{RoomID: 4}, "LEFT_ROOM\n4"},
+	{"room now started", &RoomNowStarted{RoomID: 4, Elapsed: 71539}, "ROOM_NOW_STARTED\n4\n71539"},
+	{"chat", &Chat{PlayerID: 0, Name: "SERVER", Text: "hello"}, "CHAT\n0\nSERVER\nhello"},
+	{"room list", &RoomList{Rooms: []RoomStatus{
+		{ID: 1, State: "STARTED", Latency: 3, Elapsed: 71539, Players: []RoomListPlayer{
+			{Name: "X", ID: 5, IP: "local"},
+			{Name: "Y, Z", ID: 6, IP: "::ffff:192.168.123.5"},
+		}},
+		{ID: 2, State: "VACANT", Latency: 3, Elapsed: 8903496},
+	}}, "ROOM_LIST\nRoom 1 [STARTED] 3 71539 {Name: X, ID: 5, IP: local}, {Name: Y, Z, ID: 6, IP: ::ffff:192.168.123.5}\n" +
+		"Room 2 [VACANT] 3 8903496 \n"},
+	{"stats", &Stats{PlayTime: 10, Players: 2}, "STATS 10 2"},
+	{"frame", &Frame{PlayerID: 2, Seq: 1, Checksum: -5}, "FRAME\n2\n1\n0\n0\n0\n0\n0\n-5"},
+	{"away", &Away{PlayerID: 2, Reason: ""}, "AWAY\n2\n"},
+	{"control names", &UpdateControlNames{PlayerID: 2, Controls: [4]string{"1", "2", "3", "4"}},
+		"UPDATE_CONTROL_NAMES\n2\n1\n2\n3\n4"},
+	{"ok", &AdminOK{For: "KICK"}, "OK KICK"},
+	{"error", &AdminError{For: "SET_LATENCY", Reason: "room 9 does not exist"}, "ERROR SET_LATENCY room 9 does not exist"},
+}
+
+func TestClientRoundTrip(t *testing.T) {
+	for _, tc := range clientCases {
+		t.Run(tc.name, func(t *testing.T) {
+			testRoundTrip(t, tc.msg, tc.wire, ParseClient)
+		})
+	}
+}
+
+func TestServerRoundTrip(t *testing.T) {
+	for _, tc := range serverCases {
+		t.Run(tc.name, func(t *testing.T) {
+			testRoundTrip(t, tc.msg, tc.wire, ParseServer)
+		})
+	}
+}
+
+func testRoundTrip(t *testing.T, m Message, wire string, parse func([]byte) (Message, error)) {
+	t.Helper()
+	data, err := Encode(m)
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	if string(data) != wire {
+		t.Errorf("Encode = %q, want %q", data, wire)
+	}
+	got, err := parse(data)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if !reflect.DeepEqual(got, m) {
+		t.Errorf("parse = %#v, want %#v", got, m)
+	}
+}
+
+func TestParseJoinIgnoresExtraLines(t *testing.T) {
+	m, err := ParseClient([]byte("JOIN\n2\nDavis\np1\np2\np3\np4\n1,2\nextra\n"))
+	if err != nil {
+		t.Fatalf("ParseClient: %v", err)
+	}
+	want := &Join{RoomID: 2, Name: "Davis", Controls: [4]string{"p1", "p2", "p3", "p4"}, Achievements: "1,2"}
+	if !reflect.DeepEqual(m, want) {
+		t.Errorf("ParseClient = %#v, want %#v", m, want)
+	}
+}
+
+func TestParseRoomListWithoutTrailingSpace(t *testing.T) {
+	m, err := ParseServer([]byte("ROOM_LIST\nRoom 1 [VACANT] 3 5137\n"))
+	if err != nil {
+		t.Fatalf("ParseServer: %v", err)
+	}
+	want := &RoomList{Rooms: []RoomStatus{{ID: 1, State: "VACANT", Latency: 3, Elapsed: 5137}}}
+	if !reflect.DeepEqual(m, want) {
+		t.Errorf("ParseServer = %#v, want %#v", m, want)
+	}
+}
+
+func TestParseRejectsUnsendableText(t *testing.T) {
+	tests := []struct {
+		name  string
+		wire  string
+		parse func([]byte) (Message, error)
+	}{
+		{"join name separator", "JOIN\n1\n¶\na\nb\nc\nd\n", ParseClient},
+		{"join name carriage return", "JOIN\n1\nname\r\na\nb\nc\nd\n", ParseClient},
+		{"join control separator", "JOIN\n1\nname\na\n¶\nc\nd\n", ParseClient},
+		{"join achievements carriage return", "JOIN\n1\nname\na\nb\nc\nd\n1\r", ParseClient},
+		{"control names separator", "UPDATE_CONTROL_NAMES\n1\na\nb\nc\n¶", ParseClient},
+		{"control names carriage return", "UPDATE_CONTROL_NAMES\n1\na\r\nb\nc\nd", ParseClient},
+		{"chat separator", "CHAT\n¶", ParseClient},
+		{"achievements separator", "UPDATE_ACHIEVEMENTS\n¶", ParseClient},
+		{"player list name", "PLAYER_LIST\n1\n3\n¶\n1\n¶\na\nb\nc\nd\n\n", ParseServer},
+		{"room list brace", "ROOM_LIST\nRoom 1 [LOBBY] 3 1 {Name: a{, ID: 1, IP: x}\n", ParseServer},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := tt.parse([]byte(tt.wire))
+			if !errors.Is(err, ErrInvalidField) {
+				t.Fatalf("parse = %#v, %v; want %v", m, err, ErrInvalidField)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		wire string
+		want error
+	}{
+		{"", ErrEmpty},
+		{"NOPE", ErrUnknownCommand},
+		{"LEAVE", ErrFieldCount},
+		{"LEAVE\n1\n2", ErrFieldCount},
+		{"LEAVE\nx", ErrInvalidField},
+		{"JOIN\n1\nname\na\nb\nc\nd", ErrFieldCount},
+		{"FRAME\n1\n2\n3\n4\n5\n6\n7", ErrFieldCount},
+		{"KICK", ErrFieldCount},
+		{"KICK 1\n", ErrFieldCount},
+		{"SET_LATENCY 1", ErrFieldCount},
+		{"SET_LATENCY 1 x", ErrInvalidField},
+	}
+	for _, tt := range tests {
+		if _, err := ParseClient([]byte(tt.wire)); !errors.Is(err, tt.want) {
+			t.Errorf("ParseClient(%q) = %v, want %v", tt.wire, err, tt.want)
+		}
+	}
+}
+
+func TestEncodeRejectsUnsendableText(t *testing.T) {
+	for _, m := range []Message{
+		&Chat{Name: "a\nb"},
+		&PlayerList{Players: []PlayerEntry{{Name: "¶"}}},
+		&RoomList{Rooms: []RoomStatus{{Players: []RoomListPlayer{{Name: "{x}"}}}}},
+		&Broadcast{Text: "a\r"},
+	} {
+		if data, err := Encode(m); !errors.Is(err, ErrInvalidField) {
+			t.Errorf("Encode(%#v) = %q, %v; want %v", m, data, err, ErrInvalidField)
+		}
+	}
+}
+
+func FuzzParseClient(f *testing.F) {
+	for _, tc := range clientCases {
+		f.Add([]byte(tc.wire))
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		checkReparse(t, data, ParseClient)
+	})
+}
+
+func FuzzParseServer(f *testing.F) {
+	for _, tc := range serverCases {
+		f.Add([]byte(tc.wire))
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		checkReparse(t, data, ParseServer)
+	})
+}
+
+// checkReparse verifies that a message that parses encodes again and parses
+// to the same value.
+func checkReparse(t *testing.T, data []byte, parse func([]byte) (Message, error)) {
+	m, err := parse(data)
+	if err != nil {
+		return
+	}
+	encoded, err := Encode(m)
+	if err != nil {
+		t.Fatalf("Encode(%#v) of %q: %v", m, data, err)
+	}
+	again, err := parse(encoded)
+	if err != nil {
+		t.Fatalf("parse(%q), encoded from %q: %v", encoded, data, err)
+	}
+	if !reflect.DeepEqual(again, m) {
+		t.Fatalf("%q parsed as %#v, re-encoded as %q, parsed as %#v", data, m, encoded, again)
+	}
+}
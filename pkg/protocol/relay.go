@@ -0,0 +1,94 @@
+package protocol
+
+// Messages a client sends to the server, which relays them unchanged to the
+// other players of the room.
+
+// Frame carries the input of one game frame:
+// FRAME\n<player id>\n<seq>\n<k1>\n<k2>\n<k3>\n<k4>\n<unknown>\n<checksum>.
+type Frame struct {
+	PlayerID int
+	Seq      int
+	// Keys holds the pressed keys of the four local controls.
+	Keys [4]int
+	// Unknown is the second to last field, whose meaning is not known.
+	Unknown int
+	// Checksum summarizes the game state, for detecting desyncs.
+	Checksum int
+}
+
+// FrameLines is the number of lines of a FRAME, including the command.
+const FrameLines = 9
+
+func (*Frame) Command() string { return CmdFrame }
+
+func (m *Frame) encode(e *encoder) {
+	e.int(m.PlayerID)
+	e.int(m.Seq)
+	for _, k := range m.Keys {
+		e.int(k)
+	}
+	e.int(m.Unknown)
+	e.int(m.Checksum)
+}
+
+func parseFrame(d *decoder) Message {
+	if !d.expect(FrameLines) {
+		return nil
+	}
+	m := &Frame{
+		PlayerID: d.int("player id", d.lines[1]),
+		Seq:      d.int("seq", d.lines[2]),
+		Unknown:  d.int("unknown", d.lines[7]),
+		Checksum: d.int("checksum", d.lines[8]),
+	}
+	for i := range m.Keys {
+		m.Keys[i] = d.int("keys", d.lines[3+i])
+	}
+	return m
+}
+
+// Away reports that a player went away: AWAY\n<player id>\n<reason>.
+type Away struct {
+	PlayerID int
+	Reason   string
+}
+
+func (*Away) Command() string { return CmdAway }
+
+func (m *Away) encode(e *encoder) {
+	e.int(m.PlayerID)
+	e.line("reason", m.Reason)
+}
+
+func parseAway(d *decoder) Message {
+	if !d.expect(3) {
+		return nil
+	}
+	return &Away{PlayerID: d.int("player id", d.lines[1]), Reason: d.text("reason", d.lines[2])}
+}
+
+// UpdateControlNames renames the local controls of a player:
+// UPDATE_CONTROL_NAMES\n<player id>\n<p1>\n<p2>\n<p3>\n<p4>.
+type UpdateControlNames struct {
+	PlayerID int
+	Controls [4]string
+}
+
+func (*UpdateControlNames) Command() string { return CmdUpdateControlNames }
+
+func (m *UpdateControlNames) encode(e *encoder) {
+	e.int(m.PlayerID)
+	for _, c := range m.Controls {
+		e.line("controls", c)
+	}
+}
+
+func parseUpdateControlNames(d *decoder) Message {
+	if !d.expect(6) {
+		return nil
+	}
+	return &UpdateControlNames{
+		PlayerID: d.int("player id", d.lines[1]),
+		Controls: d.controls(d.lines[2:6]),
+	}
+}
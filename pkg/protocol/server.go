@@ -0,0 +1,429 @@
+package protocol
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Messages sent by the server to clients.
+
+var serverMessages = map[string]parser{
+	CmdYourID:             parseYourID,
+	CmdList:               parseList,
+	CmdPlayerList:         parsePlayerList,
+	CmdLeftRoom:           parseLeftRoom,
+	CmdRoomNowStarted:     parseRoomNowStarted,
+	CmdChat:               parseChat,
+	CmdRoomList:           parseRoomList,
+	CmdStats:              parseStats,
+	CmdFrame:              parseFrame,
+	CmdAway:               parseAway,
+	CmdUpdateControlNames: parseUpdateControlNames,
+	CmdOK:                 parseAdminOK,
+	CmdError:              parseAdminError,
+}
+
+// separatorLine is the line that starts every LIST and PLAYER_LIST entry.
+const separatorLine = "¶"
+
+// YourID is the first message of every connection and tells the client its
+// player id: YOUR_ID\n<player id>\n<p1>\n<p2>\n<p3>\n<p4>.
+type YourID struct {
+	PlayerID int
+	// Params are fixed values of unknown meaning; the original server
+	// always sends DefaultYourIDParams.
+	Params [4]int
+}
+
+// DefaultYourIDParams are the values the original server sends in YOUR_ID.
+var DefaultYourIDParams = [4]int{200, -999, -999, -999}
+
+func (*YourID) Command() string { return CmdYourID }
+
+func (m *YourID) encode(e *encoder) {
+	e.int(m.PlayerID)
+	for _, p := range m.Params {
+		e.int(p)
+	}
+}
+
+func parseYourID(d *decoder) Message {
+	if !d.expect(6) {
+		return nil
+	}
+	m := &YourID{PlayerID: d.int("player id", d.lines[1])}
+	for i := range m.Params {
+		m.Params[i] = d.int("params", d.lines[2+i])
+	}
+	return m
+}
+
+// List answers ListRequest with one entry per room.
+type List struct {
+	Rooms []RoomSummary
+}
+
+// RoomSummary is a room as listed in List.
+type RoomSummary struct {
+	ID      int
+	State   string
+	Latency int
+	// Elapsed is the time field, in milliseconds.
+	Elapsed int64
+	Players int
+	// Names are the names of the players, in seat order.
+	Names []string
+}
+
+// Lines per List entry: the separator, "Room", id, state, latency, time,
+// player count and names.
+const listEntryLines = 8
+
+func (*List) Command() string { return CmdList }
+
+func (m *List) encode(e *encoder) {
+	e.line("", "")
+	for _, r := range m.Rooms {
+		e.separator()
+		e.line("", "Room")
+		e.int(r.ID)
+		e.line("state", r.State)
+		e.int(r.Latency)
+		e.int64(r.Elapsed)
+		e.int(r.Players)
+		for _, name := range r.Names {
+			e.check("names", name)
+		}
+		e.b = append(e.b, '\n')
+		e.b = append(e.b, strings.Join(r.Names, ", ")...)
+	}
+	e.b = append(e.b, '\n')
+}
+
+func parseList(d *decoder) Message {
+	// LIST, an empty line, the entries and the empty line after the final
+	// line break.
+	n := len(d.lines) - 3
+	if n < 0 || n%listEntryLines != 0 || d.lines[1] != "" || d.lines[len(d.lines)-1] != "" {
+		d.fail("", fmt.Errorf("%w: malformed room list", ErrFieldCount))
+		return nil
+	}
+	m := &List{}
+	for i := 2; i+listEntryLines <= len(d.lines)-1; i += listEntryLines {
+		l := d.lines[i : i+listEntryLines]
+		if l[0] != separatorLine || l[1] != "Room" {
+			d.fail("rooms", fmt.Errorf("%w: entry does not start with %q", ErrInvalidField, Separator+"Room"))
+			return nil
+		}
+		r := RoomSummary{
+			ID:      d.int("room id", l[2]),
+			State:   d.text("state", l[3]),
+			Latency: d.int("latency", l[4]),
+			Elapsed: d.int64("time", l[5]),
+			Players: d.int("players", l[6]),
+		}
+		if l[7] != "" {
+			for _, name := range strings.Split(l[7], ", ") {
+				r.Names = append(r.Names, d.text("names", name))
+			}
+		}
+		m.Rooms = append(m.Rooms, r)
+	}
+	return m
+}
+
+// PlayerList describes the players of a room, in seat order. It is sent to
+// everyone in the room whenever one of them changes.
+type PlayerList struct {
+	RoomID  int
+	Latency int
+	Players []PlayerEntry
+}
+
+// PlayerEntry is a player as listed in PlayerList.
+type PlayerEntry struct {
+	ID           int
+	Name         string
+	Controls     [4]string
+	Achievements string
+}
+
+// Lines per PlayerList entry: the separator, id, name, four control names
+// and achievements.
+const playerEntryLines = 8
+
+func (*PlayerList) Command() string { return CmdPlayerList }
+
+func (m *PlayerList) encode(e *encoder) {
+	e.int(m.RoomID)
+	e.int(m.Latency)
+	for _, p := range m.Players {
+		e.separator()
+		e.int(p.ID)
+		e.line("name", p.Name)
+		for _, c := range p.Controls {
+			e.line("controls", c)
+		}
+		e.line("achievements", p.Achievements)
+	}
+	e.b = append(e.b, '\n')
+}
+
+func parsePlayerList(d *decoder) Message {
+	// PLAYER_LIST, room id, latency, the entries and the empty line after
+	// the final line break.
+	n := len(d.lines) - 4
+	if n < 0 || n%playerEntryLines != 0 || d.lines[len(d.lines)-1] != "" {
+		d.fail("", fmt.Errorf("%w: malformed player list", ErrFieldCount))
+		return nil
+	}
+	m := &PlayerList{
+		RoomID:  d.int("room id", d.lines[1]),
+		Latency: d.int("latency", d.lines[2]),
+	}
+	for i := 3; i+playerEntryLines <= len(d.lines)-1; i += playerEntryLines {
+		l := d.lines[i : i+playerEntryLines]
+		if l[0] != separatorLine {
+			d.fail("players", fmt.Errorf("%w: entry does not start with %q", ErrInvalidField, Separator))
+			return nil
+		}
+		m.Players = append(m.Players, PlayerEntry{
+			ID:           d.int("player id", l[1]),
+			Name:         d.text("name", l[2]),
+			Controls:     d.controls(l[3:7]),
+			Achievements: d.text("achievements", l[7]),
+		})
+	}
+	return m
+}
+
+// LeftRoom confirms Leave: LEFT_ROOM\n<room id>.
+type LeftRoom struct {
+	RoomID int
+}
+
+func (*LeftRoom) Command() string     { return CmdLeftRoom }
+func (m *LeftRoom) encode(e *encoder) { e.int(m.RoomID) }
+
+func parseLeftRoom(d *decoder) Message {
+	if !d.expect(2) {
+		return nil
+	}
+	return &LeftRoom{RoomID: d.int("room id", d.lines[1])}
+}
+
+// RoomNowStarted tells the players of a room that the game starts:
+// ROOM_NOW_STARTED\n<room id>\n<time>.
+type RoomNowStarted struct {
+	RoomID int
+	// Elapsed is the time field, in milliseconds.
+	Elapsed int64
+}
+
+func (*RoomNowStarted) Command() string { return CmdRoomNowStarted }
+
+func (m *RoomNowStarted) encode(e *encoder) {
+	e.int(m.RoomID)
+	e.int64(m.Elapsed)
+}
+
+func parseRoomNowStarted(d *decoder) Message {
+	if !d.expect(3) {
+		return nil
+	}
+	return &RoomNowStarted{RoomID: d.int("room id", d.lines[1]), Elapsed: d.int64("time", d.lines[2])}
+}
+
+// Chat is a chat line broadcast to a room: CHAT\n<player id>\n<name>\n<text>.
+type Chat struct {
+	PlayerID int
+	Name     string
+	Text     string
+}
+
+func (*Chat) Command() string { return CmdChat }
+
+func (m *Chat) encode(e *encoder) {
+	e.int(m.PlayerID)
+	e.line("name", m.Name)
+	e.line("text", m.Text)
+}
+
+func parseChat(d *decoder) Message {
+	if !d.expect(4) {
+		return nil
+	}
+	return &Chat{
+		PlayerID: d.int("player id", d.lines[1]),
+		Name:     d.text("name", d.lines[2]),
+		Text:     d.text("text", d.lines[3]),
+	}
+}
+
+// RoomList is the room overview sent periodically to admin connections, one
+// line per room:
+//
+//	Room <id> [<state>] <latency> <time> {Name: <name>, ID: <id>, IP: <ip>}, ...
+type RoomList struct {
+	Rooms []RoomStatus
+}
+
+// RoomStatus is a room as listed in RoomList.
+type RoomStatus struct {
+	ID      int
+	State   string
+	Latency int
+	// Elapsed is the time field, in milliseconds.
+	Elapsed int64
+	Players []RoomListPlayer
+}
+
+// RoomListPlayer is a player as listed in RoomList.
+type RoomListPlayer struct {
+	Name string
+	ID   int
+	IP   string
+}
+
+func (*RoomList) Command() string { return CmdRoomList }
+
+func (m *RoomList) encode(e *encoder) {
+	for _, r := range m.Rooms {
+		e.check("state", r.State)
+		// The players are preceded by a space even when there are none, as
+		// in the original server's list.
+		e.b = fmt.Appendf(e.b, "\nRoom %d [%s] %d %d ", r.ID, r.State, r.Latency, r.Elapsed)
+		for i, p := range r.Players {
+			e.check("name", p.Name)
+			e.check("ip", p.IP)
+			if strings.ContainsAny(p.Name, "{}") {
+				e.fail("name", fmt.Errorf("%w: %q cannot be sent", ErrInvalidField, p.Name))
+			}
+			if i > 0 {
+				e.b = append(e.b, ", "...)
+			}
+			e.b = fmt.Appendf(e.b, "{Name: %s, ID: %d, IP: %s}", p.Name, p.ID, p.IP)
+		}
+	}
+	e.b = append(e.b, '\n')
+}
+
+func parseRoomList(d *decoder) Message {
+	if len(d.lines) < 2 || d.lines[len(d.lines)-1] != "" {
+		d.fail("", fmt.Errorf("%w: malformed room list", ErrFieldCount))
+		return nil
+	}
+	m := &RoomList{}
+	for _, line := range d.lines[1 : len(d.lines)-1] {
+		r, ok := parseRoomStatus(line)
+		if !ok {
+			d.fail("rooms", fmt.Errorf("%w: malformed room %q", ErrInvalidField, line))
+			return nil
+		}
+		m.Rooms = append(m.Rooms, r)
+	}
+	return m
+}
+
+func parseRoomStatus(line string) (RoomStatus, bool) {
+	var r RoomStatus
+	f := strings.SplitN(line, " ", 6)
+	if len(f) < 5 || f[0] != "Room" || !strings.HasPrefix(f[2], "[") || !strings.HasSuffix(f[2], "]") {
+		return r, false
+	}
+	var err1, err2, err3 error
+	r.ID, err1 = strconv.Atoi(f[1])
+	r.State = f[2][1 : len(f[2])-1]
+	if !sendable(r.State) {
+		return r, false
+	}
+	r.Latency, err2 = strconv.Atoi(f[3])
+	r.Elapsed, err3 = strconv.ParseInt(f[4], 10, 64)
+	if err1 != nil || err2 != nil || err3 != nil {
+		return r, false
+	}
+	if len(f) == 5 || f[5] == "" {
+		return r, true
+	}
+	players := f[5]
+	if !strings.HasPrefix(players, "{") || !strings.HasSuffix(players, "}") {
+		return r, false
+	}
+	for _, p := range strings.Split(players[1:len(players)-1], "}, {") {
+		// Names may contain ", ", so the fields are taken from the right.
+		ipAt := strings.LastIndex(p, ", IP: ")
+		idAt := strings.LastIndex(p, ", ID: ")
+		if !strings.HasPrefix(p, "Name: ") || idAt < 0 || ipAt < idAt {
+			return r, false
+		}
+		id, err := strconv.Atoi(p[idAt+len(", ID: ") : ipAt])
+		name, ip := p[len("Name: "):idAt], p[ipAt+len(", IP: "):]
+		if err != nil || strings.ContainsAny(name, "{}") || !sendable(name) || !sendable(ip) {
+			return r, false
+		}
+		r.Players = append(r.Players, RoomListPlayer{Name: name, ID: id, IP: ip})
+	}
+	return r, true
+}
+
+// Stats is sent periodically to admin connections: STATS <play time> <players>.
+// How the original server computes the numbers is not known.
+type Stats struct {
+	PlayTime int
+	Players  int
+}
+
+func (*Stats) Command() string { return CmdStats }
+
+func (m *Stats) encode(e *encoder) {
+	e.b = fmt.Appendf(e.b, " %d %d", m.PlayTime, m.Players)
+}
+
+func parseStats(d *decoder) Message {
+	f := strings.Split(d.data, " ")
+	if len(f) != 3 {
+		d.fail("", fmt.Errorf("%w: got %d fields, want 3", ErrFieldCount, len(f)))
+		return nil
+	}
+	return &Stats{PlayTime: d.int("play time", f[1]), Players: d.int("players", f[2])}
+}
+
+// AdminOK answers an admin command that succeeded: OK <command>.
+type AdminOK struct {
+	// For is the command answered.
+	For string
+}
+
+func (*AdminOK) Command() string     { return CmdOK }
+func (m *AdminOK) encode(e *encoder) { e.word("command", m.For) }
+
+func parseAdminOK(d *decoder) Message {
+	f := d.fields(2)
+	if f == nil {
+		return nil
+	}
+	return &AdminOK{For: d.text("command", f[1])}
+}
+
+// AdminError answers an admin command that failed: ERROR <command> <reason>.
+type AdminError struct {
+	// For is the command answered.
+	For    string
+	Reason string
+}
+
+func (*AdminError) Command() string { return CmdError }
+
+func (m *AdminError) encode(e *encoder) {
+	e.word("command", m.For)
+	e.word("reason", m.Reason)
+}
+
+func parseAdminError(d *decoder) Message {
+	f := d.fields(3)
+	if f == nil {
+		return nil
+	}
+	return &AdminError{For: d.text("command", f[1]), Reason: d.text("reason", f[2])}
+}